@@ -0,0 +1,9 @@
+# List every labeled branch of the migration scripts
+data "alembic_branches" "all" {}
+
+# Upgrade each branch to its own head
+resource "alembic_upgrade" "branch" {
+  for_each = { for branch in data.alembic_branches.all.branches : branch.label => branch }
+
+  target = "${each.key}@head"
+}
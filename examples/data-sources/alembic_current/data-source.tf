@@ -0,0 +1,20 @@
+# Read the revision a database is currently at
+data "alembic_current" "db" {
+  // Environment variables passed to the alembic command
+  environment = {
+    DATABASE_URL = locals.database_connection_string
+  }
+
+  // If you need a proxy like cloudsql or an SSH port forward for connecting,
+  // you can do that here.
+  // proxy_command = ["cloud_sql_proxy", "-instances=..."]
+  // proxy_sleep   = "30s"
+}
+
+# Assert the schema version of a database this stack does not manage
+check "schema_version" {
+  assert {
+    condition     = data.alembic_current.db.is_up_to_date
+    error_message = "The database has pending migrations."
+  }
+}
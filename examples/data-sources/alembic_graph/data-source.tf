@@ -0,0 +1,10 @@
+# Render the revision graph, highlighting where the database is and where
+# the upgrade is going
+data "alembic_graph" "migrations" {
+  current = [for revision in data.alembic_current.db.revisions : revision.revision]
+  target  = alembic_upgrade.db-upgrade.target
+}
+
+output "migration_graph" {
+  value = data.alembic_graph.migrations.mermaid // or .dot for Graphviz
+}
@@ -0,0 +1,17 @@
+# List the head revisions of the migration scripts
+data "alembic_heads" "all" {
+  // You can override the alembic command on a per-data-source basis
+  // alembic = ["custom", "alembic", "command"]
+}
+
+# Catch accidental multiple heads before attempting an upgrade
+resource "alembic_upgrade" "db-upgrade" {
+  target = "head"
+
+  lifecycle {
+    precondition {
+      condition     = !data.alembic_heads.all.multiple_heads
+      error_message = "Migration scripts have multiple heads: ${join(", ", data.alembic_heads.all.revisions)}"
+    }
+  }
+}
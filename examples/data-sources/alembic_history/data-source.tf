@@ -0,0 +1,9 @@
+# List the revisions between the database's current revision and head
+data "alembic_history" "pending" {
+  from = data.alembic_current.db.revisions[0].revision // Inclusive (default: base)
+  to   = "head"                                        // (default: heads)
+}
+
+output "release_notes" {
+  value = [for revision in data.alembic_history.pending.revisions : "${revision.revision}: ${revision.message}"]
+}
@@ -0,0 +1,16 @@
+# Resolve the current head of the migration scripts to a concrete revision
+data "alembic_revision" "head" {
+  revision = "head" // Any alembic revision specification
+
+  // You can override the alembic command on a per-data-source basis
+  // alembic = ["custom", "alembic", "command"]
+}
+
+# Pin an upgrade to the resolved revision ID
+resource "alembic_upgrade" "db-upgrade" {
+  target = data.alembic_revision.head.revision_id
+}
+
+output "migration_message" {
+  value = data.alembic_revision.head.message
+}
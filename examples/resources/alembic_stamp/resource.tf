@@ -1,7 +1,7 @@
 # Stamp a database; this does not perform and upgrade and only
 # marks the database as if it had been upgrade (see 'alembic stamp --help')
 resource "alembic_stamp" "db-stamp" {
-  target = "head"          // The target revision ID or special "head" value
+  target = "head"          // Any revision specification (e.g. "heads" or "feature@head")
   tag    = "my-custom-tag" // Custom tag passed with the --tag option
 
   // Environment variables passed to the alembic command
@@ -18,6 +18,9 @@ resource "alembic_stamp" "db-stamp" {
   // You can override the alembic command on a per-resource basis
   // alembic = ["custom", "alembic", "command"]
 
+  // The alembic project, e.g. one service of a monorepo
+  // project_root = "${path.module}/services/billing"
+
   // If you need a proxy like cloudsql or an SSH port forward for connecting,
   // you can do that here.
   // proxy_command = ["cloud_sql_proxy", "-instances=..."]
@@ -26,4 +29,10 @@ resource "alembic_stamp" "db-stamp" {
   // This is set to 5s by default, and will happen whenever a connection
   // needs to be made to the database.
   // proxy_sleep = "30s"
+
+  // Or, rather than sleeping, wait until the proxy accepts connections
+  // proxy_ready = {
+  //   tcp     = "127.0.0.1:5432"
+  //   timeout = "1m"
+  // }
 }
@@ -0,0 +1,322 @@
+package alembic
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/hashicorp/terraform-plugin-framework/types"
+)
+
+// projectArchiveData is the 'project_archive' provider setting
+type projectArchiveData struct {
+	Path         types.String `tfsdk:"path"`
+	SHA256       types.String `tfsdk:"sha256"`
+	Subdirectory types.String `tfsdk:"subdirectory"`
+}
+
+// extractProjectArchive verifies the checksum of a .tar.gz or .zip archive, if one is given,
+// and extracts it into a new private temporary directory. The caller is responsible for
+// removing the directory.
+func extractProjectArchive(archive string, checksum string) (string, error) {
+	file, err := os.Open(archive)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	// The archive is hashed before anything is extracted from it
+	if checksum != "" {
+		hash := sha256.New()
+		if _, err := io.Copy(hash, file); err != nil {
+			return "", err
+		}
+		if actual := hex.EncodeToString(hash.Sum(nil)); !strings.EqualFold(actual, checksum) {
+			return "", fmt.Errorf("checksum mismatch: expected sha256 '%v', found '%v'", checksum, actual)
+		}
+		if _, err := file.Seek(0, io.SeekStart); err != nil {
+			return "", err
+		}
+	}
+
+	// MkdirTemp creates the directory readable by the current user only
+	dir, err := os.MkdirTemp("", "terraform-provider-alembic-project-")
+	if err != nil {
+		return "", err
+	}
+
+	switch {
+	case strings.HasSuffix(archive, ".tar.gz") || strings.HasSuffix(archive, ".tgz"):
+		err = extractTarGz(file, dir)
+	case strings.HasSuffix(archive, ".zip"):
+		err = extractZip(file, dir)
+	default:
+		err = fmt.Errorf("unsupported archive format, expected a .tar.gz, .tgz or .zip file")
+	}
+
+	if err != nil {
+		os.RemoveAll(dir)
+		return "", err
+	}
+
+	return dir, nil
+}
+
+func extractTarGz(file *os.File, dir string) error {
+	compressed, err := gzip.NewReader(file)
+	if err != nil {
+		return err
+	}
+	defer compressed.Close()
+
+	return extractTar(compressed, dir)
+}
+
+func extractTar(input io.Reader, dir string) error {
+	reader := tar.NewReader(input)
+	for {
+		header, err := reader.Next()
+		if err == io.EOF {
+			return checkArchiveLinks(dir)
+		} else if err != nil {
+			return err
+		}
+
+		target, err := archiveEntryPath(dir, header.Name)
+		if err != nil {
+			return err
+		}
+
+		switch header.Typeflag {
+		case tar.TypeDir:
+			err = os.MkdirAll(target, 0700)
+		case tar.TypeReg:
+			err = writeArchiveFile(target, header.FileInfo().Mode(), reader)
+		case tar.TypeSymlink:
+			err = writeArchiveSymlink(dir, target, header.Linkname)
+		case tar.TypeXGlobalHeader:
+			// e.g. the commit ID recorded by 'git archive'
+			continue
+		default:
+			// Devices, hard links and the like have no place in a migration project
+			err = fmt.Errorf("unsupported entry type for '%v'", header.Name)
+		}
+
+		if err != nil {
+			return err
+		}
+	}
+}
+
+func extractZip(file *os.File, dir string) error {
+	info, err := file.Stat()
+	if err != nil {
+		return err
+	}
+
+	reader, err := zip.NewReader(file, info.Size())
+	if err != nil {
+		return err
+	}
+
+	for _, entry := range reader.File {
+		target, err := archiveEntryPath(dir, entry.Name)
+		if err != nil {
+			return err
+		}
+
+		mode := entry.Mode()
+		switch {
+		case mode.IsDir():
+			err = os.MkdirAll(target, 0700)
+		case mode&os.ModeSymlink != 0:
+			err = extractZipSymlink(dir, target, entry)
+		case mode.IsRegular():
+			err = extractZipFile(target, entry)
+		default:
+			err = fmt.Errorf("unsupported entry type for '%v'", entry.Name)
+		}
+
+		if err != nil {
+			return err
+		}
+	}
+
+	return checkArchiveLinks(dir)
+}
+
+func extractZipFile(target string, entry *zip.File) error {
+	contents, err := entry.Open()
+	if err != nil {
+		return err
+	}
+	defer contents.Close()
+
+	return writeArchiveFile(target, entry.Mode(), contents)
+}
+
+func extractZipSymlink(dir string, target string, entry *zip.File) error {
+	contents, err := entry.Open()
+	if err != nil {
+		return err
+	}
+	defer contents.Close()
+
+	link, err := io.ReadAll(contents)
+	if err != nil {
+		return err
+	}
+
+	return writeArchiveSymlink(dir, target, string(link))
+}
+
+// archiveEntryPath returns where an archive entry is extracted to, refusing entries which
+// would end up outside of the extraction directory, either by name or through symbolic
+// links extracted before them
+func archiveEntryPath(dir string, name string) (string, error) {
+	target, err := confinedPath(dir, name, "the archive root")
+	if err != nil {
+		return "", fmt.Errorf("archive entry %v", err)
+	}
+	return target, nil
+}
+
+// confinedPath joins a slash separated relative path to a root directory (e.g. an extracted
+// archive or a git checkout), refusing paths which end up outside of it, either by name or
+// through symbolic links. The root is described as 'root' in errors.
+func confinedPath(dir string, name string, root string) (string, error) {
+	cleaned := filepath.Clean(filepath.FromSlash(name))
+	if filepath.IsAbs(cleaned) || !withinDir(".", cleaned) {
+		return "", fmt.Errorf("'%v' is outside of %v", name, root)
+	}
+
+	target := filepath.Join(dir, cleaned)
+	if _, err := resolveArchiveDir(dir, filepath.Dir(target)); err != nil {
+		return "", fmt.Errorf("'%v' is outside of %v: %v", name, root, err)
+	}
+
+	return target, nil
+}
+
+// resolveArchiveDir resolves the symbolic links of a directory within the extraction
+// directory, which does not need to exist yet, and refuses directories which actually
+// are outside of it
+func resolveArchiveDir(root string, dir string) (string, error) {
+	real_root, err := filepath.EvalSymlinks(root)
+	if err != nil {
+		return "", err
+	}
+
+	// Directories which do not exist yet cannot be symbolic links
+	existing := dir
+	var missing []string
+	for {
+		if _, err := os.Lstat(existing); err == nil {
+			break
+		} else if !os.IsNotExist(err) {
+			return "", err
+		}
+		missing = append([]string{filepath.Base(existing)}, missing...)
+		existing = filepath.Dir(existing)
+	}
+
+	resolved, err := filepath.EvalSymlinks(existing)
+	if err != nil {
+		return "", err
+	}
+
+	if !withinDir(real_root, resolved) {
+		return "", fmt.Errorf("'%v' resolves to '%v'", dir, resolved)
+	}
+
+	return filepath.Join(append([]string{resolved}, missing...)...), nil
+}
+
+// checkArchiveLinks refuses extracted trees containing symbolic links which resolve to
+// outside of the extraction directory, e.g. by combining several links which each look
+// harmless on their own. Dangling links are left alone.
+func checkArchiveLinks(root string) error {
+	real_root, err := filepath.EvalSymlinks(root)
+	if err != nil {
+		return err
+	}
+
+	return filepath.WalkDir(root, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil || entry.Type()&fs.ModeSymlink == 0 {
+			return err
+		}
+
+		resolved, err := filepath.EvalSymlinks(path)
+		if err != nil {
+			return nil
+		}
+
+		if !withinDir(real_root, resolved) {
+			return fmt.Errorf("symbolic link '%v' resolves to '%v', outside of the archive root", path, resolved)
+		}
+		return nil
+	})
+}
+
+// withinDir reports whether a path is the directory itself or lies beneath it
+func withinDir(dir string, path string) bool {
+	relative, err := filepath.Rel(dir, path)
+	return err == nil && relative != ".." && !strings.HasPrefix(relative, ".."+string(filepath.Separator))
+}
+
+// writeArchiveFile creates a regular file, keeping its executable bit. Existing files
+// (including symbolic links) are never written through.
+func writeArchiveFile(target string, mode os.FileMode, contents io.Reader) error {
+	if err := os.MkdirAll(filepath.Dir(target), 0700); err != nil {
+		return err
+	}
+
+	output, err := os.OpenFile(target, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600|(mode.Perm()&0100))
+	if err != nil {
+		return err
+	}
+
+	if _, err := io.Copy(output, contents); err != nil {
+		output.Close()
+		return err
+	}
+
+	return output.Close()
+}
+
+// writeArchiveSymlink creates a symbolic link, as long as it points within the archive.
+// Relative links are resolved from where their directory actually is.
+func writeArchiveSymlink(dir string, target string, link string) error {
+	parent, err := resolveArchiveDir(dir, filepath.Dir(target))
+	if err != nil {
+		return err
+	}
+
+	real_root, err := filepath.EvalSymlinks(dir)
+	if err != nil {
+		return err
+	}
+
+	resolved := link
+	if !filepath.IsAbs(link) {
+		resolved = filepath.Join(parent, link)
+	}
+
+	if !withinDir(real_root, resolved) {
+		return fmt.Errorf("symbolic link '%v' points outside of the archive root", link)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(target), 0700); err != nil {
+		return err
+	}
+
+	return os.Symlink(link, target)
+}
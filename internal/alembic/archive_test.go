@@ -0,0 +1,241 @@
+package alembic
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// archiveEntry is a file, directory or symbolic link of a test archive
+type archiveEntry struct {
+	name     string
+	body     string
+	link     string
+	dir      bool
+	typeflag byte
+}
+
+func buildTar(t *testing.T, entries []archiveEntry) *bytes.Buffer {
+	t.Helper()
+
+	var buffer bytes.Buffer
+	writer := tar.NewWriter(&buffer)
+	for _, entry := range entries {
+		header := &tar.Header{Name: entry.name, Mode: 0644}
+		switch {
+		case entry.typeflag == tar.TypeXGlobalHeader:
+			header = &tar.Header{Name: entry.name, Typeflag: entry.typeflag, PAXRecords: map[string]string{"comment": "0123abcd"}}
+		case entry.typeflag != 0:
+			header.Typeflag = entry.typeflag
+		case entry.link != "":
+			header.Typeflag = tar.TypeSymlink
+			header.Linkname = entry.link
+		case entry.dir:
+			header.Typeflag = tar.TypeDir
+			header.Mode = 0755
+		default:
+			header.Typeflag = tar.TypeReg
+			header.Size = int64(len(entry.body))
+		}
+		if err := writer.WriteHeader(header); err != nil {
+			t.Fatal(err)
+		}
+		if header.Typeflag == tar.TypeReg {
+			if _, err := writer.Write([]byte(entry.body)); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	if err := writer.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	return &buffer
+}
+
+// extractionRoot returns an empty extraction directory within a parent directory, which
+// is where escaping entries would end up
+func extractionRoot(t *testing.T) (string, string) {
+	parent := t.TempDir()
+	root := filepath.Join(parent, "root")
+	if err := os.Mkdir(root, 0700); err != nil {
+		t.Fatal(err)
+	}
+	return parent, root
+}
+
+func TestExtractTar(t *testing.T) {
+	tests := []struct {
+		name    string
+		entries []archiveEntry
+		err     string
+		escaped string
+	}{
+		{
+			name: "project",
+			entries: []archiveEntry{
+				{name: "app/", dir: true},
+				{name: "app/alembic.ini", body: "[alembic]\n"},
+				{name: "app/migrations/env.py", body: "import os\n"},
+				{name: "app/env.py", link: "migrations/env.py"},
+				{name: "pax_global_header", typeflag: tar.TypeXGlobalHeader},
+			},
+		},
+		{
+			name:    "parent directory",
+			entries: []archiveEntry{{name: "../escaped.txt", body: "x"}},
+			err:     "outside of the archive root",
+			escaped: "escaped.txt",
+		},
+		{
+			name:    "absolute link",
+			entries: []archiveEntry{{name: "passwd", link: "/etc/passwd"}},
+			err:     "points outside of the archive root",
+		},
+		{
+			name:    "link to the parent directory",
+			entries: []archiveEntry{{name: "up", link: "../"}},
+			err:     "points outside of the archive root",
+		},
+		{
+			name: "chain of links",
+			entries: []archiveEntry{
+				{name: "d1", link: "."},
+				{name: "d1/d2", link: ".."},
+				{name: "d2/escaped.txt", body: "x"},
+			},
+			err:     "outside of the archive root",
+			escaped: "escaped.txt",
+		},
+		{
+			name: "link through a link",
+			entries: []archiveEntry{
+				{name: "d1", link: "."},
+				{name: "up", link: "d1/.."},
+			},
+			err: "outside of the archive root",
+		},
+		{
+			name:    "hard link",
+			entries: []archiveEntry{{name: "hard", typeflag: tar.TypeLink}},
+			err:     "unsupported entry type",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			parent, root := extractionRoot(t)
+
+			err := extractTar(buildTar(t, test.entries), root)
+			if test.err == "" && err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			} else if test.err != "" && (err == nil || !strings.Contains(err.Error(), test.err)) {
+				t.Fatalf("expected error containing %q, got %v", test.err, err)
+			}
+
+			if test.escaped != "" {
+				if _, err := os.Lstat(filepath.Join(parent, test.escaped)); err == nil {
+					t.Fatalf("%v was written outside of the archive root", test.escaped)
+				}
+			}
+		})
+	}
+}
+
+func TestExtractTarProject(t *testing.T) {
+	_, root := extractionRoot(t)
+
+	err := extractTar(buildTar(t, []archiveEntry{
+		{name: "app/alembic.ini", body: "[alembic]\n"},
+		{name: "app/env.py", link: "migrations/env.py"},
+		{name: "app/migrations/env.py", body: "import os\n"},
+	}), root)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	contents, err := os.ReadFile(filepath.Join(root, "app", "env.py"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(contents) != "import os\n" {
+		t.Fatalf("unexpected contents %q", contents)
+	}
+}
+
+func TestExtractProjectArchive(t *testing.T) {
+	dir := t.TempDir()
+
+	var compressed bytes.Buffer
+	writer := gzip.NewWriter(&compressed)
+	writer.Write(buildTar(t, []archiveEntry{{name: "app-1.0/alembic.ini", body: "[alembic]\n"}}).Bytes())
+	writer.Close()
+
+	archive := filepath.Join(dir, "app.tar.gz")
+	if err := os.WriteFile(archive, compressed.Bytes(), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := extractProjectArchive(archive, strings.Repeat("0", 64)); err == nil || !strings.Contains(err.Error(), "checksum mismatch") {
+		t.Fatalf("expected a checksum mismatch, got %v", err)
+	}
+
+	extracted, err := extractProjectArchive(archive, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(extracted)
+
+	if _, err := os.Stat(filepath.Join(extracted, "app-1.0", "alembic.ini")); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestExtractZipSymlinkChain(t *testing.T) {
+	parent, root := extractionRoot(t)
+
+	var buffer bytes.Buffer
+	writer := zip.NewWriter(&buffer)
+	for _, entry := range []archiveEntry{
+		{name: "d1", link: "."},
+		{name: "d1/d2", link: ".."},
+		{name: "d2/escaped.txt", body: "x"},
+	} {
+		header := &zip.FileHeader{Name: entry.name, Method: zip.Store}
+		body := entry.body
+		if entry.link != "" {
+			header.SetMode(os.ModeSymlink | 0777)
+			body = entry.link
+		} else {
+			header.SetMode(0644)
+		}
+		file, err := writer.CreateHeader(header)
+		if err != nil {
+			t.Fatal(err)
+		}
+		file.Write([]byte(body))
+	}
+	writer.Close()
+
+	archive := filepath.Join(t.TempDir(), "app.zip")
+	if err := os.WriteFile(archive, buffer.Bytes(), 0600); err != nil {
+		t.Fatal(err)
+	}
+	file, err := os.Open(archive)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	if err := extractZip(file, root); err == nil {
+		t.Fatal("expected the archive to be refused")
+	}
+	if _, err := os.Lstat(filepath.Join(parent, "escaped.txt")); err == nil {
+		t.Fatal("escaped.txt was written outside of the archive root")
+	}
+}
@@ -0,0 +1,151 @@
+package alembic
+
+import (
+	"context"
+
+	"github.com/google/uuid"
+	"github.com/hashicorp/terraform-plugin-framework/attr"
+	"github.com/hashicorp/terraform-plugin-framework/datasource"
+	"github.com/hashicorp/terraform-plugin-framework/diag"
+	"github.com/hashicorp/terraform-plugin-framework/provider"
+	"github.com/hashicorp/terraform-plugin-framework/tfsdk"
+	"github.com/hashicorp/terraform-plugin-framework/types"
+)
+
+// Object type of each element in the alembic_branches 'branches' attribute
+var branchType = types.ObjectType{
+	AttrTypes: map[string]attr.Type{
+		"label": types.StringType,
+		"base":  types.StringType,
+		"head":  types.StringType,
+		"heads": types.ListType{ElemType: types.StringType},
+	},
+}
+
+type dataBranchesType struct{}
+
+func (d dataBranchesType) GetSchema(_ context.Context) (tfsdk.Schema, diag.Diagnostics) {
+	return tfsdk.Schema{
+		Description: "List every labeled branch of the migration scripts",
+		Attributes: map[string]tfsdk.Attribute{
+			"environment": {
+				Type:        types.MapType{ElemType: types.StringType},
+				Description: "Environment variables to set when running the alembic command.",
+				Optional:    true,
+				Sensitive:   true,
+			},
+			"alembic": {
+				Type:        types.ListType{ElemType: types.StringType},
+				Description: "Command used to execute alembic. By default, this is taken from the provider configuration.",
+				Optional:    true,
+			},
+			"config": {
+				Type:        types.StringType,
+				Description: "Name of the alembic configuration file within the project root. By default, this is taken from the provider configuration.",
+				Optional:    true,
+			},
+			"section": {
+				Type:        types.StringType,
+				Description: "The section within the configuration file to use for Alembic config. By default, this is taken from the provider configuration.",
+				Optional:    true,
+			},
+			"branches": {
+				Description: "The branches, ordered by label.",
+				Computed:    true,
+				Attributes: tfsdk.ListNestedAttributes(map[string]tfsdk.Attribute{
+					"label": {
+						Type:        types.StringType,
+						Description: "The branch label.",
+						Computed:    true,
+					},
+					"base": {
+						Type:        types.StringType,
+						Description: "The first revision carrying the branch label.",
+						Computed:    true,
+					},
+					"head": {
+						Type:        types.StringType,
+						Description: "The head revision of the branch. Empty if the branch has more than one head.",
+						Computed:    true,
+					},
+					"heads": {
+						Type:        types.ListType{ElemType: types.StringType},
+						Description: "Every head revision carrying the branch label.",
+						Computed:    true,
+					},
+				}),
+			},
+			"id": {
+				Type:        types.StringType,
+				Description: "A unique ID for this data source used internally by terraform. Not intended for external use.",
+				Computed:    true,
+			},
+		},
+	}, nil
+}
+
+func (d dataBranchesType) NewDataSource(_ context.Context, p provider.Provider) (datasource.DataSource, diag.Diagnostics) {
+	return dataBranches{
+		p: *(p.(*alembicProvider)),
+	}, nil
+}
+
+type dataBranches struct {
+	p alembicProvider
+}
+
+type dataBranchesData struct {
+	Environment types.Map    `tfsdk:"environment"`
+	Alembic     types.List   `tfsdk:"alembic"`
+	Config      types.String `tfsdk:"config"`
+	Section     types.String `tfsdk:"section"`
+	Branches    types.List   `tfsdk:"branches"`
+	ID          types.String `tfsdk:"id"`
+}
+
+func (d dataBranches) Read(ctx context.Context, req datasource.ReadRequest, resp *datasource.ReadResponse) {
+
+	var data dataBranchesData
+
+	// Retrieve the configuration
+	diags := req.Config.Get(ctx, &data)
+	resp.Diagnostics.Append(diags...)
+	if resp.Diagnostics.HasError() {
+		return
+	}
+
+	p, diags := d.p.withConfig(ctx, data.Alembic, data.Config, data.Section)
+	resp.Diagnostics.Append(diags...)
+	if resp.Diagnostics.HasError() {
+		return
+	}
+
+	graph, diags := readRevisionGraph(ctx, p, data.Alembic, data.Environment)
+	resp.Diagnostics.Append(diags...)
+	if resp.Diagnostics.HasError() {
+		return
+	}
+
+	branches := types.List{ElemType: branchType, Elems: []attr.Value{}}
+	for _, branch := range graph.branches() {
+		var head string
+		if len(branch.Heads) == 1 {
+			head = branch.Heads[0]
+		}
+
+		branches.Elems = append(branches.Elems, types.Object{
+			AttrTypes: branchType.AttrTypes,
+			Attrs: map[string]attr.Value{
+				"label": types.String{Value: branch.Label},
+				"base":  types.String{Value: branch.Base},
+				"head":  types.String{Value: head},
+				"heads": stringList(branch.Heads),
+			},
+		})
+	}
+
+	data.Branches = branches
+	data.ID = types.String{Value: uuid.New().String()}
+
+	resp.Diagnostics.Append(resp.State.Set(ctx, &data)...)
+}
@@ -0,0 +1,91 @@
+package alembic
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/hashicorp/terraform-plugin-framework/types"
+)
+
+func TestDataBranchesRead(t *testing.T) {
+	type testBranch struct {
+		Label string   `tfsdk:"label"`
+		Base  string   `tfsdk:"base"`
+		Head  string   `tfsdk:"head"`
+		Heads []string `tfsdk:"heads"`
+	}
+
+	tests := []struct {
+		name     string
+		p        alembicProvider
+		branches []testBranch
+	}{
+		{
+			name: "labelled branches",
+			p:    testProvider(t),
+			branches: []testBranch{
+				{Label: "feature", Base: featureBase, Head: featureHead, Heads: []string{featureHead}},
+				{Label: "main", Base: mainBase, Head: mainHead, Heads: []string{mainHead}},
+			},
+		},
+		{
+			name:     "no revisions",
+			p:        emptyProject(t),
+			branches: []testBranch{},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var data dataBranchesData
+			if diags := readDataSource(t, dataBranchesType{}, test.p, nil, &data); diags.HasError() {
+				t.Fatal(diags)
+			}
+
+			if data.Branches.Null || data.ID.Value == "" {
+				t.Fatal("expected known branches and id")
+			}
+
+			branches := []testBranch{}
+			if diags := data.Branches.ElementsAs(context.Background(), &branches, false); diags.HasError() {
+				t.Fatal(diags)
+			}
+			if !reflect.DeepEqual(branches, test.branches) {
+				t.Fatalf("expected branches %v, got %v", test.branches, branches)
+			}
+		})
+	}
+}
+
+// A branch whose head has been split has no single head
+func TestDataBranchesReadSplitBranch(t *testing.T) {
+	p := testProvider(t)
+
+	split := "9c1f2e3d4b5a"
+	contents := "\"\"\"split orders\n\nRevision ID: " + split + "\n\"\"\"\nrevision = \"" + split + "\"\ndown_revision = \"" + mainThird + "\"\n"
+	if err := os.WriteFile(filepath.Join(p.project_root, "migrations", "versions", split+".py"), []byte(contents), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	var data dataBranchesData
+	if diags := readDataSource(t, dataBranchesType{}, p, nil, &data); diags.HasError() {
+		t.Fatal(diags)
+	}
+
+	var main types.Object
+	for _, elem := range data.Branches.Elems {
+		if object := elem.(types.Object); object.Attrs["label"].(types.String).Value == "main" {
+			main = object
+		}
+	}
+
+	if head := main.Attrs["head"].(types.String).Value; head != "" {
+		t.Fatalf("expected no single head, got %q", head)
+	}
+	if heads := listStrings(t, main.Attrs["heads"].(types.List)); len(heads) != 2 {
+		t.Fatalf("expected two heads, got %v", heads)
+	}
+}
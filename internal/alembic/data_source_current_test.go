@@ -0,0 +1,110 @@
+package alembic
+
+import (
+	"context"
+	"os"
+	"reflect"
+	"testing"
+
+	"github.com/hashicorp/terraform-plugin-framework/types"
+	"github.com/hashicorp/terraform-plugin-go/tftypes"
+)
+
+func TestIsUpToDate(t *testing.T) {
+	tests := []struct {
+		name     string
+		current  []string
+		heads    []string
+		expected bool
+	}{
+		{name: "at the head", current: []string{mainHead}, heads: []string{mainHead}, expected: true},
+		{name: "at every head", current: []string{featureHead, mainHead}, heads: []string{mainHead, featureHead}, expected: true},
+		{name: "duplicate rows", current: []string{mainHead, mainHead}, heads: []string{mainHead}, expected: true},
+		{name: "behind", current: []string{mainThird}, heads: []string{mainHead}, expected: false},
+		{name: "missing a head", current: []string{mainHead, mainHead}, heads: []string{mainHead, featureHead}, expected: false},
+		{name: "extra revision", current: []string{mainHead, featureBase}, heads: []string{mainHead}, expected: false},
+		{name: "not stamped", current: []string{}, heads: []string{mainHead}, expected: false},
+		{name: "no migrations", current: []string{}, heads: []string{}, expected: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if result := isUpToDate(test.current, test.heads); result != test.expected {
+				t.Fatalf("expected %v, got %v", test.expected, result)
+			}
+		})
+	}
+}
+
+func TestDataCurrentRead(t *testing.T) {
+	tests := []struct {
+		name          string
+		current       []string
+		revisions     map[string]bool
+		is_up_to_date bool
+	}{
+		{
+			name:          "stamped",
+			current:       []string{mainHead, featureHead},
+			revisions:     map[string]bool{mainHead: true, featureHead: true},
+			is_up_to_date: true,
+		},
+		{
+			name:      "not stamped",
+			revisions: map[string]bool{},
+		},
+		{
+			name:      "behind head",
+			current:   []string{mainThird, featureHead},
+			revisions: map[string]bool{mainThird: false, featureHead: true},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			executable, output := fakeAlembic(t)
+			p := testProvider(t)
+			p.alembic = []string{executable}
+
+			var data dataCurrentData
+			diags := readDataSource(t, dataCurrentType{}, p, map[string]tftypes.Value{
+				"database_url": tftypes.NewValue(tftypes.String, testDatabase(t, p.project_root, "app.db", test.current...)),
+			}, &data)
+			if diags.HasError() {
+				t.Fatal(diags)
+			}
+
+			// The version table is read directly, so alembic never runs
+			if _, err := os.Stat(output); !os.IsNotExist(err) {
+				t.Fatalf("expected alembic not to run, got %v", err)
+			}
+
+			var revisions []struct {
+				Revision types.String `tfsdk:"revision"`
+				IsHead   types.Bool   `tfsdk:"is_head"`
+			}
+			if diags := data.Revisions.ElementsAs(context.Background(), &revisions, false); diags.HasError() {
+				t.Fatal(diags)
+			}
+
+			// The version table has no particular order
+			result := map[string]bool{}
+			for _, revision := range revisions {
+				result[revision.Revision.Value] = revision.IsHead.Value
+			}
+			if len(revisions) != len(result) || !reflect.DeepEqual(result, test.revisions) {
+				t.Fatalf("expected revisions %v, got %+v", test.revisions, revisions)
+			}
+
+			if heads := listStrings(t, data.Heads); !reflect.DeepEqual(heads, []string{mainHead, featureHead}) {
+				t.Fatalf("expected heads %v, got %v", []string{mainHead, featureHead}, heads)
+			}
+			if data.IsUpToDate.Value != test.is_up_to_date {
+				t.Fatalf("expected is_up_to_date %v, got %v", test.is_up_to_date, data.IsUpToDate.Value)
+			}
+			if data.ID.Value == "" {
+				t.Fatal("expected an ID")
+			}
+		})
+	}
+}
@@ -0,0 +1,155 @@
+package alembic
+
+import (
+	"context"
+
+	"github.com/google/uuid"
+	"github.com/hashicorp/terraform-plugin-framework/datasource"
+	"github.com/hashicorp/terraform-plugin-framework/diag"
+	"github.com/hashicorp/terraform-plugin-framework/path"
+	"github.com/hashicorp/terraform-plugin-framework/provider"
+	"github.com/hashicorp/terraform-plugin-framework/tfsdk"
+	"github.com/hashicorp/terraform-plugin-framework/types"
+)
+
+type dataGraphType struct{}
+
+func (d dataGraphType) GetSchema(_ context.Context) (tfsdk.Schema, diag.Diagnostics) {
+	return tfsdk.Schema{
+		Description: "Render the revision graph of the migration scripts as Graphviz DOT and Mermaid diagrams",
+		Attributes: map[string]tfsdk.Attribute{
+			"current": {
+				Type:        types.ListType{ElemType: types.StringType},
+				Description: "Revisions to highlight as the current revisions of the database (e.g. from the alembic_current data source).",
+				Optional:    true,
+			},
+			"target": {
+				Type:        types.StringType,
+				Description: "Revision specification to highlight as the target revision (e.g. the target of an alembic_upgrade resource). Relative revisions such as '+1' are taken relative to 'current'.",
+				Optional:    true,
+			},
+			"environment": {
+				Type:        types.MapType{ElemType: types.StringType},
+				Description: "Environment variables to set when running the alembic command.",
+				Optional:    true,
+				Sensitive:   true,
+			},
+			"alembic": {
+				Type:        types.ListType{ElemType: types.StringType},
+				Description: "Command used to execute alembic. By default, this is taken from the provider configuration.",
+				Optional:    true,
+			},
+			"config": {
+				Type:        types.StringType,
+				Description: "Name of the alembic configuration file within the project root. By default, this is taken from the provider configuration.",
+				Optional:    true,
+			},
+			"section": {
+				Type:        types.StringType,
+				Description: "The section within the configuration file to use for Alembic config. By default, this is taken from the provider configuration.",
+				Optional:    true,
+			},
+			"dot": {
+				Type:        types.StringType,
+				Description: "The revision graph in the Graphviz DOT language.",
+				Computed:    true,
+			},
+			"mermaid": {
+				Type:        types.StringType,
+				Description: "The revision graph as a Mermaid flowchart.",
+				Computed:    true,
+			},
+			"id": {
+				Type:        types.StringType,
+				Description: "A unique ID for this data source used internally by terraform. Not intended for external use.",
+				Computed:    true,
+			},
+		},
+	}, nil
+}
+
+func (d dataGraphType) NewDataSource(_ context.Context, p provider.Provider) (datasource.DataSource, diag.Diagnostics) {
+	return dataGraph{
+		p: *(p.(*alembicProvider)),
+	}, nil
+}
+
+type dataGraph struct {
+	p alembicProvider
+}
+
+type dataGraphData struct {
+	Current     types.List   `tfsdk:"current"`
+	Target      types.String `tfsdk:"target"`
+	Environment types.Map    `tfsdk:"environment"`
+	Alembic     types.List   `tfsdk:"alembic"`
+	Config      types.String `tfsdk:"config"`
+	Section     types.String `tfsdk:"section"`
+	Dot         types.String `tfsdk:"dot"`
+	Mermaid     types.String `tfsdk:"mermaid"`
+	ID          types.String `tfsdk:"id"`
+}
+
+func (d dataGraph) Read(ctx context.Context, req datasource.ReadRequest, resp *datasource.ReadResponse) {
+
+	var data dataGraphData
+	var current []string
+
+	// Retrieve the configuration
+	diags := req.Config.Get(ctx, &data)
+	resp.Diagnostics.Append(diags...)
+	if resp.Diagnostics.HasError() {
+		return
+	}
+
+	p, diags := d.p.withConfig(ctx, data.Alembic, data.Config, data.Section)
+	resp.Diagnostics.Append(diags...)
+	if resp.Diagnostics.HasError() {
+		return
+	}
+
+	graph, diags := readRevisionGraph(ctx, p, data.Alembic, data.Environment)
+	resp.Diagnostics.Append(diags...)
+	if resp.Diagnostics.HasError() {
+		return
+	}
+
+	highlights := graphHighlights{
+		Current: make(map[string]bool),
+		Target:  make(map[string]bool),
+	}
+
+	if !data.Current.Null {
+		resp.Diagnostics.Append(data.Current.ElementsAs(ctx, &current, false)...)
+		if resp.Diagnostics.HasError() {
+			return
+		}
+
+		for _, revision := range current {
+			if _, ok := graph.revisions[revision]; !ok {
+				resp.Diagnostics.AddAttributeError(path.Root("current"), "unknown revision", "Revision '"+revision+"' is not present in the migration scripts.")
+				return
+			}
+			highlights.Current[revision] = true
+		}
+	}
+
+	if !data.Target.Null {
+		// Relative targets such as "+1" are taken relative to the current revisions
+		targets, err := graph.resolve(data.Target.Value, current)
+		if err != nil {
+			resp.Diagnostics.AddAttributeError(path.Root("target"), "unable to resolve target", err.Error())
+			return
+		}
+
+		for _, target := range targets {
+			highlights.Target[target] = true
+		}
+	}
+
+	data.Dot = types.String{Value: graph.dot(highlights)}
+	data.Mermaid = types.String{Value: graph.mermaid(highlights)}
+	data.ID = types.String{Value: uuid.New().String()}
+
+	resp.Diagnostics.Append(resp.State.Set(ctx, &data)...)
+}
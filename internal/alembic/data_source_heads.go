@@ -0,0 +1,156 @@
+package alembic
+
+import (
+	"context"
+	"strings"
+
+	"github.com/hashicorp/terraform-plugin-framework/attr"
+	"github.com/hashicorp/terraform-plugin-framework/datasource"
+	"github.com/hashicorp/terraform-plugin-framework/diag"
+	"github.com/hashicorp/terraform-plugin-framework/provider"
+	"github.com/hashicorp/terraform-plugin-framework/tfsdk"
+	"github.com/hashicorp/terraform-plugin-framework/types"
+)
+
+// Object type of each element in the alembic_heads 'heads' attribute
+var headType = types.ObjectType{
+	AttrTypes: map[string]attr.Type{
+		"revision":      types.StringType,
+		"branch_labels": types.ListType{ElemType: types.StringType},
+		"message":       types.StringType,
+	},
+}
+
+type dataHeadsType struct{}
+
+func (d dataHeadsType) GetSchema(_ context.Context) (tfsdk.Schema, diag.Diagnostics) {
+	return tfsdk.Schema{
+		Description: "List every head revision of the migration scripts",
+		Attributes: map[string]tfsdk.Attribute{
+			"environment": {
+				Type:        types.MapType{ElemType: types.StringType},
+				Description: "Environment variables to set when running the alembic command.",
+				Optional:    true,
+				Sensitive:   true,
+			},
+			"alembic": {
+				Type:        types.ListType{ElemType: types.StringType},
+				Description: "Command used to execute alembic. By default, this is taken from the provider configuration.",
+				Optional:    true,
+			},
+			"config": {
+				Type:        types.StringType,
+				Description: "Name of the alembic configuration file within the project root. By default, this is taken from the provider configuration.",
+				Optional:    true,
+			},
+			"section": {
+				Type:        types.StringType,
+				Description: "The section within the configuration file to use for Alembic config. By default, this is taken from the provider configuration.",
+				Optional:    true,
+			},
+			"heads": {
+				Description: "The head revisions, i.e. revisions which are not revised by any other revision.",
+				Computed:    true,
+				Attributes: tfsdk.ListNestedAttributes(map[string]tfsdk.Attribute{
+					"revision": {
+						Type:        types.StringType,
+						Description: "Revision identifier.",
+						Computed:    true,
+					},
+					"branch_labels": {
+						Type:        types.ListType{ElemType: types.StringType},
+						Description: "Branch labels applied to this revision.",
+						Computed:    true,
+					},
+					"message": {
+						Type:        types.StringType,
+						Description: "The message given when the revision was created.",
+						Computed:    true,
+					},
+				}),
+			},
+			"revisions": {
+				Type:        types.ListType{ElemType: types.StringType},
+				Description: "The identifiers of the head revisions.",
+				Computed:    true,
+			},
+			"multiple_heads": {
+				Type:        types.BoolType,
+				Description: "Whether the migration scripts have more than one head.",
+				Computed:    true,
+			},
+			"id": {
+				Type:        types.StringType,
+				Description: "The head revision identifiers joined by commas.",
+				Computed:    true,
+			},
+		},
+	}, nil
+}
+
+func (d dataHeadsType) NewDataSource(_ context.Context, p provider.Provider) (datasource.DataSource, diag.Diagnostics) {
+	return dataHeads{
+		p: *(p.(*alembicProvider)),
+	}, nil
+}
+
+type dataHeads struct {
+	p alembicProvider
+}
+
+type dataHeadsData struct {
+	Environment   types.Map    `tfsdk:"environment"`
+	Alembic       types.List   `tfsdk:"alembic"`
+	Config        types.String `tfsdk:"config"`
+	Section       types.String `tfsdk:"section"`
+	Heads         types.List   `tfsdk:"heads"`
+	Revisions     types.List   `tfsdk:"revisions"`
+	MultipleHeads types.Bool   `tfsdk:"multiple_heads"`
+	ID            types.String `tfsdk:"id"`
+}
+
+func (d dataHeads) Read(ctx context.Context, req datasource.ReadRequest, resp *datasource.ReadResponse) {
+
+	var data dataHeadsData
+
+	// Retrieve the configuration
+	diags := req.Config.Get(ctx, &data)
+	resp.Diagnostics.Append(diags...)
+	if resp.Diagnostics.HasError() {
+		return
+	}
+
+	p, diags := d.p.withConfig(ctx, data.Alembic, data.Config, data.Section)
+	resp.Diagnostics.Append(diags...)
+	if resp.Diagnostics.HasError() {
+		return
+	}
+
+	graph, diags := readRevisionGraph(ctx, p, data.Alembic, data.Environment)
+	resp.Diagnostics.Append(diags...)
+	if resp.Diagnostics.HasError() {
+		return
+	}
+
+	ids := graph.heads()
+
+	heads := types.List{ElemType: headType, Elems: []attr.Value{}}
+	for _, id := range ids {
+		revision := graph.revisions[id]
+		heads.Elems = append(heads.Elems, types.Object{
+			AttrTypes: headType.AttrTypes,
+			Attrs: map[string]attr.Value{
+				"revision":      types.String{Value: revision.Revision},
+				"branch_labels": stringList(revision.BranchLabels),
+				"message":       types.String{Value: revision.Message},
+			},
+		})
+	}
+
+	data.Heads = heads
+	data.Revisions = stringList(ids)
+	data.MultipleHeads = types.Bool{Value: len(ids) > 1}
+	data.ID = types.String{Value: strings.Join(ids, ",")}
+
+	resp.Diagnostics.Append(resp.State.Set(ctx, &data)...)
+}
@@ -0,0 +1,86 @@
+package alembic
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+type testHead struct {
+	Revision     string   `tfsdk:"revision"`
+	BranchLabels []string `tfsdk:"branch_labels"`
+	Message      string   `tfsdk:"message"`
+}
+
+// emptyProject returns a provider for an alembic project without any revisions
+func emptyProject(t *testing.T) alembicProvider {
+	t.Helper()
+
+	p := testProvider(t)
+	if err := os.RemoveAll(filepath.Join(p.project_root, "migrations", "versions")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.MkdirAll(filepath.Join(p.project_root, "migrations", "versions"), 0700); err != nil {
+		t.Fatal(err)
+	}
+
+	return p
+}
+
+func TestDataHeadsRead(t *testing.T) {
+	tests := []struct {
+		name     string
+		p        alembicProvider
+		heads    []testHead
+		multiple bool
+	}{
+		{
+			name: "several heads",
+			p:    testProvider(t),
+			heads: []testHead{
+				{Revision: mainHead, BranchLabels: []string{"main"}, Message: "index orders"},
+				{Revision: featureHead, BranchLabels: []string{"feature"}, Message: "add flag owner"},
+			},
+			multiple: true,
+		},
+		{
+			name:  "no revisions",
+			p:     emptyProject(t),
+			heads: []testHead{},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var data dataHeadsData
+			if diags := readDataSource(t, dataHeadsType{}, test.p, nil, &data); diags.HasError() {
+				t.Fatal(diags)
+			}
+
+			heads := []testHead{}
+			if diags := data.Heads.ElementsAs(context.Background(), &heads, false); diags.HasError() {
+				t.Fatal(diags)
+			}
+			if !reflect.DeepEqual(heads, test.heads) {
+				t.Fatalf("expected heads %v, got %v", test.heads, heads)
+			}
+
+			revisions := []string{}
+			for _, head := range test.heads {
+				revisions = append(revisions, head.Revision)
+			}
+			if got := listStrings(t, data.Revisions); !reflect.DeepEqual(got, revisions) {
+				t.Fatalf("expected revisions %v, got %v", revisions, got)
+			}
+			if data.MultipleHeads.Value != test.multiple {
+				t.Fatalf("expected multiple_heads %v, got %v", test.multiple, data.MultipleHeads.Value)
+			}
+			if data.ID.Value != strings.Join(revisions, ",") {
+				t.Fatalf("unexpected id %q", data.ID.Value)
+			}
+		})
+	}
+}
@@ -0,0 +1,172 @@
+package alembic
+
+import (
+	"context"
+
+	"github.com/google/uuid"
+	"github.com/hashicorp/terraform-plugin-framework/attr"
+	"github.com/hashicorp/terraform-plugin-framework/datasource"
+	"github.com/hashicorp/terraform-plugin-framework/diag"
+	"github.com/hashicorp/terraform-plugin-framework/provider"
+	"github.com/hashicorp/terraform-plugin-framework/tfsdk"
+	"github.com/hashicorp/terraform-plugin-framework/types"
+)
+
+// Object type of each element in the alembic_history 'revisions' attribute
+var historyEntryType = types.ObjectType{
+	AttrTypes: map[string]attr.Type{
+		"revision":        types.StringType,
+		"down_revisions":  types.ListType{ElemType: types.StringType},
+		"message":         types.StringType,
+		"is_head":         types.BoolType,
+		"is_branch_point": types.BoolType,
+		"is_merge_point":  types.BoolType,
+	},
+}
+
+type dataHistoryType struct{}
+
+func (d dataHistoryType) GetSchema(_ context.Context) (tfsdk.Schema, diag.Diagnostics) {
+	return tfsdk.Schema{
+		Description: "List the revisions of the migration scripts, optionally limited to a range",
+		Attributes: map[string]tfsdk.Attribute{
+			"from": {
+				Type:        types.StringType,
+				Description: "Revision specification at which the listing starts. This revision is included in the listing, as with 'alembic history'. (default: 'base')",
+				Optional:    true,
+			},
+			"to": {
+				Type:        types.StringType,
+				Description: "Revision specification at which the listing ends. (default: 'heads')",
+				Optional:    true,
+			},
+			"environment": {
+				Type:        types.MapType{ElemType: types.StringType},
+				Description: "Environment variables to set when running the alembic command.",
+				Optional:    true,
+				Sensitive:   true,
+			},
+			"alembic": {
+				Type:        types.ListType{ElemType: types.StringType},
+				Description: "Command used to execute alembic. By default, this is taken from the provider configuration.",
+				Optional:    true,
+			},
+			"config": {
+				Type:        types.StringType,
+				Description: "Name of the alembic configuration file within the project root. By default, this is taken from the provider configuration.",
+				Optional:    true,
+			},
+			"section": {
+				Type:        types.StringType,
+				Description: "The section within the configuration file to use for Alembic config. By default, this is taken from the provider configuration.",
+				Optional:    true,
+			},
+			"revisions": {
+				Description: "The revisions within the range, newest first.",
+				Computed:    true,
+				Attributes: tfsdk.ListNestedAttributes(map[string]tfsdk.Attribute{
+					"revision": {
+						Type:        types.StringType,
+						Description: "Revision identifier.",
+						Computed:    true,
+					},
+					"down_revisions": {
+						Type:        types.ListType{ElemType: types.StringType},
+						Description: "Revision identifiers this revision directly revises.",
+						Computed:    true,
+					},
+					"message": {
+						Type:        types.StringType,
+						Description: "The message given when the revision was created.",
+						Computed:    true,
+					},
+					"is_head": {
+						Type:        types.BoolType,
+						Description: "Whether this revision is a head revision.",
+						Computed:    true,
+					},
+					"is_branch_point": {
+						Type:        types.BoolType,
+						Description: "Whether multiple revisions revise this revision.",
+						Computed:    true,
+					},
+					"is_merge_point": {
+						Type:        types.BoolType,
+						Description: "Whether this revision merges multiple revisions.",
+						Computed:    true,
+					},
+				}),
+			},
+			"id": {
+				Type:        types.StringType,
+				Description: "A unique ID for this data source used internally by terraform. Not intended for external use.",
+				Computed:    true,
+			},
+		},
+	}, nil
+}
+
+func (d dataHistoryType) NewDataSource(_ context.Context, p provider.Provider) (datasource.DataSource, diag.Diagnostics) {
+	return dataHistory{
+		p: *(p.(*alembicProvider)),
+	}, nil
+}
+
+type dataHistory struct {
+	p alembicProvider
+}
+
+type dataHistoryData struct {
+	From        types.String `tfsdk:"from"`
+	To          types.String `tfsdk:"to"`
+	Environment types.Map    `tfsdk:"environment"`
+	Alembic     types.List   `tfsdk:"alembic"`
+	Config      types.String `tfsdk:"config"`
+	Section     types.String `tfsdk:"section"`
+	Revisions   types.List   `tfsdk:"revisions"`
+	ID          types.String `tfsdk:"id"`
+}
+
+func (d dataHistory) Read(ctx context.Context, req datasource.ReadRequest, resp *datasource.ReadResponse) {
+
+	var data dataHistoryData
+
+	// Retrieve the configuration
+	diags := req.Config.Get(ctx, &data)
+	resp.Diagnostics.Append(diags...)
+	if resp.Diagnostics.HasError() {
+		return
+	}
+
+	p, diags := d.p.withConfig(ctx, data.Alembic, data.Config, data.Section)
+	resp.Diagnostics.Append(diags...)
+	if resp.Diagnostics.HasError() {
+		return
+	}
+
+	history, diags := readHistory(ctx, p, data.Alembic, data.Environment, data.From.Value, data.To.Value)
+	resp.Diagnostics.Append(diags...)
+	if resp.Diagnostics.HasError() {
+		return
+	}
+
+	revisions := types.List{ElemType: historyEntryType, Elems: []attr.Value{}}
+	for _, revision := range history {
+		revisions.Elems = append(revisions.Elems, types.Object{
+			AttrTypes: historyEntryType.AttrTypes,
+			Attrs: map[string]attr.Value{
+				"revision":        types.String{Value: revision.Revision},
+				"down_revisions":  stringList(revision.DownRevisions),
+				"message":         types.String{Value: revision.Message},
+				"is_head":         types.Bool{Value: revision.IsHead},
+				"is_branch_point": types.Bool{Value: revision.IsBranchPoint},
+				"is_merge_point":  types.Bool{Value: revision.IsMergePoint},
+			},
+		})
+	}
+
+	data.Revisions = revisions
+	data.ID = types.String{Value: uuid.New().String()}
+
+	resp.Diagnostics.Append(resp.State.Set(ctx, &data)...)
+}
@@ -0,0 +1,110 @@
+package alembic
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/hashicorp/terraform-plugin-go/tftypes"
+)
+
+func TestDataHistoryRead(t *testing.T) {
+	type testEntry struct {
+		Revision      string   `tfsdk:"revision"`
+		DownRevisions []string `tfsdk:"down_revisions"`
+		Message       string   `tfsdk:"message"`
+		IsHead        bool     `tfsdk:"is_head"`
+		IsBranchPoint bool     `tfsdk:"is_branch_point"`
+		IsMergePoint  bool     `tfsdk:"is_merge_point"`
+	}
+
+	p := testProvider(t)
+
+	tests := []struct {
+		name     string
+		from     string
+		to       string
+		expected []testEntry
+		err      string
+	}{
+		{
+			name: "one branch",
+			to:   "feature@head",
+			expected: []testEntry{
+				{Revision: featureHead, DownRevisions: []string{featureBase}, Message: "add flag owner", IsHead: true},
+				{Revision: featureBase, DownRevisions: []string{}, Message: "create flags"},
+			},
+		},
+		{
+			name: "range",
+			from: "ae10",
+			to:   "main@head-1",
+			expected: []testEntry{
+				{Revision: mainThird, DownRevisions: []string{mainSecond}, Message: "add orders"},
+				{Revision: mainSecond, DownRevisions: []string{mainBase}, Message: "add email"},
+			},
+		},
+		{
+			name:     "base",
+			to:       "base",
+			expected: []testEntry{},
+		},
+		{
+			name: "unrelated revisions",
+			from: "feature",
+			to:   "main@head",
+			err:  "is not an ancestor of revision",
+		},
+		{
+			name: "unknown revision",
+			to:   "missing",
+			err:  "no such revision or branch 'missing'",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			config := map[string]tftypes.Value{"to": tftypes.NewValue(tftypes.String, test.to)}
+			if test.from != "" {
+				config["from"] = tftypes.NewValue(tftypes.String, test.from)
+			}
+
+			var data dataHistoryData
+			diags := readDataSource(t, dataHistoryType{}, p, config, &data)
+
+			if test.err != "" {
+				if !diags.HasError() || !strings.Contains(diags[0].Summary()+": "+diags[0].Detail(), test.err) {
+					t.Fatalf("expected an error containing %q, got %v", test.err, diags)
+				}
+				return
+			}
+			if diags.HasError() {
+				t.Fatal(diags)
+			}
+
+			entries := []testEntry{}
+			if diags := data.Revisions.ElementsAs(context.Background(), &entries, false); diags.HasError() {
+				t.Fatal(diags)
+			}
+			if !reflect.DeepEqual(entries, test.expected) {
+				t.Fatalf("expected %v, got %v", test.expected, entries)
+			}
+			if data.ID.Value == "" {
+				t.Fatal("expected an id")
+			}
+		})
+	}
+}
+
+// Without from and to, the history covers every revision
+func TestDataHistoryReadEverything(t *testing.T) {
+	var data dataHistoryData
+	if diags := readDataSource(t, dataHistoryType{}, testProvider(t), nil, &data); diags.HasError() {
+		t.Fatal(diags)
+	}
+
+	if len(data.Revisions.Elems) != 6 {
+		t.Fatalf("expected 6 revisions, got %v", len(data.Revisions.Elems))
+	}
+}
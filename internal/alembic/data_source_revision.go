@@ -0,0 +1,169 @@
+package alembic
+
+import (
+	"context"
+
+	"github.com/hashicorp/terraform-plugin-framework/datasource"
+	"github.com/hashicorp/terraform-plugin-framework/diag"
+	"github.com/hashicorp/terraform-plugin-framework/provider"
+	"github.com/hashicorp/terraform-plugin-framework/tfsdk"
+	"github.com/hashicorp/terraform-plugin-framework/types"
+)
+
+type dataRevisionType struct{}
+
+func (d dataRevisionType) GetSchema(_ context.Context) (tfsdk.Schema, diag.Diagnostics) {
+	return tfsdk.Schema{
+		Description: "Resolve a revision specification (e.g. 'head', a partial revision ID or 'branch@head') to the metadata of a single revision",
+		Attributes: map[string]tfsdk.Attribute{
+			"revision": {
+				Type:        types.StringType,
+				Description: "Revision specification to resolve (e.g. 'head', 'branch@head', a partial revision ID or 'ae10+2'). Relative revisions without an anchor are taken relative to base.",
+				Required:    true,
+			},
+			"environment": {
+				Type:        types.MapType{ElemType: types.StringType},
+				Description: "Environment variables to set when running the alembic command.",
+				Optional:    true,
+				Sensitive:   true,
+			},
+			"alembic": {
+				Type:        types.ListType{ElemType: types.StringType},
+				Description: "Command used to execute alembic. By default, this is taken from the provider configuration.",
+				Optional:    true,
+			},
+			"config": {
+				Type:        types.StringType,
+				Description: "Name of the alembic configuration file within the project root. By default, this is taken from the provider configuration.",
+				Optional:    true,
+			},
+			"section": {
+				Type:        types.StringType,
+				Description: "The section within the configuration file to use for Alembic config. By default, this is taken from the provider configuration.",
+				Optional:    true,
+			},
+			"revision_id": {
+				Type:        types.StringType,
+				Description: "The full revision identifier which the specification resolved to.",
+				Computed:    true,
+			},
+			"down_revisions": {
+				Type:        types.ListType{ElemType: types.StringType},
+				Description: "Revision identifiers this revision directly revises. Empty for a base revision, and multiple for a merge point.",
+				Computed:    true,
+			},
+			"branch_labels": {
+				Type:        types.ListType{ElemType: types.StringType},
+				Description: "Branch labels applied to this revision.",
+				Computed:    true,
+			},
+			"depends_on": {
+				Type:        types.ListType{ElemType: types.StringType},
+				Description: "Additional revisions this revision depends on.",
+				Computed:    true,
+			},
+			"message": {
+				Type:        types.StringType,
+				Description: "The message given when the revision was created.",
+				Computed:    true,
+			},
+			"create_date": {
+				Type:        types.StringType,
+				Description: "The creation date recorded in the revision script.",
+				Computed:    true,
+			},
+			"path": {
+				Type:        types.StringType,
+				Description: "Path to the revision script file.",
+				Computed:    true,
+			},
+			"is_head": {
+				Type:        types.BoolType,
+				Description: "Whether this revision is a head revision.",
+				Computed:    true,
+			},
+			"is_branch_point": {
+				Type:        types.BoolType,
+				Description: "Whether multiple revisions revise this revision.",
+				Computed:    true,
+			},
+			"is_merge_point": {
+				Type:        types.BoolType,
+				Description: "Whether this revision merges multiple revisions.",
+				Computed:    true,
+			},
+			"id": {
+				Type:        types.StringType,
+				Description: "The full revision identifier. Identical to revision_id.",
+				Computed:    true,
+			},
+		},
+	}, nil
+}
+
+func (d dataRevisionType) NewDataSource(_ context.Context, p provider.Provider) (datasource.DataSource, diag.Diagnostics) {
+	return dataRevision{
+		p: *(p.(*alembicProvider)),
+	}, nil
+}
+
+type dataRevision struct {
+	p alembicProvider
+}
+
+type dataRevisionData struct {
+	Revision      string       `tfsdk:"revision"`
+	Environment   types.Map    `tfsdk:"environment"`
+	Alembic       types.List   `tfsdk:"alembic"`
+	Config        types.String `tfsdk:"config"`
+	Section       types.String `tfsdk:"section"`
+	RevisionID    types.String `tfsdk:"revision_id"`
+	DownRevisions types.List   `tfsdk:"down_revisions"`
+	BranchLabels  types.List   `tfsdk:"branch_labels"`
+	DependsOn     types.List   `tfsdk:"depends_on"`
+	Message       types.String `tfsdk:"message"`
+	CreateDate    types.String `tfsdk:"create_date"`
+	Path          types.String `tfsdk:"path"`
+	IsHead        types.Bool   `tfsdk:"is_head"`
+	IsBranchPoint types.Bool   `tfsdk:"is_branch_point"`
+	IsMergePoint  types.Bool   `tfsdk:"is_merge_point"`
+	ID            types.String `tfsdk:"id"`
+}
+
+func (d dataRevision) Read(ctx context.Context, req datasource.ReadRequest, resp *datasource.ReadResponse) {
+
+	var data dataRevisionData
+
+	// Retrieve the configuration
+	diags := req.Config.Get(ctx, &data)
+	resp.Diagnostics.Append(diags...)
+	if resp.Diagnostics.HasError() {
+		return
+	}
+
+	p, diags := d.p.withConfig(ctx, data.Alembic, data.Config, data.Section)
+	resp.Diagnostics.Append(diags...)
+	if resp.Diagnostics.HasError() {
+		return
+	}
+
+	revision, diags := resolveRevision(ctx, p, data.Alembic, data.Environment, data.Revision)
+	resp.Diagnostics.Append(diags...)
+	if resp.Diagnostics.HasError() {
+		return
+	}
+
+	data.RevisionID = types.String{Value: revision.Revision}
+	data.DownRevisions = stringList(revision.DownRevisions)
+	data.BranchLabels = stringList(revision.BranchLabels)
+	data.DependsOn = stringList(revision.DependsOn)
+	data.Message = types.String{Value: revision.Message}
+	data.CreateDate = types.String{Value: revision.CreateDate}
+	data.Path = types.String{Value: revision.Path}
+	data.IsHead = types.Bool{Value: revision.IsHead}
+	data.IsBranchPoint = types.Bool{Value: revision.IsBranchPoint}
+	data.IsMergePoint = types.Bool{Value: revision.IsMergePoint}
+	data.ID = types.String{Value: revision.Revision}
+
+	resp.Diagnostics.Append(resp.State.Set(ctx, &data)...)
+}
@@ -0,0 +1,150 @@
+package alembic
+
+import (
+	"context"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/hashicorp/terraform-plugin-framework/datasource"
+	"github.com/hashicorp/terraform-plugin-framework/diag"
+	"github.com/hashicorp/terraform-plugin-framework/provider"
+	"github.com/hashicorp/terraform-plugin-framework/tfsdk"
+	"github.com/hashicorp/terraform-plugin-framework/types"
+	"github.com/hashicorp/terraform-plugin-go/tftypes"
+)
+
+// testObject builds a value of the schema from the given attributes, leaving every other
+// attribute null
+func testObject(schema tfsdk.Schema, attributes map[string]tftypes.Value) tftypes.Value {
+	object_type := schema.TerraformType(context.Background()).(tftypes.Object)
+
+	values := map[string]tftypes.Value{}
+	for name, attribute_type := range object_type.AttributeTypes {
+		if value, ok := attributes[name]; ok {
+			values[name] = value
+		} else {
+			values[name] = tftypes.NewValue(attribute_type, nil)
+		}
+	}
+
+	return tftypes.NewValue(object_type, values)
+}
+
+// readDataSource runs the Read of a data source with the given configuration and decodes the
+// resulting state into data
+func readDataSource(t *testing.T, data_source_type provider.DataSourceType, p alembicProvider, config map[string]tftypes.Value, data interface{}) diag.Diagnostics {
+	t.Helper()
+	ctx := context.Background()
+
+	schema, diags := data_source_type.GetSchema(ctx)
+	if diags.HasError() {
+		t.Fatal(diags)
+	}
+
+	data_source, diags := data_source_type.NewDataSource(ctx, &p)
+	if diags.HasError() {
+		t.Fatal(diags)
+	}
+
+	req := datasource.ReadRequest{Config: tfsdk.Config{Schema: schema, Raw: testObject(schema, config)}}
+	resp := datasource.ReadResponse{State: tfsdk.State{Schema: schema, Raw: tftypes.NewValue(schema.TerraformType(ctx), nil)}}
+	data_source.Read(ctx, req, &resp)
+	if resp.Diagnostics.HasError() {
+		return resp.Diagnostics
+	}
+
+	resp.Diagnostics.Append(resp.State.Get(ctx, data)...)
+	return resp.Diagnostics
+}
+
+// listStrings returns the elements of a list of strings
+func listStrings(t *testing.T, list types.List) []string {
+	t.Helper()
+
+	values := []string{}
+	if diags := list.ElementsAs(context.Background(), &values, false); diags.HasError() {
+		t.Fatal(diags)
+	}
+	return values
+}
+
+// testProvider returns a configured provider for the project written by testProject
+func testProvider(t *testing.T) alembicProvider {
+	t.Helper()
+
+	return alembicProvider{
+		configured:   true,
+		project_root: testProject(t),
+		alembic:      []string{"alembic"},
+		config:       "alembic.ini",
+		section:      "alembic",
+	}
+}
+
+func TestDataRevisionRead(t *testing.T) {
+	p := testProvider(t)
+
+	tests := []struct {
+		revision  string
+		id        string
+		down      []string
+		labels    []string
+		message   string
+		is_head   bool
+		is_branch bool
+		is_merge  bool
+		err       string
+	}{
+		{revision: "main@head", id: mainHead, down: []string{mainThird}, labels: []string{"main"}, message: "index orders", is_head: true},
+		{revision: "ae10", id: mainSecond, down: []string{mainBase}, labels: []string{"main"}, message: "add email"},
+		{revision: "feature", id: featureBase, down: []string{}, labels: []string{"feature"}, message: "create flags"},
+		{revision: "heads", err: "did not resolve to a single revision"},
+		{revision: "missing", err: "failed resolving revision 'missing'"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.revision, func(t *testing.T) {
+			var data dataRevisionData
+			diags := readDataSource(t, dataRevisionType{}, p, map[string]tftypes.Value{
+				"revision": tftypes.NewValue(tftypes.String, test.revision),
+			}, &data)
+
+			if test.err != "" {
+				if !diags.HasError() || !strings.Contains(diags[0].Summary()+": "+diags[0].Detail(), test.err) {
+					t.Fatalf("expected an error containing %q, got %v", test.err, diags)
+				}
+				return
+			}
+			if diags.HasError() {
+				t.Fatal(diags)
+			}
+
+			if data.ID.Value != test.id || data.RevisionID.Value != test.id {
+				t.Fatalf("expected revision %v, got %v (id %v)", test.id, data.RevisionID.Value, data.ID.Value)
+			}
+			if down := listStrings(t, data.DownRevisions); !reflect.DeepEqual(down, test.down) {
+				t.Fatalf("expected down revisions %v, got %v", test.down, down)
+			}
+			if labels := listStrings(t, data.BranchLabels); !reflect.DeepEqual(labels, test.labels) {
+				t.Fatalf("expected branch labels %v, got %v", test.labels, labels)
+			}
+			if depends_on := listStrings(t, data.DependsOn); len(depends_on) != 0 {
+				t.Fatalf("expected no dependencies, got %v", depends_on)
+			}
+			if data.Message.Value != test.message {
+				t.Fatalf("expected message %q, got %q", test.message, data.Message.Value)
+			}
+			if data.CreateDate.Value != "2022-09-01 12:00:00.000000" {
+				t.Fatalf("unexpected create date %q", data.CreateDate.Value)
+			}
+			if data.Path.Value != filepath.Join(p.project_root, "migrations", "versions", test.id+".py") {
+				t.Fatalf("unexpected path %q", data.Path.Value)
+			}
+			if data.IsHead.Value != test.is_head || data.IsBranchPoint.Value != test.is_branch || data.IsMergePoint.Value != test.is_merge {
+				t.Fatalf("unexpected flags: head %v, branch point %v, merge point %v", data.IsHead.Value, data.IsBranchPoint.Value, data.IsMergePoint.Value)
+			}
+		})
+	}
+}
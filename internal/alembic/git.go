@@ -0,0 +1,188 @@
+package alembic
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/hashicorp/terraform-plugin-framework/types"
+	"github.com/hashicorp/terraform-plugin-log/tflog"
+)
+
+// projectGitData is the 'project_git' provider setting
+type projectGitData struct {
+	Repository   types.String `tfsdk:"repository"`
+	Ref          types.String `tfsdk:"ref"`
+	Subdirectory types.String `tfsdk:"subdirectory"`
+	CacheDir     types.String `tfsdk:"cache_dir"`
+}
+
+// gitError is a failed git command along with its output
+type gitError struct {
+	Args   []string
+	Err    error
+	Stdout string
+	Stderr string
+}
+
+func (e *gitError) Error() string {
+	return fmt.Sprintf("git %v failed: %v\n\nStandard Output:\n%v\n\nStandard Error:\n%v\n\n", strings.Join(e.Args, " "), e.Err, e.Stdout, e.Stderr)
+}
+
+// defaultGitCacheDir returns the directory repositories are cached in by default
+func defaultGitCacheDir() (string, error) {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "terraform-provider-alembic", "git"), nil
+}
+
+// checkoutProjectGit resolves a ref (branch, tag or commit) of a repository, and returns a
+// checkout of the resulting commit along with its ID. Repositories are fetched into a bare
+// mirror within the cache directory, and each commit is checked out once into a directory
+// named after it. A ref which is a full commit ID that was already checked out is used
+// without touching the repository, while branches and tags are fetched again on every run.
+func checkoutProjectGit(ctx context.Context, cache_dir string, repository string, ref string) (string, string, error) {
+	// A commit never changes, so its checkout can be used even if the repository is not
+	// reachable anymore
+	pinned := isCommitID(ref)
+	if pinned {
+		checkout := filepath.Join(cache_dir, "checkouts", strings.ToLower(ref))
+		if _, err := os.Stat(checkout); err == nil {
+			tflog.Debug(ctx, "using cached project checkout", map[string]interface{}{"commit": strings.ToLower(ref), "path": checkout})
+			return checkout, strings.ToLower(ref), nil
+		}
+	}
+
+	// Mirrors are keyed by repository, since the same commit may come from a fork
+	key := sha256.Sum256([]byte(repository))
+	mirror := filepath.Join(cache_dir, "repositories", hex.EncodeToString(key[:])+".git")
+
+	if _, err := os.Stat(mirror); os.IsNotExist(err) {
+		if err := os.MkdirAll(filepath.Dir(mirror), 0700); err != nil {
+			return "", "", err
+		}
+		if _, err := runGit(ctx, "", "init", "--bare", "--quiet", mirror); err != nil {
+			return "", "", err
+		}
+	}
+
+	// Branches and tags are always fetched again, since they move. Neither the repository
+	// nor the ref may be mistaken for an option.
+	if _, err := runGit(ctx, mirror, "fetch", "--quiet", "--force", "--prune", "--tags", "--end-of-options", repository, "+refs/heads/*:refs/heads/*"); err != nil {
+		return "", "", err
+	}
+
+	// A commit which no branch or tag reaches is only fetched when it is asked for. It is
+	// kept under a ref of its own, so that it is not pruned from the mirror.
+	output, err := runGit(ctx, mirror, "rev-parse", "--verify", "--quiet", "--end-of-options", ref+"^{commit}")
+	if err != nil && pinned {
+		refspec := fmt.Sprintf("+%v:refs/commits/%v", strings.ToLower(ref), strings.ToLower(ref))
+		if _, err := runGit(ctx, mirror, "fetch", "--quiet", "--end-of-options", repository, refspec); err != nil {
+			return "", "", err
+		}
+		output, err = runGit(ctx, mirror, "rev-parse", "--verify", "--quiet", "--end-of-options", ref+"^{commit}")
+	}
+	if err != nil {
+		return "", "", fmt.Errorf("ref '%v' was not found in '%v'", ref, repository)
+	}
+	commit := strings.TrimSpace(output)
+
+	checkout := filepath.Join(cache_dir, "checkouts", commit)
+	if _, err := os.Stat(checkout); err == nil {
+		tflog.Debug(ctx, "using cached project checkout", map[string]interface{}{"commit": commit, "path": checkout})
+		return checkout, commit, nil
+	}
+
+	if err := os.MkdirAll(filepath.Dir(checkout), 0700); err != nil {
+		return "", "", err
+	}
+
+	// The commit is exported next to its final location and then renamed, so that an
+	// interrupted run never leaves a partial checkout behind under the commit ID
+	partial, err := os.MkdirTemp(filepath.Dir(checkout), commit+".partial-")
+	if err != nil {
+		return "", "", err
+	}
+	defer os.RemoveAll(partial)
+
+	var stderr bytes.Buffer
+	reader, writer := io.Pipe()
+
+	proc := exec.CommandContext(ctx, "git", "--git-dir", mirror, "archive", "--format=tar", commit)
+	proc.Stdout = writer
+	proc.Stderr = &stderr
+
+	if err := proc.Start(); err != nil {
+		return "", "", err
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		err := proc.Wait()
+		writer.CloseWithError(err)
+		done <- err
+	}()
+
+	// Unblock git if extracting stops early, and wait for it before reading its output
+	err = extractTar(reader, partial)
+	if err == nil {
+		// The archive is padded after its last entry
+		_, err = io.Copy(io.Discard, reader)
+	}
+	reader.CloseWithError(io.ErrClosedPipe)
+	if wait_err := <-done; err == nil && wait_err != nil {
+		err = wait_err
+	}
+
+	if err != nil {
+		return "", "", &gitError{Args: proc.Args[1:], Err: err, Stderr: stderr.String()}
+	}
+
+	if err := os.Rename(partial, checkout); err != nil {
+		// Another run may have checked out the same commit in the meantime
+		if _, stat_err := os.Stat(checkout); stat_err != nil {
+			return "", "", err
+		}
+	}
+
+	return checkout, commit, nil
+}
+
+// isCommitID reports whether a ref is a full commit ID (SHA-1 or SHA-256) rather than a
+// branch, tag or abbreviated commit
+func isCommitID(ref string) bool {
+	if len(ref) != 40 && len(ref) != 64 {
+		return false
+	}
+	_, err := hex.DecodeString(ref)
+	return err == nil
+}
+
+// runGit runs a git command, optionally within a bare repository, and returns its output
+func runGit(ctx context.Context, git_dir string, args ...string) (string, error) {
+	var stdout bytes.Buffer
+	var stderr bytes.Buffer
+
+	if git_dir != "" {
+		args = append([]string{"--git-dir", git_dir}, args...)
+	}
+
+	proc := exec.CommandContext(ctx, "git", args...)
+	proc.Stdout = &stdout
+	proc.Stderr = &stderr
+
+	if err := proc.Run(); err != nil {
+		return "", &gitError{Args: args, Err: err, Stdout: stdout.String(), Stderr: stderr.String()}
+	}
+
+	return stdout.String(), nil
+}
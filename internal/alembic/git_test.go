@@ -0,0 +1,316 @@
+package alembic
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// testRepository is a work tree pushing to a local bare repository, which stands in for the
+// remote repository of a project
+type testRepository struct {
+	t      *testing.T
+	work   string
+	remote string
+}
+
+func newTestRepository(t *testing.T) *testRepository {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git is not installed")
+	}
+
+	t.Setenv("GIT_AUTHOR_NAME", "test")
+	t.Setenv("GIT_AUTHOR_EMAIL", "test@example.com")
+	t.Setenv("GIT_COMMITTER_NAME", "test")
+	t.Setenv("GIT_COMMITTER_EMAIL", "test@example.com")
+	t.Setenv("GIT_CONFIG_NOSYSTEM", "1")
+
+	dir := t.TempDir()
+	repository := &testRepository{
+		t:      t,
+		work:   filepath.Join(dir, "work"),
+		remote: filepath.Join(dir, "remote.git"),
+	}
+
+	repository.git("init", "--quiet", "--bare", repository.remote)
+	repository.git("init", "--quiet", repository.work)
+	repository.git("-C", repository.work, "symbolic-ref", "HEAD", "refs/heads/main")
+
+	return repository
+}
+
+func (r *testRepository) git(args ...string) string {
+	r.t.Helper()
+
+	output, err := runGit(context.Background(), "", args...)
+	if err != nil {
+		r.t.Fatal(err)
+	}
+	return strings.TrimSpace(output)
+}
+
+// commit commits files (or symbolic links, for values starting with "->") and pushes them,
+// returning the commit ID
+func (r *testRepository) commit(files map[string]string) string {
+	r.t.Helper()
+
+	for name, contents := range files {
+		path := filepath.Join(r.work, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+			r.t.Fatal(err)
+		}
+		os.Remove(path)
+
+		var err error
+		if link := strings.TrimPrefix(contents, "->"); link != contents {
+			err = os.Symlink(link, path)
+		} else {
+			err = os.WriteFile(path, []byte(contents), 0600)
+		}
+		if err != nil {
+			r.t.Fatal(err)
+		}
+	}
+
+	r.git("-C", r.work, "add", "--all")
+	r.git("-C", r.work, "commit", "--quiet", "--message", "commit")
+	r.git("-C", r.work, "push", "--quiet", "--tags", r.remote, "main")
+
+	return r.git("-C", r.work, "rev-parse", "HEAD")
+}
+
+func TestCheckoutProjectGit(t *testing.T) {
+	repository := newTestRepository(t)
+	cache_dir := t.TempDir()
+	ctx := context.Background()
+
+	first := repository.commit(map[string]string{"app/alembic.ini": "version 1"})
+	repository.git("-C", repository.work, "tag", "v1")
+	second := repository.commit(map[string]string{"app/alembic.ini": "version 2"})
+	repository.git("-C", repository.work, "push", "--quiet", "--tags", repository.remote)
+
+	tests := []struct {
+		ref      string
+		commit   string
+		contents string
+	}{
+		{ref: "v1", commit: first, contents: "version 1"},
+		{ref: "main", commit: second, contents: "version 2"},
+		{ref: first, commit: first, contents: "version 1"},
+		{ref: first[:10], commit: first, contents: "version 1"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.ref, func(t *testing.T) {
+			dir, commit, err := checkoutProjectGit(ctx, cache_dir, repository.remote, test.ref)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if commit != test.commit {
+				t.Fatalf("expected commit %v, got %v", test.commit, commit)
+			}
+			if dir != filepath.Join(cache_dir, "checkouts", commit) {
+				t.Fatalf("unexpected checkout directory %v", dir)
+			}
+
+			contents, err := os.ReadFile(filepath.Join(dir, "app", "alembic.ini"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(contents) != test.contents {
+				t.Fatalf("expected %q, got %q", test.contents, contents)
+			}
+		})
+	}
+}
+
+func TestCheckoutProjectGitCache(t *testing.T) {
+	repository := newTestRepository(t)
+	cache_dir := t.TempDir()
+	ctx := context.Background()
+
+	first := repository.commit(map[string]string{"alembic.ini": "version 1"})
+
+	dir, _, err := checkoutProjectGit(ctx, cache_dir, repository.remote, "main")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Checkouts are reused as long as the ref still points at the same commit
+	marker := filepath.Join(dir, "marker")
+	if err := os.WriteFile(marker, nil, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	dir, commit, err := checkoutProjectGit(ctx, cache_dir, repository.remote, "main")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if commit != first {
+		t.Fatalf("expected commit %v, got %v", first, commit)
+	}
+	if _, err := os.Stat(marker); err != nil {
+		t.Fatal("the cached checkout was not reused")
+	}
+
+	// Branches are fetched again, so new commits are picked up
+	second := repository.commit(map[string]string{"alembic.ini": "version 2"})
+
+	dir, commit, err = checkoutProjectGit(ctx, cache_dir, repository.remote, "main")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if commit != second {
+		t.Fatalf("expected commit %v, got %v", second, commit)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "marker")); err == nil {
+		t.Fatal("the checkout of the previous commit was reused")
+	}
+
+	mirrors, err := os.ReadDir(filepath.Join(cache_dir, "repositories"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(mirrors) != 1 {
+		t.Fatalf("expected a single mirror, found %v", len(mirrors))
+	}
+}
+
+func TestCheckoutProjectGitPinnedCommit(t *testing.T) {
+	repository := newTestRepository(t)
+	cache_dir := t.TempDir()
+	ctx := context.Background()
+
+	first := repository.commit(map[string]string{"alembic.ini": "version 1"})
+	second := repository.commit(map[string]string{"alembic.ini": "version 2"})
+
+	// Once no branch or tag reaches the second commit, it is only fetched when asked for
+	repository.git("-C", repository.work, "reset", "--quiet", "--hard", first)
+	repository.git("-C", repository.work, "push", "--quiet", "--force", repository.remote, "main")
+	repository.git("--git-dir", repository.remote, "config", "uploadpack.allowAnySHA1InWant", "true")
+
+	dir, commit, err := checkoutProjectGit(ctx, cache_dir, repository.remote, second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if commit != second {
+		t.Fatalf("expected commit %v, got %v", second, commit)
+	}
+
+	contents, err := os.ReadFile(filepath.Join(dir, "alembic.ini"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(contents) != "version 2" {
+		t.Fatalf("expected %q, got %q", "version 2", contents)
+	}
+
+	// Checkouts of a commit do not need the repository anymore, unlike branches
+	if err := os.RemoveAll(repository.remote); err != nil {
+		t.Fatal(err)
+	}
+
+	dir, commit, err = checkoutProjectGit(ctx, cache_dir, repository.remote, strings.ToUpper(second))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if commit != second || dir != filepath.Join(cache_dir, "checkouts", second) {
+		t.Fatalf("expected the cached checkout of %v, got %v at %v", second, commit, dir)
+	}
+
+	if _, _, err := checkoutProjectGit(ctx, cache_dir, repository.remote, "main"); err == nil {
+		t.Fatal("expected fetching the branch to fail")
+	}
+}
+
+func TestCheckoutProjectGitErrors(t *testing.T) {
+	repository := newTestRepository(t)
+	cache_dir := t.TempDir()
+	ctx := context.Background()
+
+	repository.commit(map[string]string{"alembic.ini": "version 1"})
+
+	for _, ref := range []string{"missing", "--upload-pack=touch pwned"} {
+		t.Run(ref, func(t *testing.T) {
+			_, _, err := checkoutProjectGit(ctx, cache_dir, repository.remote, ref)
+			if err == nil || !strings.Contains(err.Error(), "was not found") {
+				t.Fatalf("expected the ref not to be found, got %v", err)
+			}
+		})
+	}
+
+	t.Run("missing repository", func(t *testing.T) {
+		_, _, err := checkoutProjectGit(ctx, cache_dir, filepath.Join(t.TempDir(), "missing.git"), "main")
+		if err == nil {
+			t.Fatal("expected an error")
+		}
+	})
+}
+
+func TestCheckoutProjectGitSymlinks(t *testing.T) {
+	repository := newTestRepository(t)
+	cache_dir := t.TempDir()
+	ctx := context.Background()
+
+	// Each link looks harmless on its own, but together they point out of the checkout
+	commit := repository.commit(map[string]string{
+		"alembic.ini": "version 1",
+		"a":           "->.",
+		"b":           "->a/..",
+	})
+
+	_, _, err := checkoutProjectGit(ctx, cache_dir, repository.remote, "main")
+	if err == nil || !strings.Contains(err.Error(), "outside of the archive root") {
+		t.Fatalf("expected the checkout to be refused, got %v", err)
+	}
+
+	if _, err := os.Stat(filepath.Join(cache_dir, "checkouts", commit)); err == nil {
+		t.Fatal("the refused checkout was kept")
+	}
+}
+
+func TestCheckoutProjectGitSubdirectory(t *testing.T) {
+	repository := newTestRepository(t)
+	cache_dir := t.TempDir()
+	ctx := context.Background()
+
+	repository.commit(map[string]string{"app/alembic.ini": "[alembic]\n"})
+
+	dir, _, err := checkoutProjectGit(ctx, cache_dir, repository.remote, "main")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		subdirectory string
+		valid        bool
+		err          string
+	}{
+		{subdirectory: "app", valid: true},
+		{subdirectory: "app/alembic.ini", valid: false},
+		{subdirectory: "missing", valid: false},
+		{subdirectory: "../app", err: "'../app' is outside of the repository"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.subdirectory, func(t *testing.T) {
+			project_root, err := confinedPath(dir, test.subdirectory, "the repository")
+			if test.err != "" {
+				if err == nil || err.Error() != test.err {
+					t.Fatalf("expected error %q, got %v", test.err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if diags := checkProjectRoot(project_root, "alembic.ini"); diags.HasError() == test.valid {
+				t.Fatalf("expected valid=%v, got %v", test.valid, diags)
+			}
+		})
+	}
+}
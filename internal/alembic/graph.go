@@ -0,0 +1,441 @@
+package alembic
+
+import (
+	"fmt"
+	"sort"
+)
+
+// revisionGraph indexes a set of revisions and the edges between them
+type revisionGraph struct {
+	revisions map[string]*alembicRevision
+	children  map[string][]string
+	labels    map[string]string
+	order     []string
+}
+
+// revisionBranch describes the revisions carrying a single branch label
+type revisionBranch struct {
+	Label string
+	Base  string
+	Heads []string
+}
+
+// newRevisionGraph builds a graph from a list of revisions. The head, branch point and
+// merge point flags as well as branch labels are (re)computed from the graph itself, so
+// the input only needs to provide the revision IDs, down revisions and declared labels.
+func newRevisionGraph(revisions []alembicRevision) (*revisionGraph, error) {
+	g := &revisionGraph{
+		revisions: make(map[string]*alembicRevision),
+		children:  make(map[string][]string),
+		labels:    make(map[string]string),
+	}
+
+	var input []string
+	for i := range revisions {
+		revision := revisions[i]
+		if _, ok := g.revisions[revision.Revision]; ok {
+			return nil, fmt.Errorf("revision '%v' is present more than once", revision.Revision)
+		}
+		g.revisions[revision.Revision] = &revision
+		input = append(input, revision.Revision)
+	}
+
+	for _, id := range input {
+		revision := g.revisions[id]
+		for _, down := range revision.DownRevisions {
+			if _, ok := g.revisions[down]; !ok {
+				return nil, fmt.Errorf("revision '%v' revises unknown revision '%v'", id, down)
+			}
+			g.children[down] = append(g.children[down], id)
+		}
+	}
+
+	for _, revision := range g.revisions {
+		revision.IsHead = len(g.children[revision.Revision]) == 0
+		revision.IsBranchPoint = len(g.children[revision.Revision]) > 1
+		revision.IsMergePoint = len(revision.DownRevisions) > 1
+	}
+
+	order, err := g.sortRevisions(input)
+	if err != nil {
+		return nil, err
+	}
+	g.order = order
+
+	g.propagateLabels()
+
+	// Dependencies may name either a revision or a branch label
+	for _, id := range g.order {
+		for _, dependency := range g.revisions[id].DependsOn {
+			if _, ok := g.revisions[dependency]; !ok && g.lookupLabel(dependency) == "" {
+				return nil, fmt.Errorf("revision '%v' depends on unknown revision '%v'", id, dependency)
+			}
+		}
+	}
+
+	return g, nil
+}
+
+// sortRevisions orders the revisions the same way 'alembic history' does, starting at the
+// heads and only listing a revision once every revision which revises it has been listed.
+func (g *revisionGraph) sortRevisions(input []string) ([]string, error) {
+	var order []string
+	var ready []string
+
+	position := make(map[string]int)
+	remaining := make(map[string]int)
+
+	for i, id := range input {
+		position[id] = i
+		remaining[id] = len(g.children[id])
+		if remaining[id] == 0 {
+			ready = append(ready, id)
+		}
+	}
+
+	for len(ready) > 0 {
+		sort.SliceStable(ready, func(i, j int) bool { return position[ready[i]] < position[ready[j]] })
+
+		id := ready[0]
+		ready = ready[1:]
+		order = append(order, id)
+
+		for _, down := range g.revisions[id].DownRevisions {
+			remaining[down] -= 1
+			if remaining[down] == 0 {
+				ready = append(ready, down)
+			}
+		}
+	}
+
+	if len(order) != len(input) {
+		return nil, fmt.Errorf("revision graph contains a cycle")
+	}
+
+	return order, nil
+}
+
+// propagateLabels applies each branch label to every descendant of the revision declaring
+// it, and to its ancestors up to (but not including) the nearest branch or merge point.
+// This mirrors how alembic itself assigns branch labels.
+func (g *revisionGraph) propagateLabels() {
+	declared := make(map[string][]string)
+	for _, id := range g.order {
+		declared[id] = append([]string{}, g.revisions[id].BranchLabels...)
+
+		// Labels reported by alembic itself are already propagated, in which case the
+		// earliest revision carrying the label is the best guess at where it was declared.
+		for _, label := range declared[id] {
+			g.labels[label] = id
+		}
+	}
+
+	for _, id := range g.order {
+		for _, label := range declared[id] {
+			for _, descendant := range g.descendants(id) {
+				g.addLabel(descendant, label)
+			}
+
+			node := g.revisions[id]
+			for !node.IsMergePoint && len(node.DownRevisions) == 1 {
+				parent := g.revisions[node.DownRevisions[0]]
+				if parent.IsBranchPoint || parent.IsMergePoint {
+					break
+				}
+				g.addLabel(parent.Revision, label)
+				node = parent
+			}
+		}
+	}
+}
+
+func (g *revisionGraph) addLabel(id string, label string) {
+	revision := g.revisions[id]
+	for _, existing := range revision.BranchLabels {
+		if existing == label {
+			return
+		}
+	}
+	revision.BranchLabels = append(revision.BranchLabels, label)
+}
+
+// lookupLabel returns the revision declaring the given branch label, if any
+func (g *revisionGraph) lookupLabel(label string) string {
+	return g.labels[label]
+}
+
+// descendants returns the given revision and every revision which (indirectly) revises it
+func (g *revisionGraph) descendants(id string) []string {
+	var result []string
+	seen := make(map[string]bool)
+	stack := []string{id}
+
+	for len(stack) > 0 {
+		current := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		if seen[current] {
+			continue
+		}
+		seen[current] = true
+		result = append(result, current)
+		stack = append(stack, g.children[current]...)
+	}
+
+	return result
+}
+
+// ancestors returns the given revision and every revision it (indirectly) revises
+func (g *revisionGraph) ancestors(id string) []string {
+	var result []string
+	seen := make(map[string]bool)
+	stack := []string{id}
+
+	for len(stack) > 0 {
+		current := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		if seen[current] {
+			continue
+		}
+		seen[current] = true
+		result = append(result, current)
+		stack = append(stack, g.revisions[current].DownRevisions...)
+	}
+
+	return result
+}
+
+// requirements returns the revisions which must be applied before the given revision:
+// those it revises and those it depends on. A dependency on a branch label is a dependency
+// on the revision declaring the label, as in alembic.
+func (g *revisionGraph) requirements(id string) []string {
+	revision := g.revisions[id]
+	result := append([]string{}, revision.DownRevisions...)
+
+	for _, dependency := range revision.DependsOn {
+		if _, ok := g.revisions[dependency]; ok {
+			result = append(result, dependency)
+		} else if declaring := g.lookupLabel(dependency); declaring != "" {
+			result = append(result, declaring)
+		}
+	}
+
+	return result
+}
+
+// requires returns the given revision and every revision it (indirectly) requires, i.e.
+// its ancestors along with their dependencies
+func (g *revisionGraph) requires(id string) []string {
+	var result []string
+	seen := make(map[string]bool)
+	stack := []string{id}
+
+	for len(stack) > 0 {
+		current := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		if seen[current] {
+			continue
+		}
+		seen[current] = true
+		result = append(result, current)
+		stack = append(stack, g.requirements(current)...)
+	}
+
+	return result
+}
+
+// applyOrder lists a set of revisions so that each comes after every revision it
+// requires. Without dependencies, this is the reverse of the graph order.
+func (g *revisionGraph) applyOrder(ids map[string]bool) []string {
+	result := []string{}
+	visited := make(map[string]bool)
+
+	var visit func(id string)
+	visit = func(id string) {
+		if visited[id] || !ids[id] {
+			return
+		}
+		visited[id] = true
+		for _, requirement := range g.requirements(id) {
+			visit(requirement)
+		}
+		result = append(result, id)
+	}
+
+	for i := len(g.order) - 1; i >= 0; i-- {
+		visit(g.order[i])
+	}
+
+	return result
+}
+
+// applied returns the revisions a database stamped with the current revisions has applied
+func (g *revisionGraph) applied(current []string) map[string]bool {
+	applied := make(map[string]bool)
+	for _, id := range current {
+		if _, ok := g.revisions[id]; ok {
+			for _, requirement := range g.requires(id) {
+				applied[requirement] = true
+			}
+		}
+	}
+	return applied
+}
+
+// pending returns the revisions applied when upgrading from the current revisions to the
+// target revisions, in the order alembic applies them (i.e. requirements first). Upgrading
+// to a revision also applies the revisions it depends on.
+func (g *revisionGraph) pending(current []string, target []string) []string {
+	applied := g.applied(current)
+
+	needed := make(map[string]bool)
+	for _, id := range target {
+		for _, requirement := range g.requires(id) {
+			needed[requirement] = !applied[requirement]
+		}
+	}
+
+	return g.applyOrder(needed)
+}
+
+// reverted returns the applied revisions which moving the database from the current
+// revisions to the target revisions would downgrade, in the order alembic reverts them
+// (i.e. dependent revisions first). An empty target is base, which reverts everything.
+// Reverting a revision also reverts every applied revision depending on it.
+func (g *revisionGraph) reverted(current []string, target []string) []string {
+	applied := g.applied(current)
+
+	// Only revisions built on top of a target are reverted, not other branches
+	kept := make(map[string]bool)
+	removed := make(map[string]bool)
+	for _, id := range target {
+		for _, requirement := range g.requires(id) {
+			kept[requirement] = true
+		}
+		for _, descendant := range g.descendants(id) {
+			removed[descendant] = true
+		}
+	}
+
+	reverting := make(map[string]bool)
+	for id := range applied {
+		reverting[id] = !kept[id] && (len(target) == 0 || removed[id])
+	}
+
+	// Revisions requiring a reverted revision can not stay applied either
+	order := g.applyOrder(applied)
+	for _, id := range order {
+		for _, requirement := range g.requirements(id) {
+			if reverting[requirement] {
+				reverting[id] = true
+			}
+		}
+	}
+
+	result := []string{}
+	for i := len(order) - 1; i >= 0; i-- {
+		if reverting[order[i]] {
+			result = append(result, order[i])
+		}
+	}
+
+	return result
+}
+
+// history returns the revisions which are ancestors of the upper revisions and descendants
+// of the lower revisions (both inclusive), newest first. An empty lower is base.
+func (g *revisionGraph) history(lower []string, upper []string) ([]string, error) {
+	included := make(map[string]bool)
+	for _, id := range upper {
+		for _, ancestor := range g.ancestors(id) {
+			included[ancestor] = true
+		}
+	}
+
+	if len(lower) > 0 {
+		descendants := make(map[string]bool)
+		for _, id := range lower {
+			if !included[id] {
+				return nil, fmt.Errorf("revision %v is not an ancestor of revision %v", id, describeRevisions(upper))
+			}
+			for _, descendant := range g.descendants(id) {
+				descendants[descendant] = true
+			}
+		}
+		for id := range included {
+			included[id] = descendants[id]
+		}
+	}
+
+	result := []string{}
+	for _, id := range g.order {
+		if included[id] {
+			result = append(result, id)
+		}
+	}
+
+	return result, nil
+}
+
+// related reports whether one of the revisions is an ancestor of the other
+func (g *revisionGraph) related(a string, b string) bool {
+	for _, id := range g.ancestors(a) {
+		if id == b {
+			return true
+		}
+	}
+	for _, id := range g.ancestors(b) {
+		if id == a {
+			return true
+		}
+	}
+	return false
+}
+
+// hasLabel reports whether the revision carries the given branch label
+func (g *revisionGraph) hasLabel(id string, label string) bool {
+	for _, existing := range g.revisions[id].BranchLabels {
+		if existing == label {
+			return true
+		}
+	}
+	return false
+}
+
+// heads returns the revisions which are not revised by any other revision
+func (g *revisionGraph) heads() []string {
+	var heads []string
+	for _, id := range g.order {
+		if g.revisions[id].IsHead {
+			heads = append(heads, id)
+		}
+	}
+	return heads
+}
+
+// branches returns every branch label along with the first and last revisions carrying it
+func (g *revisionGraph) branches() []revisionBranch {
+	var branches []revisionBranch
+	index := make(map[string]int)
+
+	// Walking from the heads down means a branch's heads are seen before its base
+	for _, id := range g.order {
+		revision := g.revisions[id]
+		for _, label := range revision.BranchLabels {
+			i, ok := index[label]
+			if !ok {
+				i = len(branches)
+				index[label] = i
+				branches = append(branches, revisionBranch{Label: label, Heads: []string{}})
+			}
+
+			if revision.IsHead {
+				branches[i].Heads = append(branches[i].Heads, id)
+			}
+			branches[i].Base = id
+		}
+	}
+
+	sort.SliceStable(branches, func(i, j int) bool { return branches[i].Label < branches[j].Label })
+
+	return branches
+}
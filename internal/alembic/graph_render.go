@@ -0,0 +1,214 @@
+package alembic
+
+import (
+	"fmt"
+	"strings"
+)
+
+// graphHighlights marks revisions which should stand out when rendering a revision graph
+type graphHighlights struct {
+	Current map[string]bool
+	Target  map[string]bool
+}
+
+// Fill colors shared by the DOT and Mermaid output
+const (
+	currentColor = "#9ecae1"
+	targetColor  = "#a1d99b"
+	bothColor    = "#fdd49e"
+)
+
+func (h graphHighlights) color(id string) string {
+	switch {
+	case h.Current[id] && h.Target[id]:
+		return bothColor
+	case h.Current[id]:
+		return currentColor
+	case h.Target[id]:
+		return targetColor
+	}
+	return ""
+}
+
+// nodeLabel builds the text displayed for a revision: its ID, branch labels and message
+func (g *revisionGraph) nodeLabel(id string, h graphHighlights) []string {
+	revision := g.revisions[id]
+	lines := []string{id}
+
+	if len(revision.BranchLabels) > 0 {
+		lines[0] += fmt.Sprintf(" (%v)", strings.Join(revision.BranchLabels, ", "))
+	}
+
+	var markers []string
+	if h.Current[id] {
+		markers = append(markers, "current")
+	}
+	if h.Target[id] {
+		markers = append(markers, "target")
+	}
+	if len(markers) > 0 {
+		lines[0] += fmt.Sprintf(" [%v]", strings.Join(markers, ", "))
+	}
+
+	if message := strings.Join(strings.Fields(revision.Message), " "); message != "" {
+		lines = append(lines, message)
+	}
+
+	return lines
+}
+
+// dependencies returns the revisions the given revision depends on, with branch labels
+// resolved to the revision declaring the label.
+func (g *revisionGraph) dependencies(id string) []string {
+	var result []string
+
+	for _, dependency := range g.revisions[id].DependsOn {
+		if _, ok := g.revisions[dependency]; !ok {
+			dependency = g.lookupLabel(dependency)
+		}
+		result = append(result, dependency)
+	}
+
+	return result
+}
+
+// dot renders the revision graph in the Graphviz DOT language. Edges point from a revision
+// to the revisions which revise it, and dependencies are drawn as dashed edges.
+func (g *revisionGraph) dot(h graphHighlights) string {
+	var b strings.Builder
+
+	escape := func(value string) string {
+		return strings.ReplaceAll(strings.ReplaceAll(value, `\`, `\\`), `"`, `\"`)
+	}
+	quote := func(value string) string {
+		return `"` + escape(value) + `"`
+	}
+
+	b.WriteString("digraph alembic {\n")
+	b.WriteString("  rankdir=BT;\n")
+	b.WriteString("  node [shape=box];\n")
+
+	for _, id := range g.order {
+		revision := g.revisions[id]
+
+		lines := g.nodeLabel(id, h)
+		for i := range lines {
+			lines[i] = escape(lines[i])
+		}
+		attributes := []string{`label="` + strings.Join(lines, `\n`) + `"`}
+
+		switch {
+		case revision.IsMergePoint:
+			attributes = append(attributes, "shape=ellipse")
+		case revision.IsBranchPoint:
+			attributes = append(attributes, "shape=hexagon")
+		}
+
+		var styles []string
+		if revision.IsHead {
+			styles = append(styles, "bold")
+		}
+		if color := h.color(id); color != "" {
+			styles = append(styles, "filled")
+			attributes = append(attributes, fmt.Sprintf(`fillcolor="%v"`, color))
+		}
+		if len(styles) > 0 {
+			attributes = append(attributes, fmt.Sprintf(`style="%v"`, strings.Join(styles, ",")))
+		}
+
+		b.WriteString(fmt.Sprintf("  %v [%v];\n", quote(id), strings.Join(attributes, ", ")))
+	}
+
+	for _, id := range g.order {
+		for _, down := range g.revisions[id].DownRevisions {
+			b.WriteString(fmt.Sprintf("  %v -> %v;\n", quote(down), quote(id)))
+		}
+		for _, dependency := range g.dependencies(id) {
+			b.WriteString(fmt.Sprintf("  %v -> %v [style=dashed];\n", quote(dependency), quote(id)))
+		}
+	}
+
+	b.WriteString("}\n")
+
+	return b.String()
+}
+
+// mermaid renders the revision graph as a Mermaid flowchart using the same conventions as
+// the DOT output. Node identifiers are generated since revision IDs may contain characters
+// which Mermaid does not allow in identifiers.
+func (g *revisionGraph) mermaid(h graphHighlights) string {
+	var b strings.Builder
+
+	names := make(map[string]string)
+	for i, id := range g.order {
+		names[id] = fmt.Sprintf("r%v", i)
+	}
+
+	b.WriteString("flowchart BT\n")
+
+	for _, id := range g.order {
+		revision := g.revisions[id]
+
+		lines := g.nodeLabel(id, h)
+		for i := range lines {
+			// A literal '#' would otherwise start an entity code such as #quot;
+			lines[i] = strings.NewReplacer("#", "#35;", `"`, "#quot;", "<", "#lt;", ">", "#gt;").Replace(lines[i])
+		}
+		label := `"` + strings.Join(lines, "<br/>") + `"`
+
+		switch {
+		case revision.IsMergePoint:
+			label = "([" + label + "])"
+		case revision.IsBranchPoint:
+			label = "{{" + label + "}}"
+		default:
+			label = "[" + label + "]"
+		}
+
+		b.WriteString(fmt.Sprintf("    %v%v\n", names[id], label))
+	}
+
+	for _, id := range g.order {
+		for _, down := range g.revisions[id].DownRevisions {
+			b.WriteString(fmt.Sprintf("    %v --> %v\n", names[down], names[id]))
+		}
+		for _, dependency := range g.dependencies(id) {
+			b.WriteString(fmt.Sprintf("    %v -.-> %v\n", names[dependency], names[id]))
+		}
+	}
+
+	classes := map[string][]string{}
+	for _, id := range g.order {
+		if g.revisions[id].IsHead {
+			classes["head"] = append(classes["head"], names[id])
+		}
+		switch h.color(id) {
+		case bothColor:
+			classes["both"] = append(classes["both"], names[id])
+		case currentColor:
+			classes["current"] = append(classes["current"], names[id])
+		case targetColor:
+			classes["target"] = append(classes["target"], names[id])
+		}
+	}
+
+	definitions := []struct {
+		name  string
+		style string
+	}{
+		{"head", "stroke-width:3px"},
+		{"current", "fill:" + currentColor},
+		{"target", "fill:" + targetColor},
+		{"both", "fill:" + bothColor},
+	}
+
+	for _, definition := range definitions {
+		if len(classes[definition.name]) == 0 {
+			continue
+		}
+		b.WriteString(fmt.Sprintf("    classDef %v %v\n", definition.name, definition.style))
+		b.WriteString(fmt.Sprintf("    class %v %v\n", strings.Join(classes[definition.name], ","), definition.name))
+	}
+
+	return b.String()
+}
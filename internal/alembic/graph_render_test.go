@@ -0,0 +1,79 @@
+package alembic
+
+import (
+	"testing"
+)
+
+// testRenderGraph holds a merge, a branch point, a dependency and messages which need escaping
+func testRenderGraph(t *testing.T) *revisionGraph {
+	t.Helper()
+
+	graph, err := newRevisionGraph([]alembicRevision{
+		{Revision: "a1", BranchLabels: []string{"main"}, Message: `create "users" table`},
+		{Revision: "b2", DownRevisions: []string{"a1"}, Message: "split\\path <b>bold</b>"},
+		{Revision: "c3", DownRevisions: []string{"a1"}, Message: "fix #12;\n  multi   line"},
+		{Revision: "d4", DownRevisions: []string{"b2", "c3"}, DependsOn: []string{"main"}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return graph
+}
+
+func TestDot(t *testing.T) {
+	graph := testRenderGraph(t)
+	highlights := graphHighlights{
+		Current: map[string]bool{"b2": true, "d4": true},
+		Target:  map[string]bool{"d4": true},
+	}
+
+	expected := `digraph alembic {
+  rankdir=BT;
+  node [shape=box];
+  "d4" [label="d4 (main) [current, target]", shape=ellipse, fillcolor="#fdd49e", style="bold,filled"];
+  "b2" [label="b2 (main) [current]\nsplit\\path <b>bold</b>", fillcolor="#9ecae1", style="filled"];
+  "c3" [label="c3 (main)\nfix #12; multi line"];
+  "a1" [label="a1 (main)\ncreate \"users\" table", shape=hexagon];
+  "b2" -> "d4";
+  "c3" -> "d4";
+  "a1" -> "d4" [style=dashed];
+  "a1" -> "b2";
+  "a1" -> "c3";
+}
+`
+
+	if actual := graph.dot(highlights); actual != expected {
+		t.Fatalf("expected:\n%v\ngot:\n%v", expected, actual)
+	}
+}
+
+func TestMermaid(t *testing.T) {
+	graph := testRenderGraph(t)
+	highlights := graphHighlights{
+		Current: map[string]bool{"b2": true, "d4": true},
+		Target:  map[string]bool{"d4": true},
+	}
+
+	expected := `flowchart BT
+    r0(["d4 (main) [current, target]"])
+    r1["b2 (main) [current]<br/>split\path #lt;b#gt;bold#lt;/b#gt;"]
+    r2["c3 (main)<br/>fix #35;12; multi line"]
+    r3{{"a1 (main)<br/>create #quot;users#quot; table"}}
+    r1 --> r0
+    r2 --> r0
+    r3 -.-> r0
+    r3 --> r1
+    r3 --> r2
+    classDef head stroke-width:3px
+    class r0 head
+    classDef current fill:#9ecae1
+    class r1 current
+    classDef both fill:#fdd49e
+    class r0 both
+`
+
+	if actual := graph.mermaid(highlights); actual != expected {
+		t.Fatalf("expected:\n%v\ngot:\n%v", expected, actual)
+	}
+}
@@ -0,0 +1,301 @@
+package alembic
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestRevisionGraph(t *testing.T) {
+	graph := testGraph(t)
+
+	if heads := graph.heads(); !reflect.DeepEqual(heads, []string{mainHead, featureHead}) {
+		t.Fatalf("unexpected heads %v", heads)
+	}
+
+	expected := []revisionBranch{
+		{Label: "feature", Base: featureBase, Heads: []string{featureHead}},
+		{Label: "main", Base: mainBase, Heads: []string{mainHead}},
+	}
+	if branches := graph.branches(); !reflect.DeepEqual(branches, expected) {
+		t.Fatalf("unexpected branches %v", branches)
+	}
+
+	// Labels apply to every descendant of the revision declaring them
+	if !graph.hasLabel(mainHead, "main") || graph.hasLabel(mainHead, "feature") {
+		t.Fatalf("unexpected labels %v", graph.revisions[mainHead].BranchLabels)
+	}
+}
+
+func TestRevisionGraphErrors(t *testing.T) {
+	tests := []struct {
+		name      string
+		revisions []alembicRevision
+		err       string
+	}{
+		{
+			name:      "duplicate",
+			revisions: []alembicRevision{{Revision: "a1"}, {Revision: "a1"}},
+			err:       "present more than once",
+		},
+		{
+			name:      "unknown down revision",
+			revisions: []alembicRevision{{Revision: "a1", DownRevisions: []string{"zz"}}},
+			err:       "revises unknown revision 'zz'",
+		},
+		{
+			name:      "unknown dependency",
+			revisions: []alembicRevision{{Revision: "a1", DependsOn: []string{"zz"}}},
+			err:       "depends on unknown revision 'zz'",
+		},
+		{
+			name: "cycle",
+			revisions: []alembicRevision{
+				{Revision: "a1", DownRevisions: []string{"b2"}},
+				{Revision: "b2", DownRevisions: []string{"a1"}},
+			},
+			err: "contains a cycle",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if _, err := newRevisionGraph(test.revisions); err == nil || !strings.Contains(err.Error(), test.err) {
+				t.Fatalf("expected error containing %q, got %v", test.err, err)
+			}
+		})
+	}
+}
+
+func TestPendingAndReverted(t *testing.T) {
+	graph := testGraph(t)
+
+	tests := []struct {
+		name     string
+		current  []string
+		target   []string
+		pending  []string
+		reverted []string
+	}{
+		{
+			name:     "upgrade from base",
+			current:  []string{},
+			target:   []string{mainThird},
+			pending:  []string{mainBase, mainSecond, mainThird},
+			reverted: []string{},
+		},
+		{
+			name:     "upgrade one branch",
+			current:  []string{mainSecond, featureBase},
+			target:   []string{mainHead},
+			pending:  []string{mainThird, mainHead},
+			reverted: []string{},
+		},
+		{
+			name:     "downgrade one branch",
+			current:  []string{mainHead, featureHead},
+			target:   []string{mainSecond},
+			pending:  []string{},
+			reverted: []string{mainHead, mainThird},
+		},
+		{
+			name:     "downgrade to base",
+			current:  []string{mainSecond, featureHead},
+			target:   []string{},
+			pending:  []string{},
+			reverted: []string{mainSecond, mainBase, featureHead, featureBase},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if pending := graph.pending(test.current, test.target); !reflect.DeepEqual(pending, test.pending) {
+				t.Errorf("expected pending %v, got %v", test.pending, pending)
+			}
+			if reverted := graph.reverted(test.current, test.target); !reflect.DeepEqual(reverted, test.reverted) {
+				t.Errorf("expected reverted %v, got %v", test.reverted, reverted)
+			}
+		})
+	}
+}
+
+func TestHistory(t *testing.T) {
+	graph := testGraph(t)
+
+	tests := []struct {
+		name     string
+		lower    []string
+		upper    []string
+		expected []string
+		err      string
+	}{
+		{
+			name:     "everything",
+			upper:    []string{mainHead, featureHead},
+			expected: []string{mainHead, mainThird, mainSecond, mainBase, featureHead, featureBase},
+		},
+		{
+			name:     "one branch",
+			upper:    []string{featureHead},
+			expected: []string{featureHead, featureBase},
+		},
+		{
+			name:     "range",
+			lower:    []string{mainSecond},
+			upper:    []string{mainThird},
+			expected: []string{mainThird, mainSecond},
+		},
+		{
+			name:     "single revision",
+			lower:    []string{mainThird},
+			upper:    []string{mainThird},
+			expected: []string{mainThird},
+		},
+		{
+			name:     "base",
+			upper:    []string{},
+			expected: []string{},
+		},
+		{
+			name:  "unrelated lower revision",
+			lower: []string{featureBase},
+			upper: []string{mainHead},
+			err:   "is not an ancestor of revision " + mainHead,
+		},
+		{
+			name:  "reversed range",
+			lower: []string{mainHead},
+			upper: []string{mainSecond},
+			err:   "is not an ancestor",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			result, err := graph.history(test.lower, test.upper)
+			if test.err != "" {
+				if err == nil || !strings.Contains(err.Error(), test.err) {
+					t.Fatalf("expected error containing %q, got %v", test.err, err)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !reflect.DeepEqual(result, test.expected) {
+				t.Fatalf("expected %v, got %v", test.expected, result)
+			}
+		})
+	}
+}
+
+// testDependencyGraph is testGraph where the feature branch depends on the main branch:
+// its base on the 'main' branch label and its head on a revision within it
+func testDependencyGraph(t *testing.T) *revisionGraph {
+	t.Helper()
+
+	graph, err := newRevisionGraph([]alembicRevision{
+		{Revision: mainBase, BranchLabels: []string{"main"}},
+		{Revision: mainSecond, DownRevisions: []string{mainBase}},
+		{Revision: mainThird, DownRevisions: []string{mainSecond}},
+		{Revision: mainHead, DownRevisions: []string{mainThird}},
+		{Revision: featureBase, BranchLabels: []string{"feature"}, DependsOn: []string{"main"}},
+		{Revision: featureHead, DownRevisions: []string{featureBase}, DependsOn: []string{mainThird}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return graph
+}
+
+func TestPendingAndRevertedDependencies(t *testing.T) {
+	graph := testDependencyGraph(t)
+
+	tests := []struct {
+		name     string
+		current  []string
+		target   []string
+		pending  []string
+		reverted []string
+	}{
+		{
+			name:     "upgrade from base",
+			current:  []string{},
+			target:   []string{featureHead},
+			pending:  []string{mainBase, featureBase, mainSecond, mainThird, featureHead},
+			reverted: []string{},
+		},
+		{
+			name:     "upgrade a dependency",
+			current:  []string{mainSecond, featureBase},
+			target:   []string{featureHead},
+			pending:  []string{mainThird, featureHead},
+			reverted: []string{},
+		},
+		{
+			name:     "dependency already applied",
+			current:  []string{mainHead},
+			target:   []string{featureBase},
+			pending:  []string{featureBase},
+			reverted: []string{},
+		},
+		{
+			name:     "downgrade a dependency",
+			current:  []string{mainHead, featureHead},
+			target:   []string{mainSecond},
+			pending:  []string{},
+			reverted: []string{mainHead, featureHead, mainThird},
+		},
+		{
+			name:     "downgrade the dependent branch",
+			current:  []string{mainHead, featureHead},
+			target:   []string{featureBase},
+			pending:  []string{},
+			reverted: []string{featureHead},
+		},
+		{
+			name:     "downgrade to base",
+			current:  []string{mainHead, featureHead},
+			target:   []string{},
+			pending:  []string{},
+			reverted: []string{mainHead, featureHead, mainThird, mainSecond, featureBase, mainBase},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if pending := graph.pending(test.current, test.target); !reflect.DeepEqual(pending, test.pending) {
+				t.Errorf("expected pending %v, got %v", test.pending, pending)
+			}
+			if reverted := graph.reverted(test.current, test.target); !reflect.DeepEqual(reverted, test.reverted) {
+				t.Errorf("expected reverted %v, got %v", test.reverted, reverted)
+			}
+		})
+	}
+}
+
+func TestSatisfiesDependencies(t *testing.T) {
+	graph := testDependencyGraph(t)
+
+	tests := []struct {
+		name     string
+		current  []string
+		desired  []string
+		expected bool
+	}{
+		{name: "dependent branch at its dependency", current: []string{mainThird, featureHead}, desired: []string{mainThird}, expected: true},
+		{name: "dependent branch past the target", current: []string{mainSecond, featureHead}, desired: []string{mainSecond}, expected: false},
+		{name: "dependency through a branch label", current: []string{mainBase, featureBase}, desired: []string{mainBase}, expected: true},
+		{name: "dependency ahead", current: []string{featureHead, mainHead}, desired: []string{featureHead}, expected: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if result := graph.satisfies(test.current, test.desired); result != test.expected {
+				t.Fatalf("expected %v, got %v", test.expected, result)
+			}
+		})
+	}
+}
@@ -0,0 +1,203 @@
+package alembic
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/hashicorp/terraform-plugin-framework/diag"
+	"github.com/hashicorp/terraform-plugin-framework/types"
+)
+
+// helperSource is a python script which reports revision information as JSON using the
+// alembic API, so that we never need to scrape the human readable alembic output.
+//
+//go:embed helper.py
+var helperSource []byte
+
+// helperResult is the JSON document printed by the helper script
+type helperResult struct {
+	Current   []currentRevision `json:"current"`
+	Revisions []alembicRevision `json:"revisions"`
+	Output    string            `json:"output"`
+	Error     string            `json:"error"`
+}
+
+// pythonCommand returns the python interpreter used to run the helper script. Unless one
+// is configured explicitly, it is derived from the alembic command so that the helper runs
+// in the same environment as alembic. A wrapped alembic keeps its wrapper (e.g. "poetry run
+// alembic" becomes "poetry run python"), while the interpreter of an alembic executable is
+// taken from its shebang or found next to it (e.g. "/venv/bin/alembic -c audit.ini" becomes
+// "/venv/bin/python3"). Anything else runs the first python3 on the PATH.
+func pythonCommand(ctx context.Context, p alembicProvider, alembic_command types.List) ([]string, diag.Diagnostics) {
+	var alembic []string
+	var diags diag.Diagnostics
+
+	if !alembic_command.Null {
+		diags.Append(alembic_command.ElementsAs(ctx, &alembic, false)...)
+		if diags.HasError() {
+			return nil, diags
+		}
+	} else if p.python != nil {
+		return p.python, diags
+	} else {
+		alembic = p.alembic
+	}
+
+	// Anything following alembic (e.g. "-c audit.ini") is an argument of alembic itself
+	switch i := alembicExecutable(alembic); {
+	case i >= 2 && alembic[i-1] == "-m" && alembic[i] == "alembic":
+		// e.g. "python -m alembic"
+		return append([]string{}, alembic[:i-1]...), diags
+	case i > 0:
+		python := append([]string{}, alembic[:i]...)
+		dir, name := filepath.Split(alembic[i])
+		return append(python, dir+strings.Replace(name, "alembic", "python", 1)), diags
+	case i == 0:
+		if python := executableInterpreter(p.project_root, alembic[0]); python != nil {
+			return python, diags
+		}
+	}
+
+	return []string{"python3"}, diags
+}
+
+// executableInterpreter returns the python interpreter a python script (e.g. the alembic
+// executable of a virtual environment or pipx) runs with, which is its shebang or else a
+// python3 or python next to it. Executables without a directory are looked up on the PATH,
+// and relative ones are relative to the project root, where alembic runs.
+func executableInterpreter(project_root string, executable string) []string {
+	path := executable
+	if filepath.Base(executable) == executable {
+		found, err := exec.LookPath(executable)
+		if err != nil {
+			return nil
+		}
+		path = found
+	} else if !filepath.IsAbs(executable) {
+		path = filepath.Join(project_root, executable)
+	}
+
+	if file, err := os.Open(path); err == nil {
+		line, _ := bufio.NewReader(file).ReadString('\n')
+		file.Close()
+
+		// e.g. "#!/venv/bin/python3" or "#!/usr/bin/env python3"
+		if interpreter := strings.Fields(strings.TrimPrefix(line, "#!")); strings.HasPrefix(line, "#!") {
+			for _, arg := range interpreter {
+				if strings.HasPrefix(filepath.Base(arg), "python") {
+					return interpreter
+				}
+			}
+		}
+	}
+
+	// Executables linked elsewhere (e.g. by pipx) live next to their interpreter
+	if resolved, err := filepath.EvalSymlinks(path); err == nil {
+		path = resolved
+	}
+
+	for _, name := range []string{"python3", "python"} {
+		python := filepath.Join(filepath.Dir(path), name)
+		if info, err := os.Stat(python); err == nil && !info.IsDir() && info.Mode()&0111 != 0 {
+			return []string{python}
+		}
+	}
+
+	return nil
+}
+
+// runHelper executes a command of the embedded helper script and returns its decoded
+// result. The command is sent to the provider's worker when one is enabled, unless the
+// resource overrides the alembic command. Otherwise, the script is written to a private
+// temporary directory for each invocation.
+func runHelper(
+	ctx context.Context,
+	p alembicProvider,
+	alembic_command types.List,
+	extra_values types.Map,
+	environment_values types.Map,
+	args ...string,
+) (*helperResult, diag.Diagnostics) {
+
+	var stderr bytes.Buffer
+	var stdout bytes.Buffer
+	var result helperResult
+
+	if p.worker != nil && alembic_command.Null {
+		request, diags := newWorkerRequest(ctx, p, extra_values, environment_values, args[0], args[1:]...)
+		if diags.HasError() {
+			return nil, diags
+		}
+		return p.worker.run(ctx, request)
+	}
+
+	python, diags := pythonCommand(ctx, p, alembic_command)
+	if diags.HasError() {
+		return nil, diags
+	}
+
+	dir, err := os.MkdirTemp("", "terraform-provider-alembic-")
+	if err != nil {
+		diags.AddError("failed creating a temporary directory for the alembic helper", err.Error())
+		return nil, diags
+	}
+	defer os.RemoveAll(dir)
+
+	helper := filepath.Join(dir, "helper.py")
+	if err := os.WriteFile(helper, helperSource, 0600); err != nil {
+		diags.AddError("failed writing the alembic helper", err.Error())
+		return nil, diags
+	}
+
+	python = append(python, helper, "-c", p.config, "-n", p.section)
+
+	proc, result_diags := buildCommand(ctx, p, python, extra_values, environment_values, args...)
+	diags.Append(result_diags...)
+	if diags.HasError() {
+		return nil, diags
+	}
+
+	// The database URLs likely contain credentials, so they are not passed as arguments
+	if len(p.databases) > 0 {
+		databases, err := json.Marshal(p.proxyDatabases())
+		if err != nil {
+			diags.AddError("failed encoding the databases for the alembic helper", err.Error())
+			return nil, diags
+		}
+		proc.Env = append(proc.Env, "ALEMBIC_PROVIDER_DATABASES="+string(databases))
+	}
+
+	proc.Stdout = &stdout
+	proc.Stderr = &stderr
+
+	run_err := proc.Run()
+
+	// The helper reports failures within alembic as JSON, so prefer that over the exit code
+	summary := ""
+	if err := json.Unmarshal(stdout.Bytes(), &result); err != nil {
+		summary = fmt.Sprintf("invalid helper output: %v", err)
+		if run_err != nil {
+			summary = run_err.Error()
+		}
+	} else if result.Error != "" {
+		summary = result.Error
+	}
+
+	if summary != "" {
+		diags.AddError(
+			fmt.Sprintf("alembic %v failed: %v", args[0], summary),
+			fmt.Sprintf("Standard Output:\n%v\n\nStandard Error:\n%v\n\n", stdout.String(), stderr.String()),
+		)
+		return nil, diags
+	}
+
+	return &result, diags
+}
@@ -0,0 +1,237 @@
+"""Report alembic revision information as JSON for terraform-provider-alembic.
+
+This script is embedded in the provider and executed with the same python environment
+as alembic. It uses alembic's python API rather than the command line, so the output
+format does not depend on the installed alembic version.
+
+Usage: helper.py [-c CONFIG] [-n SECTION] [-x KEY=VALUE ...] [--tag TAG] [--sql] COMMAND [ARGS]
+
+Commands:
+    current             revisions the database is stamped with
+    heads               head revisions of the script directory
+    history [RANGE]     revisions in the (optional) "base:head" range
+    show REVISION       revisions matching a revision specification
+    upgrade REVISION    upgrade the database
+    downgrade REVISION  downgrade the database
+    stamp REVISION      stamp the database
+    serve               run as a worker, reading one JSON request per line from stdin
+                        and writing one JSON response per line to stdout
+
+Worker requests hold the "command", "args", "config", "section", "x", "tag", "sql",
+"databases" and "environment" of a single invocation. Keeping the worker alive means
+python and the modules imported by env.py are only loaded once.
+
+The "databases" of a request map the engine names of a multidb project to their URLs.
+They replace the "databases" option and the "sqlalchemy.url" of each engine's section.
+From the command line, they are read as JSON from the ALEMBIC_PROVIDER_DATABASES
+environment variable, so the URLs never show up in the process arguments.
+"""
+
+import argparse
+import io
+import json
+import os
+import re
+import sys
+
+
+def listify(value):
+    """Normalize alembic's None/str/tuple/set values into a list of strings"""
+    if value is None:
+        return []
+    if isinstance(value, str):
+        return [value]
+    return sorted(value) if isinstance(value, (set, frozenset)) else list(value)
+
+
+def describe(script):
+    """Convert an alembic Script into a JSON compatible dictionary"""
+    longdoc = script.longdoc or ""
+    match = re.search(r"^Create Date: (.*)$", longdoc, re.MULTILINE)
+
+    return {
+        "revision": script.revision,
+        "down_revisions": listify(script.down_revision),
+        "branch_labels": listify(getattr(script, "_orig_branch_labels", script.branch_labels)),
+        "depends_on": listify(script.dependencies),
+        "message": script.doc or "",
+        "create_date": match.group(1).strip() if match else "",
+        "path": script.path or "",
+        "is_head": bool(script.is_head),
+        "is_branch_point": bool(script.is_branch_point),
+        "is_merge_point": bool(script.is_merge_point),
+    }
+
+
+def current(config, script, request):
+    from alembic.runtime.environment import EnvironmentContext
+
+    heads = []
+
+    def collect(rev, context):
+        # The multidb template runs once per engine, naming the upgrade token after it
+        token = context.opts.get("upgrade_token", "upgrades")
+        engine = token[:-len("_upgrades")] if token.endswith("_upgrades") else ""
+        heads.extend((engine, revision) for revision in context.get_current_heads())
+        return []
+
+    with EnvironmentContext(config, script, fn=collect, dont_mutate=True):
+        script.run_env()
+
+    script_heads = set(script.get_heads())
+
+    return {
+        "current": [
+            {"revision": revision, "is_head": revision in script_heads, "engine": engine}
+            for engine, revision in heads
+        ]
+    }
+
+
+def heads(config, script, request):
+    return {"revisions": [describe(revision) for revision in script.get_revisions("heads")]}
+
+
+def history(config, script, request):
+    args = request["args"]
+    base, head = "base", "heads"
+    if args:
+        if ":" not in args[0]:
+            raise ValueError("history range must be in the format [start]:[end]")
+        base, head = args[0].split(":", 1)
+        base, head = base or "base", head or "heads"
+
+    return {"revisions": [describe(revision) for revision in script.walk_revisions(base, head)]}
+
+
+def show(config, script, request):
+    args = request["args"]
+    if len(args) != 1:
+        raise ValueError("show requires exactly one revision")
+
+    return {"revisions": [describe(revision) for revision in script.get_revisions(args[0])]}
+
+
+def migrate(name):
+    def run(config, script, request):
+        from alembic import command
+
+        args = request["args"]
+        if not args or (len(args) > 1 and name != "stamp"):
+            raise ValueError("{} requires exactly one revision".format(name))
+
+        # Offline (--sql) mode writes the generated SQL to the output buffer
+        sql = bool(request.get("sql"))
+        revision = args[0] if len(args) == 1 else args
+        getattr(command, name)(config, revision, sql=sql, tag=request.get("tag"))
+        return {"output": (config.output_buffer if sql else config.stdout).getvalue()}
+
+    return run
+
+
+COMMANDS = {
+    "current": current,
+    "heads": heads,
+    "history": history,
+    "show": show,
+    "upgrade": migrate("upgrade"),
+    "downgrade": migrate("downgrade"),
+    "stamp": migrate("stamp"),
+}
+
+
+def execute(request):
+    """Run a single command with the configuration and environment of the request"""
+    from alembic.config import Config
+    from alembic.script import ScriptDirectory
+
+    saved = dict(os.environ)
+    os.environ.update(request.get("environment") or {})
+
+    try:
+        options = argparse.Namespace(x=request.get("x") or [])
+        config = Config(
+            request.get("config") or "alembic.ini",
+            ini_section=request.get("section") or "alembic",
+            output_buffer=io.StringIO(),
+            stdout=io.StringIO(),
+            cmd_opts=options,
+        )
+
+        databases = request.get("databases") or {}
+        if databases:
+            # ConfigParser interpolation would mangle URL encoded passwords
+            config.set_main_option("databases", ", ".join(sorted(databases)))
+            for name, url in databases.items():
+                config.set_section_option(name, "sqlalchemy.url", url.replace("%", "%%"))
+
+        script = ScriptDirectory.from_config(config)
+        return COMMANDS[request["command"]](config, script, request)
+    finally:
+        os.environ.clear()
+        os.environ.update(saved)
+
+
+def respond(output, result):
+    json.dump(result, output)
+    output.write("\n")
+    output.flush()
+
+
+def serve(output):
+    for line in sys.stdin:
+        if not line.strip():
+            continue
+
+        try:
+            result = execute(json.loads(line))
+        except Exception as exc:
+            result = {"error": "{}: {}".format(type(exc).__name__, exc)}
+
+        respond(output, result)
+
+    return 0
+
+
+def main():
+    parser = argparse.ArgumentParser()
+    parser.add_argument("-c", "--config", default="alembic.ini")
+    parser.add_argument("-n", "--name", default="alembic")
+    parser.add_argument("-x", action="append", default=[])
+    parser.add_argument("--tag")
+    parser.add_argument("--sql", action="store_true")
+    parser.add_argument("command", choices=sorted(COMMANDS) + ["serve"])
+    parser.add_argument("args", nargs="*")
+    options = parser.parse_args()
+
+    # env.py (and anything it imports) may print to stdout, which must only hold our JSON
+    output = sys.stdout
+    sys.stdout = sys.stderr
+
+    # Keep the database URLs away from env.py and anything it runs
+    databases = json.loads(os.environ.pop("ALEMBIC_PROVIDER_DATABASES", "") or "{}")
+
+    if options.command == "serve":
+        return serve(output)
+
+    try:
+        result = execute({
+            "command": options.command,
+            "args": options.args,
+            "config": options.config,
+            "section": options.name,
+            "x": options.x,
+            "tag": options.tag,
+            "sql": options.sql,
+            "databases": databases,
+        })
+    except Exception as exc:
+        respond(output, {"error": "{}: {}".format(type(exc).__name__, exc)})
+        return 1
+
+    respond(output, result)
+    return 0
+
+
+if __name__ == "__main__":
+    sys.exit(main())
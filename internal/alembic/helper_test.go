@@ -0,0 +1,282 @@
+package alembic
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/hashicorp/terraform-plugin-framework/attr"
+	"github.com/hashicorp/terraform-plugin-framework/types"
+)
+
+func TestPythonCommand(t *testing.T) {
+	root := t.TempDir()
+	for name, contents := range map[string]string{
+		"path/alembic":              "#!/usr/bin/python3.10\nimport alembic\n",
+		"pipx/venv/bin/alembic":     "#!/usr/bin/env python3\nimport alembic\n",
+		"venv/bin/alembic":          "#!/bin/sh\nexec python -m alembic \"$@\"\n",
+		"venv/bin/python3":          "",
+		"project/.venv/bin/alembic": "#!/bin/sh\n",
+		"project/.venv/bin/python":  "",
+	} {
+		path := filepath.Join(root, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(contents), 0700); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.MkdirAll(filepath.Join(root, "pipx", "bin"), 0700); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(filepath.Join(root, "pipx", "venv", "bin", "alembic"), filepath.Join(root, "pipx", "bin", "alembic")); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		path     string
+		p        alembicProvider
+		alembic  types.List
+		expected []string
+	}{
+		{
+			name:     "alembic on the path",
+			path:     filepath.Join(root, "path"),
+			p:        alembicProvider{alembic: []string{"alembic"}},
+			alembic:  types.List{ElemType: types.StringType, Null: true},
+			expected: []string{"/usr/bin/python3.10"},
+		},
+		{
+			name:     "alembic linked onto the path",
+			path:     filepath.Join(root, "pipx", "bin"),
+			p:        alembicProvider{alembic: []string{"alembic"}},
+			alembic:  types.List{ElemType: types.StringType, Null: true},
+			expected: []string{"/usr/bin/env", "python3"},
+		},
+		{
+			name:     "alembic missing from the path",
+			path:     filepath.Join(root, "missing"),
+			p:        alembicProvider{alembic: []string{"alembic"}},
+			alembic:  types.List{ElemType: types.StringType, Null: true},
+			expected: []string{"python3"},
+		},
+		{
+			name:     "virtual environment",
+			p:        alembicProvider{alembic: []string{filepath.Join(root, "venv", "bin", "alembic")}},
+			alembic:  types.List{ElemType: types.StringType, Null: true},
+			expected: []string{filepath.Join(root, "venv", "bin", "python3")},
+		},
+		{
+			name:     "virtual environment within the project",
+			p:        alembicProvider{project_root: filepath.Join(root, "project"), alembic: []string{".venv/bin/alembic"}},
+			alembic:  types.List{ElemType: types.StringType, Null: true},
+			expected: []string{filepath.Join(root, "project", ".venv", "bin", "python")},
+		},
+		{
+			name:     "alembic options",
+			p:        alembicProvider{alembic: []string{filepath.Join(root, "venv", "bin", "alembic"), "-c", "audit.ini"}},
+			alembic:  types.List{ElemType: types.StringType, Null: true},
+			expected: []string{filepath.Join(root, "venv", "bin", "python3")},
+		},
+		{
+			name:     "missing executable",
+			p:        alembicProvider{alembic: []string{"/nonexistent/bin/alembic"}},
+			alembic:  types.List{ElemType: types.StringType, Null: true},
+			expected: []string{"python3"},
+		},
+		{
+			name:     "wrapped alembic",
+			p:        alembicProvider{alembic: []string{"poetry", "run", "alembic"}},
+			alembic:  types.List{ElemType: types.StringType, Null: true},
+			expected: []string{"poetry", "run", "python"},
+		},
+		{
+			name:     "python module",
+			p:        alembicProvider{alembic: []string{"python3.10", "-m", "alembic"}},
+			alembic:  types.List{ElemType: types.StringType, Null: true},
+			expected: []string{"python3.10"},
+		},
+		{
+			name:     "python module with alembic options",
+			p:        alembicProvider{alembic: []string{"python3.10", "-m", "alembic", "-n", "audit"}},
+			alembic:  types.List{ElemType: types.StringType, Null: true},
+			expected: []string{"python3.10"},
+		},
+		{
+			name:     "unrecognized command",
+			p:        alembicProvider{alembic: []string{"./run-alembic.sh"}},
+			alembic:  types.List{ElemType: types.StringType, Null: true},
+			expected: []string{"python3"},
+		},
+		{
+			name:     "configured interpreter",
+			p:        alembicProvider{alembic: []string{"alembic"}, python: []string{"/opt/python"}},
+			alembic:  types.List{ElemType: types.StringType, Null: true},
+			expected: []string{"/opt/python"},
+		},
+		{
+			name:     "resource override",
+			p:        alembicProvider{alembic: []string{"alembic"}, python: []string{"/opt/python"}},
+			alembic:  types.List{ElemType: types.StringType, Elems: []attr.Value{types.String{Value: "pipenv"}, types.String{Value: "run"}, types.String{Value: "alembic"}}},
+			expected: []string{"pipenv", "run", "python"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if test.path != "" {
+				t.Setenv("PATH", test.path)
+			}
+
+			python, diags := pythonCommand(context.Background(), test.p, test.alembic)
+			if diags.HasError() {
+				t.Fatal(diags)
+			}
+			if !reflect.DeepEqual(python, test.expected) {
+				t.Fatalf("expected %v, got %v", test.expected, python)
+			}
+		})
+	}
+}
+
+// fakePython writes an executable standing in for the python interpreter. It records its
+// arguments and the databases passed through the environment, prints output and exits with
+// the given status.
+func fakePython(t *testing.T, output string, status int) (string, string) {
+	t.Helper()
+
+	dir := t.TempDir()
+	executable := filepath.Join(dir, "python")
+	record := filepath.Join(dir, "record")
+
+	if err := os.WriteFile(filepath.Join(dir, "output"), []byte(output), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	script := "#!/bin/sh\n" +
+		"printf '%s\\n' \"$@\" > '" + record + "'\n" +
+		"printf '%s\\n' \"databases=$ALEMBIC_PROVIDER_DATABASES\" >> '" + record + "'\n" +
+		"cat '" + filepath.Join(dir, "output") + "'\n" +
+		"echo 'some warning' >&2\n" +
+		fmt.Sprintf("exit %v\n", status)
+	if err := os.WriteFile(executable, []byte(script), 0700); err != nil {
+		t.Fatal(err)
+	}
+
+	return executable, record
+}
+
+func TestRunHelper(t *testing.T) {
+	tests := []struct {
+		name      string
+		output    string
+		status    int
+		databases map[string]string
+		args      []string
+		expected  *helperResult
+		err       string
+	}{
+		{
+			name:   "revisions",
+			output: `{"revisions": [{"revision": "` + mainHead + `", "down_revisions": ["` + mainThird + `"], "branch_labels": [], "depends_on": [], "message": "index orders", "create_date": "", "path": "", "is_head": true, "is_branch_point": false, "is_merge_point": false}]}`,
+			args:   []string{"show", "main@head"},
+			expected: &helperResult{Revisions: []alembicRevision{
+				{Revision: mainHead, DownRevisions: []string{mainThird}, BranchLabels: []string{}, DependsOn: []string{}, Message: "index orders", IsHead: true},
+			}},
+		},
+		{
+			name:      "current revisions of several databases",
+			output:    `{"current": [{"revision": "` + mainHead + `", "is_head": true, "engine": "engine1"}]}`,
+			databases: map[string]string{"engine1": "sqlite:///one.db"},
+			args:      []string{"current"},
+			expected:  &helperResult{Current: []currentRevision{{Revision: mainHead, IsHead: true, Engine: "engine1"}}},
+		},
+		{
+			name:   "error reported by the helper",
+			output: `{"error": "CommandError: Can't locate revision identified by 'missing'"}`,
+			status: 1,
+			args:   []string{"show", "missing"},
+			err:    "alembic show failed: CommandError: Can't locate revision identified by 'missing'",
+		},
+		{
+			name:   "invalid output",
+			output: "Traceback (most recent call last):",
+			status: 1,
+			args:   []string{"heads"},
+			err:    "alembic heads failed: exit status 1",
+		},
+		{
+			name:   "invalid output with a successful exit",
+			output: "not json",
+			args:   []string{"heads"},
+			err:    "alembic heads failed: invalid helper output",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			python, record := fakePython(t, test.output, test.status)
+			p := alembicProvider{
+				project_root: t.TempDir(),
+				alembic:      []string{"alembic"},
+				python:       []string{python},
+				config:       "alembic.ini",
+				section:      "alembic",
+				databases:    test.databases,
+			}
+
+			result, diags := runHelper(context.Background(), p, types.List{ElemType: types.StringType, Null: true}, types.Map{ElemType: types.StringType, Null: true}, types.Map{ElemType: types.StringType, Null: true}, test.args...)
+
+			if test.err != "" {
+				if !diags.HasError() || !strings.Contains(diags[0].Summary(), test.err) {
+					t.Fatalf("expected an error containing %q, got %v", test.err, diags)
+				}
+				// The output of the helper is kept for troubleshooting
+				if detail := diags[0].Detail(); !strings.Contains(detail, test.output) || !strings.Contains(detail, "some warning") {
+					t.Fatalf("expected the helper output in the detail, got %q", detail)
+				}
+				return
+			}
+			if diags.HasError() {
+				t.Fatal(diags)
+			}
+			if !reflect.DeepEqual(result, test.expected) {
+				t.Fatalf("expected %+v, got %+v", test.expected, result)
+			}
+
+			contents, err := os.ReadFile(record)
+			if err != nil {
+				t.Fatal(err)
+			}
+			lines := strings.Split(strings.TrimSuffix(string(contents), "\n"), "\n")
+
+			// The helper script is written to a temporary file which is removed afterwards
+			if filepath.Base(lines[0]) != "helper.py" {
+				t.Fatalf("expected the helper script, got %v", lines[0])
+			}
+			if _, err := os.Stat(lines[0]); !os.IsNotExist(err) {
+				t.Fatalf("expected the helper script to be removed, got %v", err)
+			}
+
+			expected := append([]string{"-c", "alembic.ini", "-n", "alembic"}, test.args...)
+			if !reflect.DeepEqual(lines[1:len(lines)-1], expected) {
+				t.Fatalf("expected arguments %v, got %v", expected, lines[1:len(lines)-1])
+			}
+
+			// Database URLs never show up in the arguments
+			databases := "databases="
+			if len(test.databases) > 0 {
+				databases += `{"engine1":"sqlite:///one.db"}`
+			}
+			if lines[len(lines)-1] != databases {
+				t.Fatalf("expected %q, got %q", databases, lines[len(lines)-1])
+			}
+		})
+	}
+}
@@ -0,0 +1,279 @@
+package alembic
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/hashicorp/terraform-plugin-framework/attr"
+	"github.com/hashicorp/terraform-plugin-framework/types"
+)
+
+func TestCheckProjectRoot(t *testing.T) {
+	root := t.TempDir()
+	for name, contents := range map[string]string{
+		"app/alembic.ini":        "[alembic]\n",
+		"app/tenant.ini/x":       "",
+		"other/migrations/x.txt": "",
+	} {
+		path := filepath.Join(root, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(contents), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		name         string
+		project_root string
+		config       string
+		err          string
+	}{
+		{name: "project", project_root: "app", config: "alembic.ini"},
+		{name: "missing project root", project_root: "missing", config: "alembic.ini", err: "project_root must be an valid directory path"},
+		{name: "file as project root", project_root: "app/alembic.ini", config: "alembic.ini", err: "is not a directory"},
+		{name: "missing configuration", project_root: "other", config: "alembic.ini", err: "project_root must contain an alembic.ini configuration"},
+		{name: "directory as configuration", project_root: "app", config: "tenant.ini", err: "'tenant.ini' is a directory"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			diags := checkProjectRoot(filepath.Join(root, test.project_root), test.config)
+			if test.err == "" {
+				if diags.HasError() {
+					t.Fatalf("unexpected error: %v", diags)
+				}
+				return
+			}
+
+			if !diags.HasError() {
+				t.Fatalf("expected error containing %q", test.err)
+			}
+			if message := diags[0].Summary() + ": " + diags[0].Detail(); !strings.Contains(message, test.err) {
+				t.Fatalf("expected error containing %q, got %q", test.err, message)
+			}
+		})
+	}
+}
+
+func TestCheckProjectRootArchiveSubdirectory(t *testing.T) {
+	_, root := extractionRoot(t)
+
+	err := extractTar(buildTar(t, []archiveEntry{
+		{name: "app/alembic.ini", body: "[alembic]\n"},
+	}), root)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A subdirectory naming a file within the archive is refused rather than crashing
+	project_root, err := confinedPath(root, "app/alembic.ini", "the archive")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diags := checkProjectRoot(project_root, "alembic.ini"); !diags.HasError() {
+		t.Fatal("expected a file to be refused as the project root")
+	}
+
+	project_root, err = confinedPath(root, "app", "the archive")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diags := checkProjectRoot(project_root, "alembic.ini"); diags.HasError() {
+		t.Fatalf("unexpected error: %v", diags)
+	}
+}
+
+func TestWithDatabases(t *testing.T) {
+	p := alembicProvider{databases: map[string]string{"engine1": "sqlite:///one.db"}}
+
+	// Databases belong to a resource, so those of another resource are never kept
+	without, diags := p.withDatabases(context.Background(), types.Map{ElemType: types.StringType, Null: true})
+	if diags.HasError() {
+		t.Fatal(diags)
+	}
+	if without.databases != nil {
+		t.Fatalf("expected no databases, got %v", without.databases)
+	}
+
+	with, diags := p.withDatabases(context.Background(), types.Map{ElemType: types.StringType, Elems: map[string]attr.Value{
+		"engine2": types.String{Value: "sqlite:///two.db"},
+	}})
+	if diags.HasError() {
+		t.Fatal(diags)
+	}
+	if !reflect.DeepEqual(with.databases, map[string]string{"engine2": "sqlite:///two.db"}) {
+		t.Fatalf("unexpected databases %v", with.databases)
+	}
+	if !reflect.DeepEqual(p.databases, map[string]string{"engine1": "sqlite:///one.db"}) {
+		t.Fatal("the provider was modified")
+	}
+}
+
+func TestWithProject(t *testing.T) {
+	root := t.TempDir()
+	for name, contents := range map[string]string{
+		"main/alembic.ini":     "[alembic]\n",
+		"main/tenant.ini":      "[tenant]\n",
+		"other/alembic.ini":    "[alembic]\n",
+		"bare/migrations/x":    "",
+		"custom/tenant.ini":    "[tenant]\n",
+		"custom/alembic.ini/x": "",
+	} {
+		path := filepath.Join(root, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(contents), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	worker := newAlembicWorker([]string{"python"}, filepath.Join(root, "main"))
+	p := alembicProvider{
+		project_root:   filepath.Join(root, "main"),
+		project_commit: "0123456789abcdef",
+		config:         "alembic.ini",
+		section:        "alembic",
+		worker:         worker,
+	}
+
+	unset := types.String{Null: true}
+
+	tests := []struct {
+		name         string
+		project_root types.String
+		alembic      []string
+		config       types.String
+		section      types.String
+		expected     alembicProvider
+		err          string
+	}{
+		{
+			name:         "provider configuration",
+			project_root: unset,
+			config:       unset,
+			section:      unset,
+			expected:     p,
+		},
+		{
+			name:         "same project root",
+			project_root: types.String{Value: filepath.Join(root, "main")},
+			config:       types.String{Value: "tenant.ini"},
+			section:      types.String{Value: "tenant"},
+			expected:     alembicProvider{project_root: filepath.Join(root, "main"), project_commit: "0123456789abcdef", config: "tenant.ini", section: "tenant", worker: worker},
+		},
+		{
+			name:         "other project root",
+			project_root: types.String{Value: filepath.Join(root, "other")},
+			config:       unset,
+			section:      unset,
+			expected:     alembicProvider{project_root: filepath.Join(root, "other"), config: "alembic.ini", section: "alembic"},
+		},
+		{
+			name:         "other project root and configuration",
+			project_root: types.String{Value: filepath.Join(root, "custom")},
+			config:       types.String{Value: "tenant.ini"},
+			section:      types.String{Value: "tenant"},
+			expected:     alembicProvider{project_root: filepath.Join(root, "custom"), config: "tenant.ini", section: "tenant"},
+		},
+		{
+			name:         "configuration named by the alembic command",
+			project_root: unset,
+			alembic:      []string{"alembic", "-c", "tenant.ini", "--name=tenant"},
+			config:       types.String{Value: "alembic.ini"},
+			section:      unset,
+			expected:     alembicProvider{project_root: filepath.Join(root, "main"), project_commit: "0123456789abcdef", config: "tenant.ini", section: "tenant", worker: worker},
+		},
+		{
+			name:         "other project root and configuration named by the alembic command",
+			project_root: types.String{Value: filepath.Join(root, "custom")},
+			alembic:      []string{"alembic", "-ctenant.ini"},
+			config:       unset,
+			section:      unset,
+			expected:     alembicProvider{project_root: filepath.Join(root, "custom"), config: "tenant.ini", section: "alembic"},
+		},
+		{
+			name:         "missing configuration named by the alembic command",
+			project_root: types.String{Value: filepath.Join(root, "other")},
+			alembic:      []string{"alembic", "-c", "tenant.ini"},
+			config:       unset,
+			section:      unset,
+			err:          "project_root must contain the alembic configuration",
+		},
+		{
+			name:         "unknown project root",
+			project_root: types.String{Unknown: true},
+			config:       unset,
+			section:      unset,
+			expected:     p,
+		},
+		{
+			name:         "missing project root",
+			project_root: types.String{Value: filepath.Join(root, "missing")},
+			config:       unset,
+			section:      unset,
+			err:          "project_root must be an valid directory path",
+		},
+		{
+			name:         "project root is a file",
+			project_root: types.String{Value: filepath.Join(root, "main", "alembic.ini")},
+			config:       unset,
+			section:      unset,
+			err:          "project_root must be an valid directory path",
+		},
+		{
+			name:         "project root without the configuration of the provider",
+			project_root: types.String{Value: filepath.Join(root, "bare")},
+			config:       unset,
+			section:      unset,
+			err:          "project_root must contain the alembic configuration",
+		},
+		{
+			name:         "configuration is a directory",
+			project_root: types.String{Value: filepath.Join(root, "custom")},
+			config:       unset,
+			section:      unset,
+			err:          "project_root must contain the alembic configuration",
+		},
+		{
+			name:         "missing configuration",
+			project_root: types.String{Value: filepath.Join(root, "other")},
+			config:       types.String{Value: "tenant.ini"},
+			section:      unset,
+			err:          "project_root must contain the alembic configuration",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			alembic_command := types.List{ElemType: types.StringType, Null: true}
+			if test.alembic != nil {
+				alembic_command = types.List{ElemType: types.StringType}
+				for _, arg := range test.alembic {
+					alembic_command.Elems = append(alembic_command.Elems, types.String{Value: arg})
+				}
+			}
+
+			result, diags := p.withProject(context.Background(), test.project_root, alembic_command, test.config, test.section)
+
+			if test.err != "" {
+				if !diags.HasError() || diags[0].Summary() != test.err {
+					t.Fatalf("expected error %q, got %v", test.err, diags)
+				}
+				return
+			}
+			if diags.HasError() {
+				t.Fatal(diags)
+			}
+			if !reflect.DeepEqual(result, test.expected) {
+				t.Fatalf("expected %+v, got %+v", test.expected, result)
+			}
+		})
+	}
+}
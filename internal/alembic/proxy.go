@@ -0,0 +1,691 @@
+package alembic
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"os/exec"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/terraform-plugin-framework-validators/stringvalidator"
+	"github.com/hashicorp/terraform-plugin-framework/diag"
+	"github.com/hashicorp/terraform-plugin-framework/path"
+	"github.com/hashicorp/terraform-plugin-framework/tfsdk"
+	"github.com/hashicorp/terraform-plugin-framework/types"
+	"github.com/hashicorp/terraform-plugin-log/tflog"
+)
+
+var durationRegex = regexp.MustCompile(`P([\d\.]+Y)?([\d\.]+M)?([\d\.]+D)?T?([\d\.]+H)?([\d\.]+M)?([\d\.]+?S)?`)
+
+// ParseDuration converts a ISO8601 duration into a time.Duration
+func parseDuration(str string) time.Duration {
+	matches := durationRegex.FindStringSubmatch(str)
+
+	years := parseDurationPart(matches[1], time.Hour*24*365)
+	months := parseDurationPart(matches[2], time.Hour*24*30)
+	days := parseDurationPart(matches[3], time.Hour*24)
+	hours := parseDurationPart(matches[4], time.Hour)
+	minutes := parseDurationPart(matches[5], time.Second*60)
+	seconds := parseDurationPart(matches[6], time.Second)
+
+	return time.Duration(years + months + days + hours + minutes + seconds)
+}
+
+func parseDurationPart(value string, unit time.Duration) time.Duration {
+	if len(value) != 0 {
+		if parsed, err := strconv.ParseFloat(value[:len(value)-1], 64); err == nil {
+			return time.Duration(float64(unit) * parsed)
+		}
+	}
+	return 0
+}
+
+// Matches the timeout and interval of the 'proxy_ready' checks, such as '30s' or '500ms'
+var readyDurationRegex = regexp.MustCompile(`^([0-9]+)(ms|s|m|h)$`)
+
+// Units of the 'proxy_ready' durations
+var readyDurationUnits = map[string]time.Duration{
+	"ms": time.Millisecond,
+	"s":  time.Second,
+	"m":  time.Minute,
+	"h":  time.Hour,
+}
+
+// parseReadyDuration converts a 'proxy_ready' duration into a time.Duration
+func parseReadyDuration(str string) time.Duration {
+	matches := readyDurationRegex.FindStringSubmatch(str)
+	if matches == nil {
+		return 0
+	}
+
+	count, _ := strconv.ParseInt(matches[1], 10, 64)
+	return time.Duration(count) * readyDurationUnits[matches[2]]
+}
+
+// Defaults of the 'proxy_ready' checks
+const (
+	defaultProxyReadyTimeout  = 30 * time.Second
+	defaultProxyReadyInterval = 500 * time.Millisecond
+)
+
+// proxyReadyData is the 'proxy_ready' attribute of a resource or data source
+type proxyReadyData struct {
+	TCP      types.String `tfsdk:"tcp"`
+	Output   types.String `tfsdk:"output"`
+	HTTP     types.String `tfsdk:"http"`
+	Timeout  types.String `tfsdk:"timeout"`
+	Interval types.String `tfsdk:"interval"`
+}
+
+// proxyReadyAttribute is the 'proxy_ready' attribute shared by everything with a proxy
+func proxyReadyAttribute() tfsdk.Attribute {
+	return tfsdk.Attribute{
+		Description: "Checks telling when the proxy is ready to accept connections, which replace sleeping for a fixed amount of time. Every configured check must pass.",
+		Optional:    true,
+		Attributes: tfsdk.SingleNestedAttributes(map[string]tfsdk.Attribute{
+			"tcp": {
+				Type:        types.StringType,
+				Description: "Address ('host:port') the proxy listens on, where '{port}' is the port picked for the proxy. The check passes once a TCP connection to it succeeds.",
+				Optional:    true,
+			},
+			"output": {
+				Type:        types.StringType,
+				Description: "Regular expression matched against each line the proxy writes to its standard output or error (e.g. 'ready for new connections'). The check passes once a line matches.",
+				Optional:    true,
+			},
+			"http": {
+				Type:        types.StringType,
+				Description: "Health check URL of the proxy, where '{port}' is the port picked for the proxy. The check passes once a GET request returns a 2xx status.",
+				Optional:    true,
+			},
+			"timeout": {
+				Type:        types.StringType,
+				Description: "How long to wait for the proxy to be ready. Format is '[0-9]+(ms|s|m|h)' (default: '30s')",
+				Optional:    true,
+				Validators: []tfsdk.AttributeValidator{
+					stringvalidator.RegexMatches(readyDurationRegex, "timeout must be in the format '[0-9]+(ms|s|m|h)'"),
+				},
+			},
+			"interval": {
+				Type:        types.StringType,
+				Description: "How long to wait between checks. Format is '[0-9]+(ms|s|m|h)' (default: '500ms')",
+				Optional:    true,
+				Validators: []tfsdk.AttributeValidator{
+					stringvalidator.RegexMatches(readyDurationRegex, "interval must be in the format '[0-9]+(ms|s|m|h)'"),
+				},
+			},
+		}),
+	}
+}
+
+// Amount of output kept from a proxy for diagnostics, and the number of lines reported
+const (
+	proxyOutputLimit = 16 * 1024
+	proxyOutputLines = 20
+)
+
+// proxyOutput receives the output of a proxy. Each line is logged, the last lines are kept
+// for diagnostics, and it records whether any line matched the 'output' readiness check.
+type proxyOutput struct {
+	ctx     context.Context
+	mutex   sync.Mutex
+	pattern *regexp.Regexp
+	line    []byte
+	matched bool
+	buffer  *boundedBuffer
+}
+
+func (o *proxyOutput) Write(p []byte) (int, error) {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+
+	o.buffer.Write(p)
+
+	o.line = append(o.line, p...)
+	for {
+		end := bytes.IndexByte(o.line, '\n')
+		if end < 0 {
+			break
+		}
+		line := bytes.TrimRight(o.line[:end], "\r")
+		tflog.Debug(o.ctx, "proxy output", map[string]interface{}{"line": string(line)})
+		if o.pattern != nil && o.pattern.Match(line) {
+			o.matched = true
+		}
+		o.line = o.line[end+1:]
+	}
+
+	return len(p), nil
+}
+
+// Matched reports whether a line of output matched the pattern
+func (o *proxyOutput) Matched() bool {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+	return o.matched
+}
+
+// Tail returns the last lines of output
+func (o *proxyOutput) Tail() string {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+
+	lines := strings.Split(strings.TrimRight(o.buffer.String(), "\n"), "\n")
+	if len(lines) > proxyOutputLines {
+		lines = lines[len(lines)-proxyOutputLines:]
+	}
+	return strings.Join(lines, "\n")
+}
+
+// proxyProcess is a running proxy command
+type proxyProcess struct {
+	args   []string
+	port   int
+	proc   *exec.Cmd
+	output *proxyOutput
+
+	// done is closed once the process exited, after which err holds its exit status
+	done chan struct{}
+	err  error
+
+	// drained is closed once all of the output was read
+	drained chan struct{}
+
+	// shared is set for the shared proxy of the provider
+	shared *sharedProxy
+
+	// tunnel is set instead of proc for SSH tunnels
+	tunnel *sshTunnel
+}
+
+// exited reports whether the proxy process is no longer running
+func (p *proxyProcess) exited() bool {
+	select {
+	case <-p.done:
+		return true
+	default:
+		return false
+	}
+}
+
+// exitDiagnostics reports an error if the proxy failed, along with its exit status and last
+// lines of output, since that is most likely why the database could not be reached. Proxies
+// which exit successfully (e.g. 'ssh -f', which forks into the background) are not errors.
+func (p *proxyProcess) exitDiagnostics() diag.Diagnostics {
+	var diags diag.Diagnostics
+
+	if p == nil || !p.exited() {
+		return diags
+	}
+
+	if p.tunnel != nil {
+		diags.AddAttributeError(path.Root("ssh_tunnel"), "ssh tunnel closed unexpectedly", p.err.Error())
+		return diags
+	}
+
+	if p.err == nil {
+		tflog.Warn(p.output.ctx, "proxy command exited successfully while it was still needed", map[string]interface{}{"args": p.args})
+		return diags
+	}
+
+	// Give the last of the output a moment to arrive, unless a child of the proxy is
+	// holding on to it
+	select {
+	case <-p.drained:
+	case <-time.After(time.Second):
+	}
+
+	diags.AddError(
+		fmt.Sprintf("proxy command exited unexpectedly: %v", p.err),
+		fmt.Sprintf("Command: %v\n\nProxy Output (last %v lines):\n%v\n\n", p.args, proxyOutputLines, p.output.Tail()),
+	)
+
+	return diags
+}
+
+// executeProxyCommand starts the proxy or SSH tunnel of a resource or data source. Without
+// either of its own, the shared proxy of the provider is used, if there is one.
+func executeProxyCommand(ctx context.Context, p alembicProvider, proxy_command types.List, proxy_sleep types.String, proxy_ready types.Object, ssh_tunnel types.Object) (*proxyProcess, diag.Diagnostics) {
+	var diags diag.Diagnostics
+	var args []string
+
+	if !ssh_tunnel.Null && !ssh_tunnel.Unknown {
+		return startSSHTunnel(ctx, ssh_tunnel)
+	}
+
+	// This is fine, we don't need a proxy command
+	if proxy_command.Null {
+		if p.proxy != nil {
+			return p.proxy.acquire(ctx)
+		}
+		return nil, diags
+	}
+
+	diags.Append(proxy_command.ElementsAs(ctx, &args, false)...)
+	if diags.HasError() {
+		return nil, diags
+	}
+
+	ready, diags := proxyReadySettings(ctx, proxy_ready)
+	if diags.HasError() {
+		return nil, diags
+	}
+
+	return startProxy(ctx, args, proxySleepDuration(proxy_sleep), ready, path.Root("proxy_ready"))
+}
+
+// Placeholder replaced with a free local port in proxy commands and readiness checks, and
+// the environment variable telling alembic about that port
+const (
+	proxyPortPlaceholder = "{port}"
+	proxyPortVariable    = "ALEMBIC_PROXY_PORT"
+)
+
+// usesProxyPort reports whether a proxy command or its readiness checks use the port placeholder
+func usesProxyPort(args []string, ready *proxyReadyData) bool {
+	for _, arg := range args {
+		if strings.Contains(arg, proxyPortPlaceholder) {
+			return true
+		}
+	}
+	return ready != nil && (strings.Contains(ready.TCP.Value, proxyPortPlaceholder) || strings.Contains(ready.HTTP.Value, proxyPortPlaceholder))
+}
+
+// substituteProxyPort replaces the port placeholder in copies of a proxy command and its
+// readiness checks
+func substituteProxyPort(args []string, ready *proxyReadyData, port int) ([]string, *proxyReadyData) {
+	value := strconv.Itoa(port)
+
+	result := make([]string, len(args))
+	for i, arg := range args {
+		result[i] = strings.ReplaceAll(arg, proxyPortPlaceholder, value)
+	}
+
+	if ready != nil {
+		substituted := *ready
+		substituted.TCP.Value = strings.ReplaceAll(ready.TCP.Value, proxyPortPlaceholder, value)
+		substituted.HTTP.Value = strings.ReplaceAll(ready.HTTP.Value, proxyPortPlaceholder, value)
+		ready = &substituted
+	}
+
+	return result, ready
+}
+
+// freeLocalPort asks the system for a TCP port which is currently free on the loopback
+// interface. Another process could still take it before the proxy listens on it.
+func freeLocalPort() (int, error) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return 0, err
+	}
+	defer listener.Close()
+
+	return listener.Addr().(*net.TCPAddr).Port, nil
+}
+
+// withProxy returns a copy of the provider which passes the port picked for a proxy, if
+// any, to alembic
+func (p alembicProvider) withProxy(proxy *proxyProcess) alembicProvider {
+	p.proxy_port = 0
+	if proxy != nil {
+		p.proxy_port = proxy.port
+	}
+	return p
+}
+
+// proxyURL replaces the port placeholder in a database URL with the port picked for the
+// proxy, if any
+func (p alembicProvider) proxyURL(url string) string {
+	if p.proxy_port == 0 {
+		return url
+	}
+	return strings.ReplaceAll(url, proxyPortPlaceholder, strconv.Itoa(p.proxy_port))
+}
+
+// proxyDatabases returns the engine URLs of a multidb project, using the port picked for
+// the proxy
+func (p alembicProvider) proxyDatabases() map[string]string {
+	if p.databases == nil {
+		return nil
+	}
+
+	databases := make(map[string]string, len(p.databases))
+	for engine, url := range p.databases {
+		databases[engine] = p.proxyURL(url)
+	}
+	return databases
+}
+
+// proxySleepDuration returns how long to wait for a proxy without readiness checks
+func proxySleepDuration(proxy_sleep types.String) time.Duration {
+	if !proxy_sleep.Null {
+		return parseDuration(proxy_sleep.Value)
+	}
+	return 5 * time.Second
+}
+
+// proxyReadySettings returns the readiness checks of a proxy, or nil if there are none
+func proxyReadySettings(ctx context.Context, proxy_ready types.Object) (*proxyReadyData, diag.Diagnostics) {
+	var diags diag.Diagnostics
+
+	if proxy_ready.Null || proxy_ready.Unknown {
+		return nil, diags
+	}
+
+	ready := &proxyReadyData{}
+	diags.Append(proxy_ready.As(ctx, ready, types.ObjectAsOptions{})...)
+	return ready, diags
+}
+
+// startProxy runs a proxy command and waits until it is ready, either by sleeping or using
+// its readiness checks, whose attribute is used to report invalid settings
+func startProxy(ctx context.Context, args []string, sleep_duration time.Duration, ready *proxyReadyData, ready_path path.Path) (*proxyProcess, diag.Diagnostics) {
+	var diags diag.Diagnostics
+	var port int
+
+	if len(args) == 0 {
+		diags.AddError("failed to start sql proxy", "The proxy command is empty.")
+		return nil, diags
+	}
+
+	// A free local port is picked for proxies using the placeholder
+	if usesProxyPort(args, ready) {
+		var err error
+		port, err = freeLocalPort()
+		if err != nil {
+			diags.AddError("failed to pick a local port for the proxy", err.Error())
+			return nil, diags
+		}
+		args, ready = substituteProxyPort(args, ready, port)
+		tflog.Debug(ctx, "picked local proxy port", map[string]interface{}{"port": port})
+	}
+
+	output := &proxyOutput{ctx: ctx, buffer: newBoundedBuffer(proxyOutputLimit)}
+	if ready != nil && !ready.Output.Null {
+		pattern, err := regexp.Compile(ready.Output.Value)
+		if err != nil {
+			diags.AddAttributeError(ready_path.AtName("output"), "invalid proxy_ready output pattern", err.Error())
+			return nil, diags
+		}
+		output.pattern = pattern
+	}
+
+	// The proxy is stopped explicitly rather than with the context, since a shared proxy
+	// outlives the operation which started it
+	proxy := &proxyProcess{
+		args:    args,
+		port:    port,
+		proc:    exec.Command(args[0], args[1:]...),
+		output:  output,
+		done:    make(chan struct{}),
+		drained: make(chan struct{}),
+	}
+
+	// The output is read through a pipe of our own rather than by exec, so that children of
+	// the proxy which keep it open do not delay noticing that the proxy itself exited
+	reader, writer, err := os.Pipe()
+	if err != nil {
+		diags.AddError("failed to create the proxy output pipe", err.Error())
+		return nil, diags
+	}
+	proxy.proc.Stdin = nil
+	proxy.proc.Stderr = writer
+	proxy.proc.Stdout = writer
+
+	err = proxy.proc.Start()
+	writer.Close()
+	if err != nil {
+		reader.Close()
+		diags.AddError(fmt.Sprintf("failed to start sql proxy: %v", args), err.Error())
+		return nil, diags
+	}
+
+	go func() {
+		io.Copy(output, reader)
+		reader.Close()
+		close(proxy.drained)
+	}()
+
+	tflog.Debug(ctx, "started proxy command", map[string]interface{}{"args": args, "pid": proxy.proc.Process.Pid})
+
+	go func() {
+		proxy.err = proxy.proc.Wait()
+		close(proxy.done)
+	}()
+
+	// Without readiness checks, wait a bit for the proxy to come alive
+	if ready == nil {
+		timer := time.NewTimer(sleep_duration)
+		select {
+		case <-proxy.done:
+			// A proxy which daemonized still needs the time to come alive
+			if proxy.err == nil {
+				<-timer.C
+			}
+		case <-timer.C:
+		}
+		timer.Stop()
+	} else {
+		diags.Append(waitForProxy(ctx, *ready, ready_path, proxy)...)
+	}
+
+	diags.Append(proxy.exitDiagnostics()...)
+	if diags.HasError() {
+		stopProxyCommand(proxy, nil)
+		return nil, diags
+	}
+
+	return proxy, diags
+}
+
+// waitForProxy polls the readiness checks of a proxy until all of them pass
+func waitForProxy(ctx context.Context, ready proxyReadyData, ready_path path.Path, proxy *proxyProcess) diag.Diagnostics {
+	var diags diag.Diagnostics
+
+	if ready.TCP.Null && ready.Output.Null && ready.HTTP.Null {
+		diags.AddAttributeError(ready_path, "missing proxy readiness check", fmt.Sprintf("At least one of 'tcp', 'output' or 'http' must be set in '%v'.", ready_path))
+		return diags
+	}
+
+	timeout := defaultProxyReadyTimeout
+	if !ready.Timeout.Null {
+		timeout = parseReadyDuration(ready.Timeout.Value)
+	}
+
+	interval := defaultProxyReadyInterval
+	if !ready.Interval.Null {
+		interval = parseReadyDuration(ready.Interval.Value)
+	}
+
+	client := http.Client{Timeout: interval}
+	deadline := time.Now().Add(timeout)
+	done := proxy.done
+
+	for {
+		var failures []string
+
+		if !ready.TCP.Null {
+			if conn, err := net.DialTimeout("tcp", ready.TCP.Value, interval); err != nil {
+				failures = append(failures, fmt.Sprintf("tcp: %v", err))
+			} else {
+				conn.Close()
+			}
+		}
+
+		if !ready.Output.Null && !proxy.output.Matched() {
+			failures = append(failures, fmt.Sprintf("output: no line matched '%v'", ready.Output.Value))
+		}
+
+		if !ready.HTTP.Null {
+			if response, err := client.Get(ready.HTTP.Value); err != nil {
+				failures = append(failures, fmt.Sprintf("http: %v", err))
+			} else {
+				response.Body.Close()
+				if response.StatusCode < 200 || response.StatusCode > 299 {
+					failures = append(failures, fmt.Sprintf("http: %v returned status %v", ready.HTTP.Value, response.Status))
+				}
+			}
+		}
+
+		if len(failures) == 0 {
+			return diags
+		}
+
+		if time.Now().After(deadline) {
+			diags.AddError(
+				"proxy did not become ready",
+				fmt.Sprintf("The proxy was still not ready after %v:\n  - %v", timeout, strings.Join(failures, "\n  - ")),
+			)
+			return diags
+		}
+
+		tflog.Trace(ctx, "waiting for the proxy to be ready", map[string]interface{}{"failures": failures})
+
+		// A failed proxy is reported by the caller, while one which exited successfully
+		// may have forked into the background, so it is still waited for
+		select {
+		case <-ctx.Done():
+			diags.AddError("proxy did not become ready", ctx.Err().Error())
+			return diags
+		case <-done:
+			if proxy.err != nil {
+				return diags
+			}
+			done = nil
+		case <-time.After(interval):
+		}
+	}
+}
+
+// stopProxyCommand kills a proxy started by executeProxyCommand, if there is one. If diags
+// is given and the proxy already exited by itself, this is reported as an error. The shared
+// proxy of the provider is only released, since other operations may still use it.
+func stopProxyCommand(proxy *proxyProcess, diags *diag.Diagnostics) {
+	if proxy == nil {
+		return
+	}
+
+	if diags != nil {
+		diags.Append(proxy.exitDiagnostics()...)
+	}
+
+	if proxy.shared != nil {
+		proxy.shared.release()
+		return
+	}
+
+	proxy.kill()
+}
+
+// kill stops the proxy process, unless it already exited
+func (p *proxyProcess) kill() {
+	if p.tunnel != nil {
+		p.tunnel.Close()
+		<-p.done
+	} else if !p.exited() {
+		p.proc.Process.Kill()
+		<-p.done
+	}
+}
+
+// proxyData is the 'proxy' provider setting
+type proxyData struct {
+	Command types.List   `tfsdk:"command"`
+	Sleep   types.String `tfsdk:"sleep"`
+	Ready   types.Object `tfsdk:"ready"`
+}
+
+// sharedProxy is the proxy of a provider configuration, used by every resource and data
+// source without a proxy command of its own. It is started once it is first needed, kept
+// running between operations, and stopped once the provider shuts down and no operation
+// uses it anymore.
+type sharedProxy struct {
+	args  []string
+	sleep time.Duration
+	ready *proxyReadyData
+
+	mutex  sync.Mutex
+	proxy  *proxyProcess
+	users  int
+	closed bool
+}
+
+func newSharedProxy(args []string, sleep time.Duration, ready *proxyReadyData) *sharedProxy {
+	return &sharedProxy{
+		args:  args,
+		sleep: sleep,
+		ready: ready,
+	}
+}
+
+// acquire returns the running proxy, starting it if needed. Concurrent operations wait for
+// the first one to start it. Each call must be paired with a call to release.
+func (s *sharedProxy) acquire(ctx context.Context) (*proxyProcess, diag.Diagnostics) {
+	var diags diag.Diagnostics
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.closed {
+		diags.AddError("failed to start the provider proxy", "The provider is shutting down.")
+		return nil, diags
+	}
+
+	// A proxy which failed since it was last used is started again. While it is in use,
+	// the failure is reported to every operation using it instead.
+	if s.proxy != nil && s.users == 0 && s.proxy.exited() && s.proxy.err != nil {
+		tflog.Warn(ctx, "restarting failed provider proxy", map[string]interface{}{"args": s.args, "error": s.proxy.err.Error()})
+		s.proxy = nil
+	}
+
+	if s.proxy == nil {
+		proxy, start_diags := startProxy(ctx, s.args, s.sleep, s.ready, path.Root("proxy").AtName("ready"))
+		diags.Append(start_diags...)
+		if diags.HasError() {
+			return nil, diags
+		}
+		proxy.shared = s
+		s.proxy = proxy
+	}
+
+	s.users++
+	return s.proxy, diags
+}
+
+// release ends the use of the proxy by one operation
+func (s *sharedProxy) release() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.users--
+	if s.closed && s.users == 0 {
+		s.stop()
+	}
+}
+
+// Close stops the proxy, or lets the last operation still using it do so
+func (s *sharedProxy) Close() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.closed = true
+	if s.users == 0 {
+		s.stop()
+	}
+}
+
+// stop kills the proxy process, if it is running. The caller must hold the mutex.
+func (s *sharedProxy) stop() {
+	if s.proxy != nil {
+		s.proxy.kill()
+		s.proxy = nil
+	}
+}
@@ -0,0 +1,540 @@
+package alembic
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/terraform-plugin-framework/attr"
+	"github.com/hashicorp/terraform-plugin-framework/path"
+	"github.com/hashicorp/terraform-plugin-framework/types"
+)
+
+func TestSubstituteProxyPort(t *testing.T) {
+	ready := &proxyReadyData{
+		TCP:  types.String{Value: "127.0.0.1:{port}"},
+		HTTP: types.String{Value: "http://127.0.0.1:{port}/health"},
+	}
+	args := []string{"cloud_sql_proxy", "-instances=project:region:db=tcp:{port}"}
+
+	if !usesProxyPort(args, nil) || !usesProxyPort(nil, ready) || usesProxyPort([]string{"ssh"}, &proxyReadyData{}) {
+		t.Fatal("unexpected placeholder detection")
+	}
+
+	substituted_args, substituted_ready := substituteProxyPort(args, ready, 15432)
+	if !reflect.DeepEqual(substituted_args, []string{"cloud_sql_proxy", "-instances=project:region:db=tcp:15432"}) {
+		t.Fatalf("unexpected arguments %v", substituted_args)
+	}
+	if substituted_ready.TCP.Value != "127.0.0.1:15432" || substituted_ready.HTTP.Value != "http://127.0.0.1:15432/health" {
+		t.Fatalf("unexpected readiness checks %v", substituted_ready)
+	}
+	if args[1] != "-instances=project:region:db=tcp:{port}" || ready.TCP.Value != "127.0.0.1:{port}" {
+		t.Fatal("the original settings were modified")
+	}
+}
+
+func TestProxyDatabaseURLs(t *testing.T) {
+	p := alembicProvider{databases: map[string]string{
+		"engine1": "postgresql://app@127.0.0.1:{port}/one",
+		"engine2": "postgresql://app@db.internal/two",
+	}}
+
+	// Without a proxy port, URLs are left alone
+	if url := p.proxyURL("postgresql://app@127.0.0.1:{port}/app"); url != "postgresql://app@127.0.0.1:{port}/app" {
+		t.Fatalf("unexpected URL %v", url)
+	}
+
+	p = p.withProxy(&proxyProcess{port: 15432})
+	if url := p.proxyURL("postgresql://app@127.0.0.1:{port}/app"); url != "postgresql://app@127.0.0.1:15432/app" {
+		t.Fatalf("unexpected URL %v", url)
+	}
+
+	expected := map[string]string{
+		"engine1": "postgresql://app@127.0.0.1:15432/one",
+		"engine2": "postgresql://app@db.internal/two",
+	}
+	if databases := p.proxyDatabases(); !reflect.DeepEqual(databases, expected) {
+		t.Fatalf("unexpected databases %v", databases)
+	}
+	if p.databases["engine1"] != "postgresql://app@127.0.0.1:{port}/one" {
+		t.Fatal("the configured databases were modified")
+	}
+
+	request, diags := newWorkerRequest(context.Background(), p, types.Map{Null: true}, types.Map{Null: true}, "current")
+	if diags.HasError() {
+		t.Fatal(diags)
+	}
+	if !reflect.DeepEqual(request.Databases, expected) || request.Environment[proxyPortVariable] != "15432" {
+		t.Fatalf("unexpected worker request %+v", request)
+	}
+}
+
+func TestParseReadyDuration(t *testing.T) {
+	tests := map[string]time.Duration{
+		"500ms": 500 * time.Millisecond,
+		"30s":   30 * time.Second,
+		"2m":    2 * time.Minute,
+		"1h":    time.Hour,
+		"1.5s":  0,
+		"10":    0,
+		"1d":    0,
+		"":      0,
+	}
+
+	for value, expected := range tests {
+		if duration := parseReadyDuration(value); duration != expected {
+			t.Fatalf("expected %q to be %v, got %v", value, expected, duration)
+		}
+	}
+}
+
+// testReady returns readiness checks with the given values set, and every other one null
+func testReady(values map[string]string) *proxyReadyData {
+	value := func(name string) types.String {
+		if v, ok := values[name]; ok {
+			return types.String{Value: v}
+		}
+		return types.String{Null: true}
+	}
+
+	return &proxyReadyData{
+		TCP:      value("tcp"),
+		Output:   value("output"),
+		HTTP:     value("http"),
+		Timeout:  value("timeout"),
+		Interval: value("interval"),
+	}
+}
+
+func TestStartProxyReady(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+
+	healthy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer healthy.Close()
+
+	tests := []struct {
+		name  string
+		args  []string
+		ready *proxyReadyData
+	}{
+		{
+			name:  "tcp",
+			args:  []string{"sleep", "30"},
+			ready: testReady(map[string]string{"tcp": listener.Addr().String()}),
+		},
+		{
+			name:  "output",
+			args:  []string{"sh", "-c", "echo starting; sleep 0.2; echo 'ready for new connections' >&2; sleep 30"},
+			ready: testReady(map[string]string{"output": "^ready for", "interval": "50ms"}),
+		},
+		{
+			name:  "http",
+			args:  []string{"sleep", "30"},
+			ready: testReady(map[string]string{"http": healthy.URL}),
+		},
+		{
+			name:  "every check on the picked port",
+			args:  []string{"python3", "-u", "-m", "http.server", "--bind", "127.0.0.1", "{port}"},
+			ready: testReady(map[string]string{"tcp": "127.0.0.1:{port}", "http": "http://127.0.0.1:{port}/", "output": "Serving HTTP", "interval": "100ms"}),
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			proxy, diags := startProxy(context.Background(), test.args, time.Minute, test.ready, path.Root("proxy_ready"))
+			if diags.HasError() {
+				t.Fatal(diags)
+			}
+			defer stopProxyCommand(proxy, nil)
+
+			if proxy.exited() {
+				t.Fatal("expected the proxy to be running")
+			}
+		})
+	}
+}
+
+func TestStartProxyReadyErrors(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	closed := listener.Addr().String()
+	listener.Close()
+
+	unhealthy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer unhealthy.Close()
+
+	tests := []struct {
+		name    string
+		args    []string
+		ready   *proxyReadyData
+		summary string
+		detail  string
+	}{
+		{
+			name:    "empty command",
+			args:    []string{},
+			ready:   nil,
+			summary: "failed to start sql proxy",
+			detail:  "The proxy command is empty.",
+		},
+		{
+			name:    "no checks",
+			args:    []string{"sleep", "30"},
+			ready:   testReady(nil),
+			summary: "missing proxy readiness check",
+		},
+		{
+			name:    "invalid output pattern",
+			args:    []string{"sleep", "30"},
+			ready:   testReady(map[string]string{"output": "("}),
+			summary: "invalid proxy_ready output pattern",
+		},
+		{
+			name:    "tcp timeout",
+			args:    []string{"sleep", "30"},
+			ready:   testReady(map[string]string{"tcp": closed, "timeout": "200ms", "interval": "50ms"}),
+			summary: "proxy did not become ready",
+			detail:  "The proxy was still not ready after 200ms:\n  - tcp: ",
+		},
+		{
+			name:    "unhealthy",
+			args:    []string{"sleep", "30"},
+			ready:   testReady(map[string]string{"http": unhealthy.URL, "timeout": "200ms", "interval": "50ms"}),
+			summary: "proxy did not become ready",
+			detail:  "returned status 503 Service Unavailable",
+		},
+		{
+			name:    "output never matching",
+			args:    []string{"sh", "-c", "echo starting; sleep 30"},
+			ready:   testReady(map[string]string{"output": "ready", "timeout": "200ms", "interval": "50ms"}),
+			summary: "proxy did not become ready",
+			detail:  "output: no line matched 'ready'",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			proxy, diags := startProxy(context.Background(), test.args, time.Minute, test.ready, path.Root("proxy_ready"))
+			if proxy != nil {
+				stopProxyCommand(proxy, nil)
+				t.Fatal("expected the proxy to fail")
+			}
+
+			if !diags.HasError() || diags.Errors()[0].Summary() != test.summary || !strings.Contains(diags.Errors()[0].Detail(), test.detail) {
+				t.Fatalf("expected error %q containing %q, got %v", test.summary, test.detail, diags)
+			}
+
+		})
+	}
+}
+
+func TestStartProxyReadyCancelled(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+
+	proxy, diags := startProxy(ctx, []string{"sleep", "30"}, time.Minute, testReady(map[string]string{"output": "ready", "interval": "50ms"}), path.Root("proxy_ready"))
+	if proxy != nil {
+		stopProxyCommand(proxy, nil)
+		t.Fatal("expected the proxy to fail")
+	}
+	if !diags.HasError() || diags[0].Summary() != "proxy did not become ready" || diags[0].Detail() != context.DeadlineExceeded.Error() {
+		t.Fatalf("expected the wait to be cancelled, got %v", diags)
+	}
+}
+
+func TestProxyOutput(t *testing.T) {
+	output := &proxyOutput{ctx: context.Background(), pattern: regexp.MustCompile("^ready$"), buffer: newBoundedBuffer(proxyOutputLimit)}
+
+	// Lines may be split across writes, and carriage returns are ignored
+	output.Write([]byte("starting\r\nrea"))
+	if output.Matched() {
+		t.Fatal("expected no match for an incomplete line")
+	}
+	output.Write([]byte("dy\r\n"))
+	if !output.Matched() {
+		t.Fatal("expected the completed line to match")
+	}
+
+	for i := 0; i < proxyOutputLines+5; i++ {
+		fmt.Fprintf(output, "line %v\n", i)
+	}
+
+	lines := strings.Split(output.Tail(), "\n")
+	if len(lines) != proxyOutputLines || lines[0] != "line 5" || lines[len(lines)-1] != fmt.Sprintf("line %v", proxyOutputLines+4) {
+		t.Fatalf("unexpected tail %q", output.Tail())
+	}
+}
+
+func TestStartProxyExit(t *testing.T) {
+	tests := []struct {
+		name    string
+		args    []string
+		summary string
+		detail  string
+	}{
+		{
+			name:    "failing proxy",
+			args:    []string{"sh", "-c", "echo 'could not connect to instance' >&2; exit 3"},
+			summary: "proxy command exited unexpectedly: exit status 3",
+			detail:  "Proxy Output (last 20 lines):\ncould not connect to instance\n",
+		},
+		{
+			name: "proxy forking into the background",
+			args: []string{"sh", "-c", "exit 0"},
+		},
+		{
+			name: "running proxy",
+			args: []string{"sleep", "30"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			// A failing proxy is reported without waiting for the whole sleep
+			started := time.Now()
+			proxy, diags := startProxy(context.Background(), test.args, 200*time.Millisecond, nil, path.Root("proxy_ready"))
+
+			if test.summary == "" {
+				if diags.HasError() {
+					t.Fatal(diags)
+				}
+				stopProxyCommand(proxy, &diags)
+				if diags.HasError() {
+					t.Fatal(diags)
+				}
+				return
+			}
+
+			if proxy != nil {
+				stopProxyCommand(proxy, nil)
+				t.Fatal("expected the proxy to fail")
+			}
+			if !diags.HasError() || diags[0].Summary() != test.summary || !strings.Contains(diags[0].Detail(), test.detail) {
+				t.Fatalf("expected error %q containing %q, got %v", test.summary, test.detail, diags)
+			}
+			if elapsed := time.Since(started); elapsed >= 200*time.Millisecond {
+				t.Fatalf("took %v to notice the proxy failed", elapsed)
+			}
+		})
+	}
+}
+
+// A proxy which crashes while it is in use is reported once it is stopped
+func TestStopProxyCommandCrashed(t *testing.T) {
+	proxy, diags := startProxy(context.Background(), []string{"sh", "-c", "sleep 0.1; echo 'connection reset'; exit 1"}, 0, nil, path.Root("proxy_ready"))
+	if diags.HasError() {
+		t.Fatal(diags)
+	}
+
+	<-proxy.done
+
+	stopProxyCommand(proxy, &diags)
+	if !diags.HasError() || diags[0].Summary() != "proxy command exited unexpectedly: exit status 1" || !strings.Contains(diags[0].Detail(), "connection reset") {
+		t.Fatalf("expected the crash to be reported, got %v", diags)
+	}
+
+	// Without diagnostics to report to, a crashed proxy is ignored
+	stopProxyCommand(proxy, nil)
+}
+
+// testSharedProxy returns a shared proxy which records each time it is started, and is ready
+// once it did so
+func testSharedProxy(t *testing.T, script string) (*sharedProxy, func() int) {
+	t.Helper()
+
+	record := filepath.Join(t.TempDir(), "starts")
+	ready := testReady(map[string]string{"output": "^ready$", "interval": "10ms"})
+	shared := newSharedProxy([]string{"sh", "-c", "echo started >> '" + record + "'; echo ready; " + script}, 0, ready)
+	t.Cleanup(shared.Close)
+
+	starts := func() int {
+		contents, err := os.ReadFile(record)
+		if os.IsNotExist(err) {
+			return 0
+		} else if err != nil {
+			t.Fatal(err)
+		}
+		return strings.Count(string(contents), "started")
+	}
+
+	return shared, starts
+}
+
+func TestSharedProxy(t *testing.T) {
+	shared, starts := testSharedProxy(t, "sleep 30")
+	ctx := context.Background()
+
+	// Concurrent operations share a single process
+	var wait sync.WaitGroup
+	proxies := make([]*proxyProcess, 4)
+	for i := range proxies {
+		wait.Add(1)
+		go func(i int) {
+			defer wait.Done()
+			proxy, diags := shared.acquire(ctx)
+			if diags.HasError() {
+				t.Error(diags)
+			}
+			proxies[i] = proxy
+		}(i)
+	}
+	wait.Wait()
+
+	for _, proxy := range proxies {
+		if proxy == nil || proxy != proxies[0] {
+			t.Fatal("expected every operation to use the same proxy")
+		}
+	}
+	if starts() != 1 || shared.users != len(proxies) {
+		t.Fatalf("expected one start and %v users, got %v starts and %v users", len(proxies), starts(), shared.users)
+	}
+
+	// The proxy keeps running between operations
+	for _, proxy := range proxies {
+		stopProxyCommand(proxy, nil)
+	}
+	if shared.users != 0 || proxies[0].exited() {
+		t.Fatal("expected the proxy to keep running without users")
+	}
+
+	proxy, diags := shared.acquire(ctx)
+	if diags.HasError() {
+		t.Fatal(diags)
+	}
+	if proxy != proxies[0] || starts() != 1 {
+		t.Fatal("expected the running proxy to be reused")
+	}
+
+	// Closing the provider leaves the proxy to the last operation using it
+	shared.Close()
+	if proxy.exited() {
+		t.Fatal("expected the proxy to keep running while it is in use")
+	}
+	if _, diags := shared.acquire(ctx); !diags.HasError() || diags[0].Detail() != "The provider is shutting down." {
+		t.Fatalf("expected acquiring a closed proxy to fail, got %v", diags)
+	}
+
+	stopProxyCommand(proxy, nil)
+	if !proxy.exited() || shared.proxy != nil {
+		t.Fatal("expected the last operation to stop the proxy")
+	}
+}
+
+func TestSharedProxyClose(t *testing.T) {
+	shared, _ := testSharedProxy(t, "sleep 30")
+
+	proxy, diags := shared.acquire(context.Background())
+	if diags.HasError() {
+		t.Fatal(diags)
+	}
+	shared.release()
+
+	// Without users, closing stops the proxy right away
+	shared.Close()
+	if !proxy.exited() {
+		t.Fatal("expected the proxy to be stopped")
+	}
+}
+
+func TestSharedProxyRestart(t *testing.T) {
+	shared, starts := testSharedProxy(t, "sleep 0.1; exit 1")
+	ctx := context.Background()
+
+	proxy, diags := shared.acquire(ctx)
+	if diags.HasError() {
+		t.Fatal(diags)
+	}
+	<-proxy.done
+
+	// While the crashed proxy is in use, its failure is reported rather than hidden
+	// behind a new process
+	other, diags := shared.acquire(ctx)
+	if diags.HasError() {
+		t.Fatal(diags)
+	}
+	if other != proxy || starts() != 1 {
+		t.Fatal("expected the crashed proxy to be kept while it is in use")
+	}
+	if diags := other.exitDiagnostics(); !diags.HasError() {
+		t.Fatal("expected the crash to be reported")
+	}
+	stopProxyCommand(other, nil)
+	stopProxyCommand(proxy, nil)
+
+	// Once nothing uses it anymore, the next operation starts a new one
+	restarted, diags := shared.acquire(ctx)
+	if diags.HasError() {
+		t.Fatal(diags)
+	}
+	defer stopProxyCommand(restarted, nil)
+	if restarted == proxy || starts() != 2 {
+		t.Fatal("expected the crashed proxy to be restarted")
+	}
+}
+
+func TestSharedProxyStartFailure(t *testing.T) {
+	shared := newSharedProxy([]string{filepath.Join(t.TempDir(), "missing")}, 0, nil)
+	defer shared.Close()
+
+	if _, diags := shared.acquire(context.Background()); !diags.HasError() {
+		t.Fatal("expected the proxy to fail starting")
+	}
+	if shared.users != 0 || shared.proxy != nil {
+		t.Fatalf("expected no users of a proxy which failed to start, got %v", shared.users)
+	}
+}
+
+// Resources and data sources without a proxy of their own use the shared proxy
+func TestExecuteProxyCommandShared(t *testing.T) {
+	shared, starts := testSharedProxy(t, "sleep 30")
+	p := alembicProvider{proxy: shared}
+
+	proxy, diags := executeProxyCommand(context.Background(), p, types.List{ElemType: types.StringType, Null: true}, types.String{Null: true}, types.Object{Null: true}, types.Object{Null: true})
+	if diags.HasError() {
+		t.Fatal(diags)
+	}
+	if proxy.shared != shared || starts() != 1 {
+		t.Fatal("expected the shared proxy")
+	}
+
+	stopProxyCommand(proxy, &diags)
+	if diags.HasError() || proxy.exited() || shared.users != 0 {
+		t.Fatal("expected the shared proxy to be released rather than stopped")
+	}
+
+	// A proxy command of the resource replaces the shared proxy
+	ready_types := map[string]attr.Type{"tcp": types.StringType, "output": types.StringType, "http": types.StringType, "timeout": types.StringType, "interval": types.StringType}
+	ready := types.Object{AttrTypes: ready_types, Attrs: map[string]attr.Value{
+		"tcp":      types.String{Null: true},
+		"output":   types.String{Value: "^ready$"},
+		"http":     types.String{Null: true},
+		"timeout":  types.String{Null: true},
+		"interval": types.String{Value: "10ms"},
+	}}
+	own, diags := executeProxyCommand(context.Background(), p, stringList([]string{"sh", "-c", "echo ready; sleep 30"}), types.String{Null: true}, ready, types.Object{Null: true})
+	if diags.HasError() {
+		t.Fatal(diags)
+	}
+	defer stopProxyCommand(own, nil)
+	if own.shared != nil || starts() != 1 {
+		t.Fatal("expected a proxy of its own")
+	}
+}
@@ -0,0 +1,368 @@
+package alembic
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Matches relative revision specifications such as "+2", "ae10-1" or "branch@head+1"
+var relativeSpecRegex = regexp.MustCompile(`^(?:([^@]+)@)?(\w+)?([+-]\d+)$`)
+
+// isCurrentRelative reports whether a revision specification (or any specification of a
+// comma separated list) is relative to the current revision of the database (e.g. "+1",
+// "-2" or "branch@+1"), rather than to a fixed anchor.
+func isCurrentRelative(spec string) bool {
+	for _, part := range splitSpecs(spec) {
+		if match := relativeSpecRegex.FindStringSubmatch(part); match != nil && match[2] == "" {
+			return true
+		}
+	}
+	return false
+}
+
+// splitSpecs splits a comma separated list of revision specifications
+func splitSpecs(spec string) []string {
+	var specs []string
+	for _, part := range strings.Split(spec, ",") {
+		specs = append(specs, strings.TrimSpace(part))
+	}
+	return specs
+}
+
+// resolve converts a revision specification into concrete revision IDs using the same
+// grammar as alembic: "head", "heads", "base", full or partial revision IDs, branch labels,
+// "branch@head", "branch@<revision>" and relative specifications such as "ae10+2", "-1"
+// or "branch@head-1". Specifications relative to the database are resolved against the
+// given current revisions. A comma separated list of specifications (e.g. one per branch)
+// resolves to every revision they resolve to. An empty result means "base", i.e. no
+// revision at all.
+func (g *revisionGraph) resolve(spec string, current []string) ([]string, error) {
+	if strings.Contains(spec, ",") {
+		return g.resolveList(splitSpecs(spec), current)
+	}
+
+	if spec == "" {
+		return nil, fmt.Errorf("empty revision specification")
+	}
+
+	if match := relativeSpecRegex.FindStringSubmatch(spec); match != nil {
+		return g.resolveRelative(match[1], match[2], match[3], current)
+	}
+
+	branch, symbol, qualified := strings.Cut(spec, "@")
+	if !qualified {
+		branch, symbol = "", spec
+	} else if _, ok := g.labels[branch]; !ok {
+		return nil, fmt.Errorf("no such branch: '%v'", branch)
+	}
+
+	switch symbol {
+	case "heads":
+		return g.filterBranch(g.heads(), branch), nil
+	case "head":
+		heads := g.filterBranch(g.heads(), branch)
+		if len(heads) > 1 {
+			return nil, fmt.Errorf("multiple heads are present for '%v' (%v); please specify a single target revision, or use 'heads'", spec, strings.Join(heads, ", "))
+		}
+		return heads, nil
+	case "base":
+		return []string{}, nil
+	}
+
+	if _, ok := g.revisions[symbol]; ok {
+		if branch != "" && !g.hasLabel(symbol, branch) {
+			return nil, fmt.Errorf("revision '%v' is not a member of branch '%v'", symbol, branch)
+		}
+		return []string{symbol}, nil
+	}
+
+	if id, ok := g.labels[symbol]; ok && !qualified {
+		return []string{id}, nil
+	}
+
+	// Partial revision identifiers, as long as they are unambiguous
+	var candidates []string
+	for _, id := range g.order {
+		if len(id) > 3 && strings.HasPrefix(id, symbol) && (branch == "" || g.hasLabel(id, branch)) {
+			candidates = append(candidates, id)
+		}
+	}
+
+	if len(candidates) > 1 {
+		return nil, fmt.Errorf("multiple revisions start with '%v': %v", symbol, strings.Join(candidates, ", "))
+	} else if len(candidates) == 0 {
+		return nil, fmt.Errorf("no such revision or branch '%v'", symbol)
+	}
+
+	return candidates, nil
+}
+
+// resolveList resolves several revision specifications, returning the distinct revisions
+// in graph order (i.e. heads first), without those implied by others
+func (g *revisionGraph) resolveList(specs []string, current []string) ([]string, error) {
+	resolved := make(map[string]bool)
+	for _, spec := range specs {
+		ids, err := g.resolve(spec, current)
+		if err != nil {
+			return nil, err
+		}
+		for _, id := range ids {
+			resolved[id] = true
+		}
+	}
+
+	// A revision is implied by any of its descendants, and would never be current along
+	// with them
+	for id := range resolved {
+		for _, ancestor := range g.ancestors(id) {
+			if ancestor != id {
+				delete(resolved, ancestor)
+			}
+		}
+	}
+
+	result := []string{}
+	for _, id := range g.order {
+		if resolved[id] {
+			result = append(result, id)
+		}
+	}
+
+	return result, nil
+}
+
+func (g *revisionGraph) resolveRelative(branch string, symbol string, relative string, current []string) ([]string, error) {
+	var start []string
+
+	steps, err := strconv.Atoi(relative)
+	if err != nil {
+		return nil, fmt.Errorf("invalid relative revision '%v': %v", relative, err)
+	}
+
+	if branch != "" {
+		if _, ok := g.labels[branch]; !ok {
+			return nil, fmt.Errorf("no such branch: '%v'", branch)
+		}
+	}
+
+	if symbol == "" {
+		// Relative to the current revision of the database
+		for _, id := range current {
+			if _, ok := g.revisions[id]; !ok {
+				return nil, fmt.Errorf("current revision '%v' is not present in the migration scripts", id)
+			}
+		}
+		start = g.filterBranch(current, branch)
+	} else {
+		anchor := symbol
+		if branch != "" {
+			anchor = branch + "@" + symbol
+		}
+
+		start, err = g.resolve(anchor, current)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if len(start) > 1 {
+		return nil, fmt.Errorf("ambiguous relative revision '%v' from multiple revisions (%v); please qualify it with a branch label", relative, strings.Join(start, ", "))
+	}
+
+	var from string
+	if len(start) == 1 {
+		from = start[0]
+	}
+
+	id, err := g.walk(from, steps, branch)
+	if err != nil {
+		return nil, err
+	} else if id == "" {
+		return []string{}, nil
+	}
+
+	return []string{id}, nil
+}
+
+// walk moves the given number of steps up (positive) or down (negative) the revision graph
+// starting at a revision, or at the base if start is empty. An empty result is the base.
+func (g *revisionGraph) walk(start string, steps int, branch string) (string, error) {
+	current := start
+
+	for ; steps > 0; steps-- {
+		var next []string
+		if current == "" {
+			next = g.bases()
+		} else {
+			next = g.children[current]
+		}
+
+		next = g.filterBranch(next, branch)
+		if len(next) == 0 {
+			return "", fmt.Errorf("relative revision walks past the head revision '%v'", current)
+		} else if len(next) > 1 {
+			return "", fmt.Errorf("ambiguous walk from '%v' which is revised by multiple revisions (%v)", describeRevisions([]string{current}), strings.Join(next, ", "))
+		}
+
+		current = next[0]
+	}
+
+	for ; steps < 0; steps++ {
+		if current == "" {
+			return "", fmt.Errorf("relative revision walks past base")
+		}
+
+		down := g.revisions[current].DownRevisions
+		if len(down) > 1 {
+			return "", fmt.Errorf("ambiguous walk from merge point '%v' (%v)", current, strings.Join(down, ", "))
+		} else if len(down) == 0 {
+			current = ""
+		} else {
+			current = down[0]
+		}
+	}
+
+	return current, nil
+}
+
+// bases returns the revisions which do not revise any other revision
+func (g *revisionGraph) bases() []string {
+	var bases []string
+	for _, id := range g.order {
+		if len(g.revisions[id].DownRevisions) == 0 {
+			bases = append(bases, id)
+		}
+	}
+	return bases
+}
+
+// filterBranch limits a list of revisions to those carrying a branch label, if one is given
+func (g *revisionGraph) filterBranch(ids []string, branch string) []string {
+	if branch == "" {
+		return ids
+	}
+
+	var result []string
+	for _, id := range ids {
+		if _, ok := g.revisions[id]; ok && g.hasLabel(id, branch) {
+			result = append(result, id)
+		}
+	}
+	return result
+}
+
+// satisfies reports whether a database stamped with the current revisions is at the
+// desired revisions. Current revisions on branches unrelated to the desired revisions are
+// ignored, so that a target on one branch is unaffected by the state of other branches,
+// unless they depend on revisions past the desired revisions.
+func (g *revisionGraph) satisfies(current []string, desired []string) bool {
+	if len(desired) == 0 {
+		return len(current) == 0
+	}
+
+	stamped := make(map[string]bool)
+	for _, id := range current {
+		if _, ok := g.revisions[id]; !ok {
+			return false
+		}
+		stamped[id] = true
+	}
+
+	wanted := make(map[string]bool)
+	for _, id := range desired {
+		if !stamped[id] {
+			return false
+		}
+		wanted[id] = true
+	}
+
+	for _, id := range current {
+		if wanted[id] {
+			continue
+		}
+		for _, target := range desired {
+			if g.related(id, target) {
+				return false
+			}
+		}
+	}
+
+	return len(g.reverted(current, desired)) == 0
+}
+
+// Maximum number of close matches suggested for an invalid revision specification
+const maxSuggestions = 5
+
+// suggestions returns revisions and branch labels resembling an invalid revision
+// specification: revision IDs or labels sharing a prefix with it, and revisions whose
+// message contains it.
+func (g *revisionGraph) suggestions(spec string) []string {
+	var result []string
+	seen := make(map[string]bool)
+
+	add := func(suggestion string) {
+		if !seen[suggestion] && len(result) < maxSuggestions {
+			seen[suggestion] = true
+			result = append(result, suggestion)
+		}
+	}
+
+	// Only the symbol itself matters, not a branch qualifier or relative suffix
+	symbol := spec
+	if match := relativeSpecRegex.FindStringSubmatch(spec); match != nil {
+		symbol = match[2]
+	} else if index := strings.LastIndex(spec, "@"); index >= 0 {
+		symbol = spec[index+1:]
+	}
+	symbol = strings.ToLower(symbol)
+	if symbol == "" {
+		return nil
+	}
+
+	for _, id := range g.order {
+		lower := strings.ToLower(id)
+		if strings.HasPrefix(lower, symbol) || (len(symbol) > 3 && strings.HasPrefix(symbol, lower)) {
+			add(id)
+		}
+	}
+
+	var labels []string
+	for label := range g.labels {
+		labels = append(labels, label)
+	}
+	sort.Strings(labels)
+
+	for _, label := range labels {
+		lower := strings.ToLower(label)
+		if strings.HasPrefix(lower, symbol) || strings.HasPrefix(symbol, lower) {
+			add(label)
+		}
+	}
+
+	for _, id := range g.order {
+		if strings.Contains(strings.ToLower(g.revisions[id].Message), symbol) {
+			add(id)
+		}
+	}
+
+	return result
+}
+
+// describeRevision formats a single revision and its message for display
+func describeRevision(revision alembicRevision) string {
+	if revision.Message == "" {
+		return revision.Revision
+	}
+	return fmt.Sprintf("%v (%v)", revision.Revision, strings.Join(strings.Fields(revision.Message), " "))
+}
+
+// describeRevisions formats a set of revisions for display, where no revisions is "base"
+func describeRevisions(ids []string) string {
+	if len(ids) == 0 || (len(ids) == 1 && ids[0] == "") {
+		return "base"
+	}
+	return strings.Join(ids, ",")
+}
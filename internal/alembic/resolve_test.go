@@ -0,0 +1,180 @@
+package alembic
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// Two independent branches: 'main' with four revisions and 'feature' with two, whose IDs
+// share a prefix
+const (
+	mainBase   = "1975ea83b712"
+	mainSecond = "ae1027a6acf0"
+	mainThird  = "27c6a30d7c24"
+	mainHead   = "3cd5b1a7fe01"
+
+	featureBase = "f5a0b1c2d3e4"
+	featureHead = "f5a0b9e8d7c6"
+)
+
+func testGraph(t *testing.T) *revisionGraph {
+	t.Helper()
+
+	graph, err := newRevisionGraph([]alembicRevision{
+		{Revision: mainBase, BranchLabels: []string{"main"}, Message: "create users"},
+		{Revision: mainSecond, DownRevisions: []string{mainBase}, Message: "add email"},
+		{Revision: mainThird, DownRevisions: []string{mainSecond}, Message: "add orders"},
+		{Revision: mainHead, DownRevisions: []string{mainThird}, Message: "index orders"},
+		{Revision: featureBase, BranchLabels: []string{"feature"}, Message: "create flags"},
+		{Revision: featureHead, DownRevisions: []string{featureBase}, Message: "add flag owner"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return graph
+}
+
+func TestResolve(t *testing.T) {
+	graph := testGraph(t)
+
+	tests := []struct {
+		spec     string
+		current  []string
+		expected []string
+		err      string
+	}{
+		// Symbolic names
+		{spec: "heads", expected: []string{mainHead, featureHead}},
+		{spec: "head", err: "multiple heads are present"},
+		{spec: "base", expected: []string{}},
+		{spec: "main@head", expected: []string{mainHead}},
+		{spec: "feature@heads", expected: []string{featureHead}},
+		{spec: "feature@base", expected: []string{}},
+		{spec: "missing@head", err: "no such branch: 'missing'"},
+
+		// Revision IDs, partial IDs and branch labels
+		{spec: mainThird, expected: []string{mainThird}},
+		{spec: "27c6", expected: []string{mainThird}},
+		{spec: "main@27c6", expected: []string{mainThird}},
+		{spec: "feature@27c6", err: "no such revision or branch '27c6'"},
+		{spec: "main@" + featureHead, err: "is not a member of branch 'main'"},
+		{spec: "f5a0", err: "multiple revisions start with 'f5a0'"},
+		{spec: "f5a0b9", expected: []string{featureHead}},
+		{spec: "ae1", expected: []string{mainSecond}},
+		{spec: "2", expected: []string{mainThird}},
+		{spec: "f5a", err: "multiple revisions start with 'f5a'"},
+		{spec: "feature", expected: []string{featureBase}},
+		{spec: "missing", err: "no such revision or branch 'missing'"},
+
+		// Relative to a fixed anchor
+		{spec: "ae10+2", expected: []string{mainHead}},
+		{spec: "ae10-1", expected: []string{mainBase}},
+		{spec: "ae10-2", expected: []string{}},
+		{spec: "ae10-3", err: "walks past base"},
+		{spec: "main@head-1", expected: []string{mainThird}},
+		{spec: "main@head+1", err: "walks past the head revision"},
+		{spec: "main@base+1", expected: []string{mainBase}},
+		{spec: "base+1", err: "ambiguous walk"},
+
+		// Relative to the current revisions of the database
+		{spec: "-1", current: []string{mainThird}, expected: []string{mainSecond}},
+		{spec: "+1", current: []string{mainThird}, expected: []string{mainHead}},
+		{spec: "+1", current: []string{}, err: "ambiguous walk"},
+		{spec: "-1", current: []string{mainThird, featureHead}, err: "ambiguous relative revision"},
+		{spec: "feature@+1", current: []string{mainThird, featureBase}, expected: []string{featureHead}},
+		{spec: "main@-1", current: []string{mainThird, featureBase}, expected: []string{mainSecond}},
+		{spec: "main@+1", current: []string{mainHead, featureBase}, err: "walks past the head revision"},
+		{spec: "-1", current: []string{"000000000000"}, err: "is not present in the migration scripts"},
+
+		// Comma separated lists
+		{spec: "main@head,feature@head", expected: []string{mainHead, featureHead}},
+		{spec: "feature@head, main@head", expected: []string{mainHead, featureHead}},
+		{spec: mainSecond + "," + mainHead, expected: []string{mainHead}},
+		{spec: "main@head,feature@base", expected: []string{mainHead}},
+		{spec: "main@head,missing", err: "no such revision or branch 'missing'"},
+		{spec: "main@-1,feature@+1", current: []string{mainThird, featureBase}, expected: []string{mainSecond, featureHead}},
+
+		{spec: "", err: "empty revision specification"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.spec, func(t *testing.T) {
+			result, err := graph.resolve(test.spec, test.current)
+			if test.err != "" {
+				if err == nil || !strings.Contains(err.Error(), test.err) {
+					t.Fatalf("expected error containing %q, got %v (%v)", test.err, err, result)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !reflect.DeepEqual(result, test.expected) {
+				t.Fatalf("expected %v, got %v", test.expected, result)
+			}
+		})
+	}
+}
+
+func TestIsCurrentRelative(t *testing.T) {
+	tests := map[string]bool{
+		"+1":                     true,
+		"-2":                     true,
+		"feature@+1":             true,
+		"main@head,feature@-1":   true,
+		"ae10+2":                 false,
+		"main@head-1":            false,
+		"head":                   false,
+		"main@head,feature@head": false,
+	}
+
+	for spec, expected := range tests {
+		if result := isCurrentRelative(spec); result != expected {
+			t.Errorf("isCurrentRelative(%q) = %v, expected %v", spec, result, expected)
+		}
+	}
+}
+
+func TestSatisfies(t *testing.T) {
+	graph := testGraph(t)
+
+	tests := []struct {
+		name     string
+		current  []string
+		desired  []string
+		expected bool
+	}{
+		{name: "at the target", current: []string{mainHead}, desired: []string{mainHead}, expected: true},
+		{name: "behind the target", current: []string{mainThird}, desired: []string{mainHead}, expected: false},
+		{name: "ahead of the target", current: []string{mainHead}, desired: []string{mainThird}, expected: false},
+		{name: "unrelated branch drift", current: []string{mainHead, featureBase}, desired: []string{mainHead}, expected: true},
+		{name: "related branch drift", current: []string{mainHead, featureBase}, desired: []string{mainHead, featureHead}, expected: false},
+		{name: "every branch", current: []string{featureHead, mainHead}, desired: []string{mainHead, featureHead}, expected: true},
+		{name: "base", current: []string{}, desired: []string{}, expected: true},
+		{name: "not at base", current: []string{mainBase}, desired: []string{}, expected: false},
+		{name: "unknown revision", current: []string{"000000000000"}, desired: []string{mainHead}, expected: false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if result := graph.satisfies(test.current, test.desired); result != test.expected {
+				t.Fatalf("expected %v, got %v", test.expected, result)
+			}
+		})
+	}
+}
+
+func TestDescribeRevisions(t *testing.T) {
+	if result := describeRevisions(nil); result != "base" {
+		t.Fatalf("unexpected description %q", result)
+	}
+	if result := describeRevisions([]string{mainHead, featureHead}); result != mainHead+","+featureHead {
+		t.Fatalf("unexpected description %q", result)
+	}
+	if result := describeRevision(alembicRevision{Revision: mainBase, Message: "create\n  users"}); result != mainBase+" (create users)" {
+		t.Fatalf("unexpected description %q", result)
+	}
+}
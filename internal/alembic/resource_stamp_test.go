@@ -0,0 +1,107 @@
+package alembic
+
+import (
+	"context"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/hashicorp/terraform-plugin-framework/types"
+)
+
+func TestResourceStampTargets(t *testing.T) {
+	tests := []struct {
+		name     string
+		target   string
+		current  []string
+		expected []string
+		resolved []string
+	}{
+		{
+			name:     "single target",
+			target:   "main@head",
+			expected: []string{"stamp", mainHead},
+			resolved: []string{mainHead},
+		},
+		{
+			name:     "several targets",
+			target:   "main@head,feature@head",
+			expected: []string{"stamp", mainHead, featureHead},
+			resolved: []string{mainHead, featureHead},
+		},
+		{
+			name:     "several targets at base",
+			target:   "main@base,feature@base",
+			expected: []string{"stamp", "base"},
+			resolved: []string{},
+		},
+		{
+			name:     "partial revision",
+			target:   "27c6",
+			expected: []string{"stamp", mainThird},
+			resolved: []string{mainThird},
+		},
+		{
+			name:     "partial revision and branch head",
+			target:   "27c6,feature@head",
+			expected: []string{"stamp", mainThird, featureHead},
+			resolved: []string{mainThird, featureHead},
+		},
+		{
+			name:     "next revision",
+			target:   "+1",
+			current:  []string{mainThird},
+			expected: []string{"stamp", mainHead},
+			resolved: []string{mainHead},
+		},
+		{
+			name:     "previous revision",
+			target:   "-1",
+			current:  []string{mainThird},
+			expected: []string{"stamp", mainSecond},
+			resolved: []string{mainSecond},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			executable, output := fakeAlembic(t)
+			p := testProvider(t)
+			p.alembic = []string{executable}
+			p.database_url = testDatabase(t, p.project_root, "app.db", test.current...)
+
+			plan := resourceStampData{
+				Environment: types.Map{ElemType: types.StringType, Null: true},
+				Alembic:     types.List{ElemType: types.StringType, Null: true},
+				ProjectRoot: types.String{Null: true},
+				Config:      types.String{Null: true},
+				Section:     types.String{Null: true},
+				DatabaseURL: types.String{Null: true},
+				Databases:   types.Map{ElemType: types.StringType, Null: true},
+				Target:      test.target,
+				Extra:       types.Map{ElemType: types.StringType, Null: true},
+				Tag:         types.String{Null: true},
+			}
+
+			if diags := (resourceStamp{p: p}).doCreateOrUpgrade(context.Background(), &plan); diags.HasError() {
+				t.Fatal(diags)
+			}
+
+			contents, err := os.ReadFile(output)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			// Every resolved revision is stamped by a single alembic run
+			expected := append([]string{"-c", "alembic.ini", "-n", "alembic"}, test.expected...)
+			if args := strings.Split(strings.TrimSuffix(string(contents), "\n"), "\n"); !reflect.DeepEqual(args, expected) {
+				t.Fatalf("expected %v, got %v", expected, args)
+			}
+
+			if resolved := listStrings(t, plan.TargetRevisions); !reflect.DeepEqual(resolved, test.resolved) {
+				t.Fatalf("expected target revisions %v, got %v", test.resolved, resolved)
+			}
+		})
+	}
+}
@@ -0,0 +1,443 @@
+package alembic
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/hashicorp/terraform-plugin-framework/attr"
+	"github.com/hashicorp/terraform-plugin-framework/resource"
+	"github.com/hashicorp/terraform-plugin-framework/tfsdk"
+	"github.com/hashicorp/terraform-plugin-framework/types"
+	"github.com/hashicorp/terraform-plugin-go/tftypes"
+)
+
+// deleteUpgrade destroys an alembic_upgrade resource with the given state
+func deleteUpgrade(t *testing.T, p alembicProvider, state map[string]tftypes.Value) resource.DeleteResponse {
+	t.Helper()
+	ctx := context.Background()
+
+	schema, diags := resourceUpgradeType{}.GetSchema(ctx)
+	if diags.HasError() {
+		t.Fatal(diags)
+	}
+
+	r, diags := resourceUpgradeType{}.NewResource(ctx, &p)
+	if diags.HasError() {
+		t.Fatal(diags)
+	}
+
+	req := resource.DeleteRequest{State: tfsdk.State{Schema: schema, Raw: testObject(schema, state)}}
+	resp := resource.DeleteResponse{State: tfsdk.State{Schema: schema, Raw: testObject(schema, state)}}
+	r.Delete(ctx, req, &resp)
+
+	return resp
+}
+
+func TestResourceUpgradeDelete(t *testing.T) {
+	tests := []struct {
+		name         string
+		current      []string
+		database_url string
+		state        map[string]tftypes.Value
+		scripts      map[string]string
+		expected     []string
+		warning      string
+		err          string
+	}{
+		{
+			name:    "keep the database by default",
+			current: []string{mainHead},
+			state:   map[string]tftypes.Value{},
+		},
+		{
+			name:    "noop",
+			current: []string{mainHead},
+			state:   map[string]tftypes.Value{"on_destroy": tftypes.NewValue(tftypes.String, onDestroyNoop)},
+		},
+		{
+			name:     "downgrade to base",
+			current:  []string{mainSecond},
+			state:    map[string]tftypes.Value{"on_destroy": tftypes.NewValue(tftypes.String, onDestroyDowngradeToBase)},
+			expected: []string{"-c", "alembic.ini", "-n", "alembic", "downgrade", "base"},
+		},
+		{
+			name:    "downgrade to a revision",
+			current: []string{mainHead},
+			state: map[string]tftypes.Value{
+				"on_destroy":   tftypes.NewValue(tftypes.String, onDestroyDowngradeTo),
+				"downgrade_to": tftypes.NewValue(tftypes.String, "ae10"),
+			},
+			expected: []string{"-c", "alembic.ini", "-n", "alembic", "downgrade", mainSecond},
+		},
+		{
+			name:    "already downgraded",
+			current: []string{mainBase},
+			state: map[string]tftypes.Value{
+				"on_destroy":   tftypes.NewValue(tftypes.String, onDestroyDowngradeTo),
+				"downgrade_to": tftypes.NewValue(tftypes.String, "ae10"),
+			},
+		},
+		{
+			name:         "unreachable database",
+			database_url: "sqlite:///missing/app.db",
+			state:        map[string]tftypes.Value{"on_destroy": tftypes.NewValue(tftypes.String, onDestroyDowngradeToBase)},
+			warning:      "skipping the downgrade on destroy: failed reading the alembic version table",
+		},
+		{
+			name:    "proxy failing to start",
+			current: []string{mainHead},
+			state: map[string]tftypes.Value{
+				"on_destroy":    tftypes.NewValue(tftypes.String, onDestroyDowngradeToBase),
+				"proxy_command": tftypes.NewValue(tftypes.List{ElementType: tftypes.String}, []tftypes.Value{tftypes.NewValue(tftypes.String, "/nonexistent/proxy")}),
+			},
+			warning: "skipping the downgrade on destroy: failed to start sql proxy",
+		},
+		{
+			name:         "missing revision to downgrade to",
+			database_url: "sqlite:///missing/app.db",
+			state: map[string]tftypes.Value{
+				"on_destroy":   tftypes.NewValue(tftypes.String, onDestroyDowngradeTo),
+				"downgrade_to": tftypes.NewValue(tftypes.String, "0000"),
+			},
+			err: "failed resolving target revision '0000'",
+		},
+		{
+			name:    "invalid migration scripts",
+			current: []string{mainHead},
+			scripts: map[string]string{"migrations/versions/broken.py": "revision = 'broken'\ndown_revision = 'missing'\n"},
+			state:   map[string]tftypes.Value{"on_destroy": tftypes.NewValue(tftypes.String, onDestroyDowngradeToBase)},
+			err:     "alembic history failed",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			executable, output := fakeAlembic(t)
+			p := testProvider(t)
+			p.alembic = []string{executable}
+
+			p.database_url = test.database_url
+			if p.database_url == "" {
+				p.database_url = testDatabase(t, p.project_root, "app.db", test.current...)
+			}
+
+			for name, contents := range test.scripts {
+				if err := os.WriteFile(filepath.Join(p.project_root, name), []byte(contents), 0600); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			resp := deleteUpgrade(t, p, test.state)
+
+			// Only failing to reach the database skips the downgrade
+			if test.err != "" {
+				if !resp.Diagnostics.HasError() || !strings.HasPrefix(resp.Diagnostics.Errors()[0].Summary(), test.err) {
+					t.Fatalf("expected an error starting with %q, got %v", test.err, resp.Diagnostics)
+				}
+				return
+			}
+			if resp.Diagnostics.HasError() {
+				t.Fatal(resp.Diagnostics)
+			}
+
+			if test.warning != "" {
+				warnings := resp.Diagnostics.Warnings()
+				if len(warnings) == 0 || !strings.HasPrefix(warnings[0].Summary(), test.warning) {
+					t.Fatalf("expected a warning starting with %q, got %v", test.warning, resp.Diagnostics)
+				}
+			} else if resp.Diagnostics.WarningsCount() > 0 {
+				t.Fatalf("unexpected warnings %v", resp.Diagnostics)
+			}
+
+			var args []string
+			if contents, err := os.ReadFile(output); err == nil {
+				args = strings.Split(strings.TrimSuffix(string(contents), "\n"), "\n")
+			} else if !os.IsNotExist(err) {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(args, test.expected) {
+				t.Fatalf("expected alembic to run with %v, got %v", test.expected, args)
+			}
+		})
+	}
+}
+
+// planUpgradeResource plans the creation of an alembic_upgrade resource with the given
+// configuration, where every computed attribute is unknown
+func planUpgradeResource(t *testing.T, p alembicProvider, config map[string]tftypes.Value) (resourceUpgradeData, resource.ModifyPlanResponse) {
+	t.Helper()
+	ctx := context.Background()
+
+	schema, diags := resourceUpgradeType{}.GetSchema(ctx)
+	if diags.HasError() {
+		t.Fatal(diags)
+	}
+
+	r, diags := resourceUpgradeType{}.NewResource(ctx, &p)
+	if diags.HasError() {
+		t.Fatal(diags)
+	}
+
+	values := map[string]tftypes.Value{}
+	for name, attribute := range schema.Attributes {
+		if attribute.Computed && !attribute.Optional {
+			values[name] = tftypes.NewValue(attribute.FrameworkType().TerraformType(ctx), tftypes.UnknownValue)
+		}
+	}
+	for name, value := range config {
+		values[name] = value
+	}
+
+	plan := testObject(schema, values)
+	req := resource.ModifyPlanRequest{
+		Config: tfsdk.Config{Schema: schema, Raw: testObject(schema, config)},
+		Plan:   tfsdk.Plan{Schema: schema, Raw: plan},
+		State:  tfsdk.State{Schema: schema, Raw: tftypes.NewValue(schema.TerraformType(ctx), nil)},
+	}
+	resp := resource.ModifyPlanResponse{Plan: tfsdk.Plan{Schema: schema, Raw: plan}}
+	r.(resource.ResourceWithModifyPlan).ModifyPlan(ctx, req, &resp)
+
+	var data resourceUpgradeData
+	if !resp.Diagnostics.HasError() {
+		if diags := resp.Plan.Get(ctx, &data); diags.HasError() {
+			t.Fatal(diags)
+		}
+	}
+
+	return data, resp
+}
+
+// pendingRevisionIDs returns the revision IDs of a 'pending_revisions' or
+// 'reverted_revisions' value
+func pendingRevisionIDs(t *testing.T, list types.List) []string {
+	t.Helper()
+
+	var revisions []struct {
+		Revision types.String `tfsdk:"revision"`
+		Message  types.String `tfsdk:"message"`
+	}
+	if diags := list.ElementsAs(context.Background(), &revisions, false); diags.HasError() {
+		t.Fatal(diags)
+	}
+
+	ids := []string{}
+	for _, revision := range revisions {
+		ids = append(ids, revision.Revision.Value)
+	}
+	return ids
+}
+
+func TestResourceUpgradeModifyPlan(t *testing.T) {
+	tests := []struct {
+		name         string
+		current      []string
+		database_url string
+		target       string
+		pending      []string
+		sql          types.String
+		args         []string
+		warning      string
+	}{
+		{
+			name:    "pending revisions",
+			current: []string{mainSecond},
+			target:  "main@head",
+			pending: []string{mainThird, mainHead},
+			sql:     types.String{Value: "ALTER TABLE orders;\n"},
+			args:    []string{"-c", "alembic.ini", "-n", "alembic", "upgrade", "--sql", mainSecond + ":" + mainHead},
+		},
+		{
+			name:    "from base",
+			target:  "feature@head",
+			pending: []string{featureBase, featureHead},
+			sql:     types.String{Value: "ALTER TABLE orders;\n"},
+			args:    []string{"-c", "alembic.ini", "-n", "alembic", "upgrade", "--sql", featureHead},
+		},
+		{
+			name:    "up to date",
+			current: []string{mainHead},
+			target:  "main@head",
+			pending: []string{},
+			sql:     types.String{Value: ""},
+		},
+		{
+			name:    "several current revisions",
+			current: []string{mainThird, featureHead},
+			target:  "main@head",
+			pending: []string{mainHead},
+			sql:     types.String{Null: true},
+			warning: "unable to generate the planned SQL",
+		},
+		{
+			name:         "unreachable database",
+			database_url: "sqlite:///missing/app.db",
+			target:       "main@head",
+			warning:      "unable to determine the pending revisions: failed reading the alembic version table",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			executable, output := fakeAlembicOutput(t, "ALTER TABLE orders;\n")
+			p := testProvider(t)
+			p.alembic = []string{executable}
+
+			p.database_url = test.database_url
+			if p.database_url == "" {
+				p.database_url = testDatabase(t, p.project_root, "app.db", test.current...)
+			}
+
+			plan, resp := planUpgradeResource(t, p, map[string]tftypes.Value{
+				"target": tftypes.NewValue(tftypes.String, test.target),
+			})
+			if resp.Diagnostics.HasError() {
+				t.Fatal(resp.Diagnostics)
+			}
+
+			if test.warning != "" {
+				warnings := resp.Diagnostics.Warnings()
+				if len(warnings) == 0 || !strings.HasPrefix(warnings[0].Summary(), test.warning) {
+					t.Fatalf("expected a warning starting with %q, got %v", test.warning, resp.Diagnostics)
+				}
+			} else if resp.Diagnostics.WarningsCount() > 0 {
+				t.Fatalf("unexpected warnings %v", resp.Diagnostics)
+			}
+
+			// Without the database, nothing is known about the pending revisions
+			if test.pending == nil {
+				if !plan.PendingRevisions.Unknown || !plan.RevertedRevisions.Unknown || !plan.PlannedSQL.Unknown {
+					t.Fatalf("expected unknown values, got %v, %v and %v", plan.PendingRevisions, plan.RevertedRevisions, plan.PlannedSQL)
+				}
+			} else {
+				if pending := pendingRevisionIDs(t, plan.PendingRevisions); !reflect.DeepEqual(pending, test.pending) {
+					t.Fatalf("expected pending revisions %v, got %v", test.pending, pending)
+				}
+				if reverted := pendingRevisionIDs(t, plan.RevertedRevisions); len(reverted) != 0 {
+					t.Fatalf("expected no reverted revisions, got %v", reverted)
+				}
+				if !plan.PlannedSQL.Equal(test.sql) {
+					t.Fatalf("expected planned SQL %v, got %v", test.sql, plan.PlannedSQL)
+				}
+			}
+
+			// Only generating the SQL runs alembic, in offline mode
+			var args []string
+			if contents, err := os.ReadFile(output); err == nil {
+				args = strings.Split(strings.TrimSuffix(string(contents), "\n"), "\n")
+			} else if !os.IsNotExist(err) {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(args, test.args) {
+				t.Fatalf("expected alembic to run with %v, got %v", test.args, args)
+			}
+		})
+	}
+}
+
+func TestResourceUpgradeTargetSpec(t *testing.T) {
+	tests := []struct {
+		name     string
+		data     resourceUpgradeData
+		expected string
+	}{
+		{
+			name:     "target",
+			data:     resourceUpgradeData{Target: types.String{Value: "main@head"}, Targets: types.List{ElemType: types.StringType, Null: true}},
+			expected: "main@head",
+		},
+		{
+			name: "targets",
+			data: resourceUpgradeData{Target: types.String{Null: true}, Targets: types.List{ElemType: types.StringType, Elems: []attr.Value{
+				types.String{Value: "main@head"},
+				types.String{Value: "feature@head"},
+			}}},
+			expected: "main@head,feature@head",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			target, diags := test.data.targetSpec(context.Background())
+			if diags.HasError() {
+				t.Fatal(diags)
+			}
+			if target != test.expected {
+				t.Fatalf("expected %q, got %q", test.expected, target)
+			}
+		})
+	}
+}
+
+func TestResourceUpgradeTargets(t *testing.T) {
+	tests := []struct {
+		name     string
+		current  []string
+		targets  []string
+		expected [][]string
+	}{
+		{
+			name:     "one run per target revision",
+			targets:  []string{"main@head", "feature@head"},
+			expected: [][]string{{"upgrade", mainHead}, {"upgrade", featureHead}},
+		},
+		{
+			name:     "only the downgraded targets",
+			current:  []string{mainHead, featureHead},
+			targets:  []string{"ae10", "feature@head"},
+			expected: [][]string{{"downgrade", mainSecond}},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			executable, output := fakeAlembic(t)
+			p := testProvider(t)
+			p.alembic = []string{executable}
+			p.database_url = testDatabase(t, p.project_root, "app.db", test.current...)
+
+			plan := resourceUpgradeData{
+				Environment:       types.Map{ElemType: types.StringType, Null: true},
+				Alembic:           types.List{ElemType: types.StringType, Null: true},
+				ProjectRoot:       types.String{Null: true},
+				Config:            types.String{Null: true},
+				Section:           types.String{Null: true},
+				DatabaseURL:       types.String{Null: true},
+				Databases:         types.Map{ElemType: types.StringType, Null: true},
+				AllowDowngrade:    types.Bool{Value: true},
+				PendingRevisions:  types.List{ElemType: pendingRevisionType, Unknown: true},
+				RevertedRevisions: types.List{ElemType: pendingRevisionType, Unknown: true},
+				PlannedSQL:        types.String{Unknown: true},
+				Target:            types.String{Null: true},
+				Targets:           stringList(test.targets),
+				Extra:             types.Map{ElemType: types.StringType, Null: true},
+				Tag:               types.String{Null: true},
+			}
+
+			if diags := (resourceUpgrade{p: p}).doCreateOrUpgrade(context.Background(), &plan); diags.HasError() {
+				t.Fatal(diags)
+			}
+
+			contents, err := os.ReadFile(output)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var expected []string
+			for _, run := range test.expected {
+				expected = append(expected, "-c", "alembic.ini", "-n", "alembic")
+				expected = append(expected, run...)
+			}
+			if args := strings.Split(strings.TrimSuffix(string(contents), "\n"), "\n"); !reflect.DeepEqual(args, expected) {
+				t.Fatalf("expected %v, got %v", expected, args)
+			}
+
+			if plan.PendingRevisions.Unknown || plan.RevertedRevisions.Unknown || plan.PlannedSQL.Unknown {
+				t.Fatal("expected no unknown values to remain")
+			}
+		})
+	}
+}
@@ -0,0 +1,15 @@
+package alembic
+
+// alembicRevision holds the metadata for a single migration script
+type alembicRevision struct {
+	Revision      string   `json:"revision"`
+	DownRevisions []string `json:"down_revisions"`
+	BranchLabels  []string `json:"branch_labels"`
+	DependsOn     []string `json:"depends_on"`
+	Message       string   `json:"message"`
+	CreateDate    string   `json:"create_date"`
+	Path          string   `json:"path"`
+	IsHead        bool     `json:"is_head"`
+	IsBranchPoint bool     `json:"is_branch_point"`
+	IsMergePoint  bool     `json:"is_merge_point"`
+}
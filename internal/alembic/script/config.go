@@ -0,0 +1,253 @@
+package script
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// Name of the implicit section whose options are visible from every other section
+const defaultSection = "DEFAULT"
+
+// Matches a "%(name)s" interpolation within a configuration value
+var interpolationRegex = regexp.MustCompile(`%\(([^)]+)\)s`)
+
+// alembic's legacy version_locations separator, used when version_path_separator is unset
+var spaceCommaRegex = regexp.MustCompile(`, *| +`)
+
+// Config holds the settings from an alembic configuration file which are needed to locate
+// the migration scripts. All paths are absolute.
+type Config struct {
+	// ScriptLocation is the directory holding env.py and the script templates
+	ScriptLocation string
+
+	// VersionLocations are the directories holding the migration scripts
+	VersionLocations []string
+
+	// RecursiveVersionLocations is set when version locations are searched recursively
+	RecursiveVersionLocations bool
+}
+
+// ReadConfig reads the given section of an alembic configuration file. Relative paths in
+// the configuration are resolved against root, which is the directory alembic runs from.
+// The filename itself is also relative to root unless it is absolute.
+func ReadConfig(root string, filename string, section string) (*Config, error) {
+	root, err := filepath.Abs(root)
+	if err != nil {
+		return nil, err
+	}
+
+	if !filepath.IsAbs(filename) {
+		filename = filepath.Join(root, filename)
+	}
+
+	sections, err := parseIni(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, ok := sections[section]; !ok {
+		return nil, fmt.Errorf("%v: no section named '%v'", filename, section)
+	}
+
+	// alembic provides the directory containing the configuration file as 'here'
+	if sections[defaultSection] == nil {
+		sections[defaultSection] = make(map[string]string)
+	}
+	if _, ok := sections[defaultSection]["here"]; !ok {
+		sections[defaultSection]["here"] = filepath.Dir(filename)
+	}
+
+	get := func(option string) (string, bool, error) {
+		return lookupOption(sections, section, option, 0)
+	}
+
+	location, ok, err := get("script_location")
+	if err != nil {
+		return nil, err
+	} else if !ok || location == "" {
+		return nil, fmt.Errorf("%v: no 'script_location' key found in section '%v'", filename, section)
+	} else if strings.Contains(location, ":") && !filepath.IsAbs(location) && filepath.VolumeName(location) == "" {
+		return nil, fmt.Errorf("%v: package resource script locations ('%v') are not supported", filename, location)
+	}
+
+	config := &Config{
+		ScriptLocation: absolutePath(root, location),
+	}
+
+	locations, ok, err := get("version_locations")
+	if err != nil {
+		return nil, err
+	}
+
+	if ok && locations != "" {
+		separator, _, err := get("version_path_separator")
+		if err != nil {
+			return nil, err
+		}
+
+		var parts []string
+		switch separator {
+		case "":
+			parts = spaceCommaRegex.Split(locations, -1)
+		case "space":
+			parts = strings.Split(locations, " ")
+		case "newline":
+			parts = strings.Split(locations, "\n")
+		case "os":
+			parts = strings.Split(locations, string(os.PathListSeparator))
+		case ":", ";":
+			parts = strings.Split(locations, separator)
+		default:
+			return nil, fmt.Errorf("%v: '%v' is not a valid value for version_path_separator", filename, separator)
+		}
+
+		for _, part := range parts {
+			if part = strings.TrimSpace(part); part != "" {
+				config.VersionLocations = append(config.VersionLocations, absolutePath(root, part))
+			}
+		}
+	} else {
+		config.VersionLocations = []string{filepath.Join(config.ScriptLocation, "versions")}
+	}
+
+	recursive, _, err := get("recursive_version_locations")
+	if err != nil {
+		return nil, err
+	}
+
+	switch strings.ToLower(strings.TrimSpace(recursive)) {
+	case "true", "yes", "on", "y", "t", "1":
+		config.RecursiveVersionLocations = true
+	}
+
+	return config, nil
+}
+
+func absolutePath(root string, path string) string {
+	if filepath.IsAbs(path) {
+		return filepath.Clean(path)
+	}
+	return filepath.Join(root, path)
+}
+
+// lookupOption retrieves an option from a section (falling back to the DEFAULT section)
+// and expands any "%(name)s" interpolations the same way Python's ConfigParser does.
+func lookupOption(sections map[string]map[string]string, section string, option string, depth int) (string, bool, error) {
+	if depth > 10 {
+		return "", false, fmt.Errorf("interpolation depth exceeded while expanding option '%v'", option)
+	}
+
+	value, ok := sections[section][option]
+	if !ok {
+		value, ok = sections[defaultSection][option]
+	}
+	if !ok {
+		return "", false, nil
+	}
+
+	var result strings.Builder
+
+	for len(value) > 0 {
+		index := strings.IndexByte(value, '%')
+		if index < 0 {
+			result.WriteString(value)
+			break
+		}
+
+		result.WriteString(value[:index])
+		value = value[index:]
+
+		if strings.HasPrefix(value, "%%") {
+			result.WriteByte('%')
+			value = value[2:]
+			continue
+		}
+
+		match := interpolationRegex.FindStringSubmatchIndex(value)
+		if match == nil || match[0] != 0 {
+			return "", false, fmt.Errorf("invalid interpolation syntax in option '%v': '%v'", option, value)
+		}
+
+		name := strings.ToLower(value[match[2]:match[3]])
+		expanded, found, lookup_err := lookupOption(sections, section, name, depth+1)
+		if lookup_err != nil {
+			return "", false, lookup_err
+		} else if !found {
+			return "", false, fmt.Errorf("option '%v' references unknown option '%v'", option, name)
+		}
+
+		result.WriteString(expanded)
+		value = value[match[1]:]
+	}
+
+	return result.String(), true, nil
+}
+
+// parseIni parses an INI file using the same rules as Python's ConfigParser defaults:
+// option names are case-insensitive, either '=' or ':' separates names from values, whole
+// line comments start with '#' or ';' and indented lines continue the previous value.
+func parseIni(filename string) (map[string]map[string]string, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	sections := make(map[string]map[string]string)
+
+	var current map[string]string
+	var option string
+	var lineno int
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimRight(scanner.Text(), " \t\r")
+		lineno += 1
+
+		trimmed := strings.TrimSpace(line)
+		if trimmed == "" {
+			option = ""
+			continue
+		} else if strings.HasPrefix(trimmed, "#") || strings.HasPrefix(trimmed, ";") {
+			continue
+		}
+
+		// Indented lines are continuations of the previous value
+		if option != "" && (line[0] == ' ' || line[0] == '\t') {
+			current[option] += "\n" + trimmed
+			continue
+		}
+
+		if strings.HasPrefix(trimmed, "[") && strings.HasSuffix(trimmed, "]") {
+			name := trimmed[1 : len(trimmed)-1]
+			if sections[name] == nil {
+				sections[name] = make(map[string]string)
+			}
+			current = sections[name]
+			option = ""
+			continue
+		}
+
+		if current == nil {
+			return nil, fmt.Errorf("%v:%v: option found before any section header", filename, lineno)
+		}
+
+		index := strings.IndexAny(trimmed, "=:")
+		if index <= 0 {
+			return nil, fmt.Errorf("%v:%v: unable to parse line '%v'", filename, lineno, trimmed)
+		}
+
+		option = strings.ToLower(strings.TrimSpace(trimmed[:index]))
+		current[option] = strings.TrimSpace(trimmed[index+1:])
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return sections, nil
+}
@@ -0,0 +1,226 @@
+package script
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestReadConfig(t *testing.T) {
+	root := t.TempDir()
+
+	tests := []struct {
+		name     string
+		config   string
+		section  string
+		expected *Config
+		err      string
+	}{
+		{
+			name:    "default version location",
+			config:  "[alembic]\nscript_location = migrations\n",
+			section: "alembic",
+			expected: &Config{
+				ScriptLocation:   filepath.Join(root, "migrations"),
+				VersionLocations: []string{filepath.Join(root, "migrations", "versions")},
+			},
+		},
+		{
+			name: "here interpolation",
+			config: `[alembic]
+script_location = %(here)s/migrations
+version_locations = %(here)s/a %(here)s/b
+`,
+			section: "alembic",
+			expected: &Config{
+				ScriptLocation:   filepath.Join(root, "conf", "migrations"),
+				VersionLocations: []string{filepath.Join(root, "conf", "a"), filepath.Join(root, "conf", "b")},
+			},
+		},
+		{
+			name: "defaults and other sections",
+			config: `[DEFAULT]
+base = db
+recursive_version_locations = true
+
+[alembic]
+script_location = x
+
+[tenant]
+Script_Location: %(base)s/migrations
+`,
+			section: "tenant",
+			expected: &Config{
+				ScriptLocation:            filepath.Join(root, "db", "migrations"),
+				VersionLocations:          []string{filepath.Join(root, "db", "migrations", "versions")},
+				RecursiveVersionLocations: true,
+			},
+		},
+		{
+			name:    "legacy separator",
+			config:  "[alembic]\nscript_location = m\nversion_locations = a, b c,d\n",
+			section: "alembic",
+			expected: &Config{
+				ScriptLocation:   filepath.Join(root, "m"),
+				VersionLocations: []string{filepath.Join(root, "a"), filepath.Join(root, "b"), filepath.Join(root, "c"), filepath.Join(root, "d")},
+			},
+		},
+		{
+			name:    "space separator",
+			config:  "[alembic]\nscript_location = m\nversion_locations = a,b c\nversion_path_separator = space\n",
+			section: "alembic",
+			expected: &Config{
+				ScriptLocation:   filepath.Join(root, "m"),
+				VersionLocations: []string{filepath.Join(root, "a,b"), filepath.Join(root, "c")},
+			},
+		},
+		{
+			name:    "os separator",
+			config:  "[alembic]\nscript_location = m\nversion_locations = a b" + string(os.PathListSeparator) + "c\nversion_path_separator = os\n",
+			section: "alembic",
+			expected: &Config{
+				ScriptLocation:   filepath.Join(root, "m"),
+				VersionLocations: []string{filepath.Join(root, "a b"), filepath.Join(root, "c")},
+			},
+		},
+		{
+			name:    "semicolon separator",
+			config:  "[alembic]\nscript_location = m\nversion_locations = a b;/abs/c\nversion_path_separator = ;\n",
+			section: "alembic",
+			expected: &Config{
+				ScriptLocation:   filepath.Join(root, "m"),
+				VersionLocations: []string{filepath.Join(root, "a b"), "/abs/c"},
+			},
+		},
+		{
+			name:    "newline separator",
+			config:  "[alembic]\nscript_location = m\nversion_locations =\n    a b\n    c\nversion_path_separator = newline\n",
+			section: "alembic",
+			expected: &Config{
+				ScriptLocation:   filepath.Join(root, "m"),
+				VersionLocations: []string{filepath.Join(root, "a b"), filepath.Join(root, "c")},
+			},
+		},
+		{
+			name:    "escaped percent",
+			config:  "[alembic]\nscript_location = 100%%\n",
+			section: "alembic",
+			expected: &Config{
+				ScriptLocation:   filepath.Join(root, "100%"),
+				VersionLocations: []string{filepath.Join(root, "100%", "versions")},
+			},
+		},
+		{
+			name:    "invalid separator",
+			config:  "[alembic]\nscript_location = m\nversion_locations = a\nversion_path_separator = comma\n",
+			section: "alembic",
+			err:     "'comma' is not a valid value for version_path_separator",
+		},
+		{
+			name:    "missing section",
+			config:  "[alembic]\nscript_location = m\n",
+			section: "tenant",
+			err:     "no section named 'tenant'",
+		},
+		{
+			name:    "missing script location",
+			config:  "[alembic]\nsqlalchemy.url = sqlite://\n",
+			section: "alembic",
+			err:     "no 'script_location' key found in section 'alembic'",
+		},
+		{
+			name:    "package resource",
+			config:  "[alembic]\nscript_location = myapp:migrations\n",
+			section: "alembic",
+			err:     "package resource script locations",
+		},
+		{
+			name:    "unknown interpolation",
+			config:  "[alembic]\nscript_location = %(missing)s\n",
+			section: "alembic",
+			err:     "references unknown option 'missing'",
+		},
+		{
+			name:    "recursive interpolation",
+			config:  "[alembic]\nscript_location = %(script_location)s\n",
+			section: "alembic",
+			err:     "interpolation depth exceeded",
+		},
+		{
+			name:    "option before section",
+			config:  "script_location = m\n",
+			section: "alembic",
+			err:     "option found before any section header",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			filename := filepath.Join("conf", strings.ReplaceAll(test.name, " ", "_")+".ini")
+			if err := os.MkdirAll(filepath.Join(root, "conf"), 0700); err != nil {
+				t.Fatal(err)
+			}
+			if err := os.WriteFile(filepath.Join(root, filename), []byte(test.config), 0600); err != nil {
+				t.Fatal(err)
+			}
+
+			config, err := ReadConfig(root, filename, test.section)
+			if test.err != "" {
+				if err == nil || !strings.Contains(err.Error(), test.err) {
+					t.Fatalf("expected error containing %q, got %v", test.err, err)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !reflect.DeepEqual(config, test.expected) {
+				t.Fatalf("expected %+v, got %+v", test.expected, config)
+			}
+		})
+	}
+}
+
+func TestRevisions(t *testing.T) {
+	root := t.TempDir()
+
+	for name, source := range map[string]string{
+		"a/1975ea83b712_create.py":       "revision = '1975ea83b712'\n",
+		"a/nested/ae1027a6acf0_email.py": "revision = 'ae1027a6acf0'\ndown_revision = '1975ea83b712'\n",
+		"a/__init__.py":                  "",
+		"a/.#27c6a30d7c24_lock.py":       "",
+		"a/README":                       "",
+		"a/__pycache__/x.py":             "revision = 'cached'\n",
+	} {
+		path := filepath.Join(root, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(source), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for recursive, expected := range map[bool][]string{
+		false: {"1975ea83b712"},
+		true:  {"1975ea83b712", "ae1027a6acf0"},
+	} {
+		config := &Config{VersionLocations: []string{filepath.Join(root, "a")}, RecursiveVersionLocations: recursive}
+
+		revisions, err := config.Revisions()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var ids []string
+		for _, revision := range revisions {
+			ids = append(ids, revision.Revision)
+		}
+		if !reflect.DeepEqual(ids, expected) {
+			t.Errorf("recursive=%v: expected %v, got %v", recursive, expected, ids)
+		}
+	}
+}
@@ -0,0 +1,341 @@
+package script
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// The module level variables alembic reads from each migration script
+var assignmentRegex = regexp.MustCompile(`^(revision|down_revision|branch_labels|depends_on)\s*(?::[^=\n]*)?=[^=]`)
+
+type literalKind int
+
+const (
+	literalNone literalKind = iota
+	literalString
+	literalSequence
+)
+
+// literal is a statically evaluated python value. Only the values which are valid for
+// the alembic revision variables are supported: None, strings and tuples/lists of strings.
+type literal struct {
+	kind  literalKind
+	text  string
+	items []string
+}
+
+// module holds the parts of a python module which are relevant for a migration script
+type module struct {
+	docstring   string
+	assignments map[string]literal
+}
+
+// parser walks python source code one top-level statement at a time
+type parser struct {
+	source string
+	pos    int
+}
+
+// parseModule extracts the docstring and revision variable assignments from python source
+func parseModule(source string) (*module, error) {
+	p := &parser{source: strings.ReplaceAll(source, "\r\n", "\n")}
+	m := &module{assignments: make(map[string]literal)}
+	first := true
+
+	for {
+		p.skipBlankLines()
+		if p.pos >= len(p.source) {
+			break
+		}
+
+		start := p.pos
+		rest := p.source[p.pos:]
+
+		// The module docstring is a string literal as the very first statement
+		if first && (p.peek() == '"' || p.peek() == '\'' || isStringPrefix(rest)) {
+			if value, err := p.parseString(false); err == nil && p.atStatementEnd() {
+				m.docstring = value
+				p.skipStatement()
+				first = false
+				continue
+			}
+			p.pos = start
+		}
+		first = false
+
+		// Only unindented statements are module level
+		if p.peek() != ' ' && p.peek() != '\t' {
+			if match := assignmentRegex.FindStringSubmatchIndex(rest); match != nil {
+				name := rest[match[2]:match[3]]
+				p.pos += match[1] - 1
+
+				value, err := p.parseLiteral()
+				if err != nil {
+					return nil, fmt.Errorf("line %v: unable to statically evaluate '%v': %v", p.lineOf(start), name, err)
+				}
+
+				if !p.atStatementEnd() {
+					return nil, fmt.Errorf("line %v: unable to statically evaluate '%v': only literal values are supported", p.lineOf(start), name)
+				}
+
+				m.assignments[name] = value
+			}
+		}
+
+		p.skipStatement()
+	}
+
+	return m, nil
+}
+
+func (p *parser) peek() byte {
+	if p.pos >= len(p.source) {
+		return 0
+	}
+	return p.source[p.pos]
+}
+
+func (p *parser) lineOf(pos int) int {
+	return strings.Count(p.source[:pos], "\n") + 1
+}
+
+// skipBlankLines moves past empty lines and comment lines
+func (p *parser) skipBlankLines() {
+	for p.pos < len(p.source) {
+		end := strings.IndexByte(p.source[p.pos:], '\n')
+		if end < 0 {
+			end = len(p.source) - p.pos
+		}
+
+		line := strings.TrimSpace(p.source[p.pos : p.pos+end])
+		if line != "" && !strings.HasPrefix(line, "#") {
+			return
+		}
+
+		p.pos += end + 1
+	}
+}
+
+// skipWhitespace moves past spaces, comments and (when nested inside brackets) newlines
+func (p *parser) skipWhitespace(newlines bool) {
+	for p.pos < len(p.source) {
+		switch c := p.source[p.pos]; {
+		case c == ' ' || c == '\t' || c == '\f':
+			p.pos += 1
+		case c == '\\' && strings.HasPrefix(p.source[p.pos:], "\\\n"):
+			p.pos += 2
+		case c == '\n' && newlines:
+			p.pos += 1
+		case c == '#':
+			for p.pos < len(p.source) && p.source[p.pos] != '\n' {
+				p.pos += 1
+			}
+		default:
+			return
+		}
+	}
+}
+
+// atStatementEnd reports whether only whitespace or a comment remains on the current line
+func (p *parser) atStatementEnd() bool {
+	p.skipWhitespace(false)
+	return p.pos >= len(p.source) || p.source[p.pos] == '\n' || p.source[p.pos] == ';'
+}
+
+// skipStatement moves to the start of the next logical line, stepping over any strings
+// and bracketed expressions which span multiple physical lines.
+func (p *parser) skipStatement() {
+	depth := 0
+
+	for p.pos < len(p.source) {
+		c := p.source[p.pos]
+		switch {
+		case c == '#':
+			p.skipWhitespace(false)
+		case c == '"' || c == '\'' || isStringPrefix(p.source[p.pos:]):
+			if _, err := p.parseString(depth > 0); err != nil {
+				p.pos += 1
+			}
+		case c == '\\' && strings.HasPrefix(p.source[p.pos:], "\\\n"):
+			p.pos += 2
+		case c == '(' || c == '[' || c == '{':
+			depth += 1
+			p.pos += 1
+		case c == ')' || c == ']' || c == '}':
+			if depth > 0 {
+				depth -= 1
+			}
+			p.pos += 1
+		case c == '\n' && depth == 0:
+			p.pos += 1
+			return
+		default:
+			p.pos += 1
+		}
+	}
+}
+
+// parseLiteral evaluates None, a string or a tuple/list/set of strings
+func (p *parser) parseLiteral() (literal, error) {
+	p.skipWhitespace(false)
+
+	rest := p.source[p.pos:]
+	switch {
+	case strings.HasPrefix(rest, "None") && !isIdentifierByte(rest, 4):
+		p.pos += 4
+		return literal{kind: literalNone}, nil
+	case p.peek() == '"' || p.peek() == '\'' || isStringPrefix(rest):
+		value, err := p.parseString(false)
+		if err != nil {
+			return literal{}, err
+		}
+		return literal{kind: literalString, text: value}, nil
+	case p.peek() == '(' || p.peek() == '[' || p.peek() == '{':
+		return p.parseSequence()
+	}
+
+	return literal{}, fmt.Errorf("only None, strings and sequences of strings are supported")
+}
+
+func (p *parser) parseSequence() (literal, error) {
+	closing := map[byte]byte{'(': ')', '[': ']', '{': '}'}[p.peek()]
+	parenthesized := p.peek() == '('
+	trailing_comma := false
+	items := []string{}
+
+	p.pos += 1
+
+	for {
+		p.skipWhitespace(true)
+
+		if p.peek() == closing {
+			p.pos += 1
+			break
+		}
+
+		value, err := p.parseString(true)
+		if err != nil {
+			return literal{}, err
+		}
+		items = append(items, value)
+
+		p.skipWhitespace(true)
+
+		trailing_comma = false
+		switch p.peek() {
+		case ',':
+			trailing_comma = true
+			p.pos += 1
+		case closing:
+		default:
+			return literal{}, fmt.Errorf("expected ',' or '%c' in sequence", closing)
+		}
+	}
+
+	// A parenthesized string without a trailing comma is just a string
+	if parenthesized && len(items) == 1 && !trailing_comma {
+		return literal{kind: literalString, text: items[0]}, nil
+	}
+
+	return literal{kind: literalSequence, items: items}, nil
+}
+
+func isIdentifierByte(s string, index int) bool {
+	if index >= len(s) {
+		return false
+	}
+	c := s[index]
+	return c == '_' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (c >= '0' && c <= '9')
+}
+
+// isStringPrefix reports whether s starts with a string prefix (e.g. the r in r"...") followed
+// by the opening quote.
+func isStringPrefix(s string) bool {
+	for i := 0; i < len(s) && i < 3; i++ {
+		switch s[i] {
+		case 'r', 'R', 'u', 'U', 'b', 'B', 'f', 'F':
+			continue
+		case '"', '\'':
+			return i > 0
+		}
+		return false
+	}
+	return false
+}
+
+// parseString parses a (possibly prefixed, triple quoted or implicitly concatenated)
+// python string literal and returns its value. Within brackets, the concatenated strings
+// may be on separate lines.
+func (p *parser) parseString(nested bool) (string, error) {
+	var result strings.Builder
+
+	for {
+		raw := false
+		for p.pos < len(p.source) && strings.IndexByte("rRuUbBfF", p.source[p.pos]) >= 0 {
+			if p.source[p.pos] == 'r' || p.source[p.pos] == 'R' {
+				raw = true
+			}
+			p.pos += 1
+		}
+
+		if p.peek() != '"' && p.peek() != '\'' {
+			return "", fmt.Errorf("expected a string")
+		}
+
+		quote := p.source[p.pos : p.pos+1]
+		if strings.HasPrefix(p.source[p.pos:], strings.Repeat(quote, 3)) {
+			quote = strings.Repeat(quote, 3)
+		}
+		p.pos += len(quote)
+
+		for {
+			if p.pos >= len(p.source) {
+				return "", fmt.Errorf("unterminated string")
+			}
+
+			rest := p.source[p.pos:]
+			if strings.HasPrefix(rest, quote) {
+				p.pos += len(quote)
+				break
+			}
+
+			c := rest[0]
+			if c == '\n' && len(quote) == 1 {
+				return "", fmt.Errorf("unterminated string")
+			}
+
+			if c == '\\' && len(rest) > 1 {
+				p.pos += 2
+				if raw {
+					result.WriteString(rest[:2])
+					continue
+				}
+
+				switch rest[1] {
+				case 'n':
+					result.WriteByte('\n')
+				case 't':
+					result.WriteByte('\t')
+				case '\n':
+				case '\\', '\'', '"':
+					result.WriteByte(rest[1])
+				default:
+					result.WriteString(rest[:2])
+				}
+				continue
+			}
+
+			result.WriteByte(c)
+			p.pos += 1
+		}
+
+		// Adjacent string literals are concatenated
+		start := p.pos
+		p.skipWhitespace(nested)
+		if p.peek() != '"' && p.peek() != '\'' && !isStringPrefix(p.source[p.pos:]) {
+			p.pos = start
+			return result.String(), nil
+		}
+	}
+}
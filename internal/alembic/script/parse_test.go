@@ -0,0 +1,211 @@
+package script
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseModule(t *testing.T) {
+	tests := []struct {
+		name        string
+		source      string
+		docstring   string
+		assignments map[string]literal
+		err         string
+	}{
+		{
+			name: "generated script",
+			source: `"""add orders
+
+Revision ID: 27c6a30d7c24
+Revises: ae1027a6acf0
+Create Date: 2022-09-01 12:00:00.000000
+
+"""
+from alembic import op
+import sqlalchemy as sa
+
+# revision identifiers, used by Alembic.
+revision = '27c6a30d7c24'
+down_revision = 'ae1027a6acf0'
+branch_labels = None
+depends_on = None
+
+
+def upgrade():
+    revision = 'ignored'
+`,
+			docstring: "add orders\n\nRevision ID: 27c6a30d7c24\nRevises: ae1027a6acf0\nCreate Date: 2022-09-01 12:00:00.000000\n\n",
+			assignments: map[string]literal{
+				"revision":      {kind: literalString, text: "27c6a30d7c24"},
+				"down_revision": {kind: literalString, text: "ae1027a6acf0"},
+				"branch_labels": {kind: literalNone},
+				"depends_on":    {kind: literalNone},
+			},
+		},
+		{
+			name: "annotated assignments",
+			source: `revision: str = "27c6a30d7c24"
+down_revision: Union[str, None] = "ae1027a6acf0"
+branch_labels: Union[str, Sequence[str], None] = None
+`,
+			assignments: map[string]literal{
+				"revision":      {kind: literalString, text: "27c6a30d7c24"},
+				"down_revision": {kind: literalString, text: "ae1027a6acf0"},
+				"branch_labels": {kind: literalNone},
+			},
+		},
+		{
+			name: "tuple down revisions",
+			source: `revision = "3cd5b1a7fe01"
+down_revision = (
+    "27c6a30d7c24",  # main
+    "f5a0b9e8d7c6",  # feature
+)
+branch_labels = ("main",)
+depends_on = ["ae1027a6acf0"]
+`,
+			assignments: map[string]literal{
+				"revision":      {kind: literalString, text: "3cd5b1a7fe01"},
+				"down_revision": {kind: literalSequence, items: []string{"27c6a30d7c24", "f5a0b9e8d7c6"}},
+				"branch_labels": {kind: literalSequence, items: []string{"main"}},
+				"depends_on":    {kind: literalSequence, items: []string{"ae1027a6acf0"}},
+			},
+		},
+		{
+			name:   "parenthesized string",
+			source: "revision = (\"3cd5b1a7fe01\")\n",
+			assignments: map[string]literal{
+				"revision": {kind: literalString, text: "3cd5b1a7fe01"},
+			},
+		},
+		{
+			name: "implicitly concatenated strings",
+			source: `r'''first ''' "line"
+revision = "3cd5" 'b1a7' \
+    "fe01"
+down_revision = ("27c6"
+                 "a30d7c24")
+`,
+			docstring: "first line",
+			assignments: map[string]literal{
+				"revision":      {kind: literalString, text: "3cd5b1a7fe01"},
+				"down_revision": {kind: literalString, text: "27c6a30d7c24"},
+			},
+		},
+		{
+			name:   "escapes",
+			source: "revision = 'a\\'b'\ndown_revision = r'a\\'b'\n",
+			assignments: map[string]literal{
+				"revision":      {kind: literalString, text: "a'b"},
+				"down_revision": {kind: literalString, text: "a\\'b"},
+			},
+		},
+		{
+			name: "strings hiding assignments",
+			source: `"""docstring"""
+message = """
+revision = 'not a revision'
+"""
+revision = "3cd5b1a7fe01"
+`,
+			docstring: "docstring",
+			assignments: map[string]literal{
+				"revision": {kind: literalString, text: "3cd5b1a7fe01"},
+			},
+		},
+		{
+			name:        "comparisons are not assignments",
+			source:      "if revision == 'x':\n    pass\n",
+			assignments: map[string]literal{},
+		},
+		{
+			name:   "computed value",
+			source: "revision = make_revision()\n",
+			err:    "line 1: unable to statically evaluate 'revision'",
+		},
+		{
+			name:   "expression",
+			source: "\n\nrevision = 'a' + 'b'\n",
+			err:    "line 3: unable to statically evaluate 'revision': only literal values are supported",
+		},
+		{
+			name:   "unterminated string",
+			source: "revision = 'abc\n",
+			err:    "unterminated string",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			result, err := parseModule(test.source)
+			if test.err != "" {
+				if err == nil || !strings.Contains(err.Error(), test.err) {
+					t.Fatalf("expected error containing %q, got %v", test.err, err)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if result.docstring != test.docstring {
+				t.Errorf("expected docstring %q, got %q", test.docstring, result.docstring)
+			}
+			if !reflect.DeepEqual(result.assignments, test.assignments) {
+				t.Errorf("expected assignments %v, got %v", test.assignments, result.assignments)
+			}
+		})
+	}
+}
+
+func TestParseFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "3cd5b1a7fe01_merge.py")
+	source := `"""merge main and feature
+
+Revision ID: 3cd5b1a7fe01
+Create Date: 2022-09-01 12:00:00.000000
+
+"""
+revision = "3cd5b1a7fe01"
+down_revision = ("27c6a30d7c24", "f5a0b9e8d7c6")
+branch_labels = "main"
+`
+	if err := os.WriteFile(path, []byte(source), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	revision, err := ParseFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := &Revision{
+		Revision:      "3cd5b1a7fe01",
+		DownRevisions: []string{"27c6a30d7c24", "f5a0b9e8d7c6"},
+		BranchLabels:  []string{"main"},
+		DependsOn:     []string{},
+		Doc:           "merge main and feature\n\nRevision ID: 3cd5b1a7fe01\nCreate Date: 2022-09-01 12:00:00.000000",
+		Message:       "merge main and feature",
+		CreateDate:    "2022-09-01 12:00:00.000000",
+		Path:          path,
+	}
+	if !reflect.DeepEqual(revision, expected) {
+		t.Fatalf("expected %+v, got %+v", expected, revision)
+	}
+
+	for source, err := range map[string]string{
+		"down_revision = None\n":  "could not determine revision id",
+		"revision = ('a', 'b')\n": "'revision' must be a string",
+	} {
+		if err := os.WriteFile(path, []byte(source), 0600); err != nil {
+			t.Fatal(err)
+		}
+		if _, result := ParseFile(path); result == nil || !strings.Contains(result.Error(), err) {
+			t.Errorf("expected error containing %q, got %v", err, result)
+		}
+	}
+}
@@ -0,0 +1,146 @@
+package script
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Revision is a migration script as declared in its source file
+type Revision struct {
+	Revision      string
+	DownRevisions []string
+	BranchLabels  []string
+	DependsOn     []string
+	Doc           string
+	Message       string
+	CreateDate    string
+	Path          string
+}
+
+// Revisions loads every migration script in the configured version locations
+func (c *Config) Revisions() ([]Revision, error) {
+	var revisions []Revision
+
+	for _, location := range c.VersionLocations {
+		files, err := c.scriptFiles(location)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, file := range files {
+			revision, err := ParseFile(file)
+			if err != nil {
+				return nil, err
+			}
+			revisions = append(revisions, *revision)
+		}
+	}
+
+	return revisions, nil
+}
+
+// scriptFiles lists the migration script files within a single version location
+func (c *Config) scriptFiles(location string) ([]string, error) {
+	var files []string
+
+	if !c.RecursiveVersionLocations {
+		entries, err := os.ReadDir(location)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, entry := range entries {
+			if !entry.IsDir() && isScriptFile(entry.Name()) {
+				files = append(files, filepath.Join(location, entry.Name()))
+			}
+		}
+
+		return files, nil
+	}
+
+	err := filepath.WalkDir(location, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if entry.IsDir() && entry.Name() == "__pycache__" {
+			return filepath.SkipDir
+		}
+		if !entry.IsDir() && isScriptFile(entry.Name()) {
+			files = append(files, path)
+		}
+		return nil
+	})
+
+	sort.Strings(files)
+
+	return files, err
+}
+
+// alembic only loads ".py" files which are neither editor lock files nor package markers
+func isScriptFile(name string) bool {
+	return strings.HasSuffix(name, ".py") && !strings.HasPrefix(name, ".#") && !strings.HasPrefix(name, "__init__")
+}
+
+// ParseFile statically extracts the revision identifiers and docstring from a migration
+// script without executing it. The revision variables must be assigned literal values.
+func ParseFile(path string) (*Revision, error) {
+	source, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	module, err := parseModule(string(source))
+	if err != nil {
+		return nil, fmt.Errorf("%v: %v", path, err)
+	}
+
+	revision := &Revision{
+		Path: path,
+		Doc:  strings.TrimSpace(module.docstring),
+	}
+
+	value, ok := module.assignments["revision"]
+	if !ok {
+		return nil, fmt.Errorf("%v: could not determine revision id; be sure the 'revision' variable is declared inside the script", path)
+	} else if value.kind != literalString {
+		return nil, fmt.Errorf("%v: 'revision' must be a string", path)
+	}
+	revision.Revision = value.text
+
+	for name, target := range map[string]*[]string{
+		"down_revision": &revision.DownRevisions,
+		"branch_labels": &revision.BranchLabels,
+		"depends_on":    &revision.DependsOn,
+	} {
+		*target = []string{}
+
+		value, ok := module.assignments[name]
+		if !ok {
+			continue
+		}
+
+		switch value.kind {
+		case literalNone:
+		case literalString:
+			*target = []string{value.text}
+		case literalSequence:
+			*target = append(*target, value.items...)
+		}
+	}
+
+	// The message is the first paragraph of the docstring
+	revision.Message = strings.SplitN(revision.Doc, "\n\n", 2)[0]
+
+	for _, line := range strings.Split(revision.Doc, "\n") {
+		if strings.HasPrefix(line, "Create Date: ") {
+			revision.CreateDate = strings.TrimSpace(strings.TrimPrefix(line, "Create Date: "))
+			break
+		}
+	}
+
+	return revision, nil
+}
@@ -0,0 +1,307 @@
+package alembic
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/terraform-plugin-framework-validators/schemavalidator"
+	"github.com/hashicorp/terraform-plugin-framework/diag"
+	"github.com/hashicorp/terraform-plugin-framework/path"
+	"github.com/hashicorp/terraform-plugin-framework/tfsdk"
+	"github.com/hashicorp/terraform-plugin-framework/types"
+	"github.com/hashicorp/terraform-plugin-log/tflog"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+)
+
+// How long connecting and authenticating to the SSH server may take
+const sshTunnelTimeout = 30 * time.Second
+
+// sshTunnelData is the 'ssh_tunnel' attribute of a resource or data source
+type sshTunnelData struct {
+	Host                 types.String `tfsdk:"host"`
+	Port                 types.Int64  `tfsdk:"port"`
+	User                 types.String `tfsdk:"user"`
+	PrivateKey           types.String `tfsdk:"private_key"`
+	PrivateKeyPassphrase types.String `tfsdk:"private_key_passphrase"`
+	HostKey              types.String `tfsdk:"host_key"`
+	RemoteHost           types.String `tfsdk:"remote_host"`
+	RemotePort           types.Int64  `tfsdk:"remote_port"`
+	LocalPort            types.Int64  `tfsdk:"local_port"`
+}
+
+// sshTunnelAttribute is the 'ssh_tunnel' attribute shared by everything with a proxy
+func sshTunnelAttribute() tfsdk.Attribute {
+	return tfsdk.Attribute{
+		Description: "An SSH tunnel to the database through a bastion host, which is used instead of 'proxy_command'. A local port is forwarded to 'remote_host' and 'remote_port' for the duration of each operation, and alembic receives that port in ALEMBIC_PROXY_PORT.",
+		Optional:    true,
+		Validators: []tfsdk.AttributeValidator{
+			schemavalidator.ConflictsWith(path.MatchRoot("proxy_command")),
+		},
+		Attributes: tfsdk.SingleNestedAttributes(map[string]tfsdk.Attribute{
+			"host": {
+				Type:        types.StringType,
+				Description: "Host name or address of the SSH server",
+				Required:    true,
+			},
+			"port": {
+				Type:        types.Int64Type,
+				Description: "Port of the SSH server (default: 22)",
+				Optional:    true,
+			},
+			"user": {
+				Type:        types.StringType,
+				Description: "User to authenticate as",
+				Required:    true,
+			},
+			"private_key": {
+				Type:        types.StringType,
+				Description: "PEM encoded private key to authenticate with. The keys of the SSH agent listening on SSH_AUTH_SOCK are used when this is not set.",
+				Optional:    true,
+				Sensitive:   true,
+			},
+			"private_key_passphrase": {
+				Type:        types.StringType,
+				Description: "Passphrase of an encrypted private key",
+				Optional:    true,
+				Sensitive:   true,
+			},
+			"host_key": {
+				Type:        types.StringType,
+				Description: "Public key of the SSH server, in authorized_keys (e.g. 'ssh-ed25519 AAAA...') or known_hosts format. Connections to servers presenting any other key are refused.",
+				Required:    true,
+			},
+			"remote_host": {
+				Type:        types.StringType,
+				Description: "Host of the database, as seen from the SSH server",
+				Required:    true,
+			},
+			"remote_port": {
+				Type:        types.Int64Type,
+				Description: "Port of the database, as seen from the SSH server",
+				Required:    true,
+			},
+			"local_port": {
+				Type:        types.Int64Type,
+				Description: "Local port to listen on (default: a free port)",
+				Optional:    true,
+			},
+		}),
+	}
+}
+
+// sshTunnel forwards connections to a local port through an SSH connection
+type sshTunnel struct {
+	ctx      context.Context
+	client   *ssh.Client
+	listener net.Listener
+	remote   string
+
+	// Connection to the SSH agent, if it is used
+	agent net.Conn
+
+	// connections tracks forwarded connections, so that closing the tunnel waits for them
+	connections sync.WaitGroup
+}
+
+// openSSHTunnel connects to the SSH server and starts listening on the local port. The
+// tunnel is ready once this returns.
+func openSSHTunnel(ctx context.Context, data sshTunnelData) (*sshTunnel, error) {
+	tunnel := &sshTunnel{
+		ctx:    ctx,
+		remote: net.JoinHostPort(data.RemoteHost.Value, strconv.FormatInt(data.RemotePort.Value, 10)),
+	}
+
+	host_key, err := parseHostKey(data.HostKey.Value)
+	if err != nil {
+		return nil, err
+	}
+
+	var auth ssh.AuthMethod
+	if !data.PrivateKey.Null {
+		var signer ssh.Signer
+		if !data.PrivateKeyPassphrase.Null {
+			signer, err = ssh.ParsePrivateKeyWithPassphrase([]byte(data.PrivateKey.Value), []byte(data.PrivateKeyPassphrase.Value))
+		} else {
+			signer, err = ssh.ParsePrivateKey([]byte(data.PrivateKey.Value))
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid private key: %v", err)
+		}
+		auth = ssh.PublicKeys(signer)
+	} else {
+		socket := os.Getenv("SSH_AUTH_SOCK")
+		if socket == "" {
+			return nil, fmt.Errorf("no private key was given, and SSH_AUTH_SOCK is not set to use the SSH agent")
+		}
+		tunnel.agent, err = net.Dial("unix", socket)
+		if err != nil {
+			return nil, fmt.Errorf("unable to connect to the SSH agent: %v", err)
+		}
+		auth = ssh.PublicKeysCallback(agent.NewClient(tunnel.agent).Signers)
+	}
+
+	port := int64(22)
+	if !data.Port.Null {
+		port = data.Port.Value
+	}
+	address := net.JoinHostPort(data.Host.Value, strconv.FormatInt(port, 10))
+
+	config := &ssh.ClientConfig{
+		User:            data.User.Value,
+		Auth:            []ssh.AuthMethod{auth},
+		HostKeyCallback: ssh.FixedHostKey(host_key),
+	}
+
+	dialer := net.Dialer{Timeout: sshTunnelTimeout}
+	conn, err := dialer.DialContext(ctx, "tcp", address)
+	if err != nil {
+		tunnel.Close()
+		return nil, err
+	}
+
+	// NewClientConn ignores the timeout of the client configuration, so the handshake gets a
+	// deadline of its own
+	conn.SetDeadline(time.Now().Add(sshTunnelTimeout))
+	client_conn, channels, requests, err := ssh.NewClientConn(conn, address, config)
+	if err != nil {
+		conn.Close()
+		tunnel.Close()
+		return nil, err
+	}
+	conn.SetDeadline(time.Time{})
+	tunnel.client = ssh.NewClient(client_conn, channels, requests)
+
+	local_port := int64(0)
+	if !data.LocalPort.Null {
+		local_port = data.LocalPort.Value
+	}
+
+	tunnel.listener, err = net.Listen("tcp", net.JoinHostPort("127.0.0.1", strconv.FormatInt(local_port, 10)))
+	if err != nil {
+		tunnel.Close()
+		return nil, err
+	}
+
+	tflog.Debug(ctx, "opened ssh tunnel", map[string]interface{}{"server": address, "remote": tunnel.remote, "local": tunnel.listener.Addr().String()})
+
+	go tunnel.accept()
+
+	return tunnel, nil
+}
+
+// parseHostKey parses a public key in authorized_keys or known_hosts format
+func parseHostKey(value string) (ssh.PublicKey, error) {
+	if key, _, _, _, err := ssh.ParseAuthorizedKey([]byte(value)); err == nil {
+		return key, nil
+	}
+
+	_, _, key, _, _, err := ssh.ParseKnownHosts([]byte(value))
+	if err != nil {
+		return nil, fmt.Errorf("invalid host key: %v", err)
+	}
+	return key, nil
+}
+
+// Port returns the local port the tunnel listens on
+func (t *sshTunnel) Port() int {
+	return t.listener.Addr().(*net.TCPAddr).Port
+}
+
+// Wait blocks until the SSH connection is closed, and returns why
+func (t *sshTunnel) Wait() error {
+	err := t.client.Wait()
+	if err == nil {
+		err = fmt.Errorf("the SSH server closed the connection")
+	}
+	return err
+}
+
+// Close stops listening and closes the SSH connection along with every forwarded connection
+func (t *sshTunnel) Close() {
+	if t.listener != nil {
+		t.listener.Close()
+	}
+	if t.client != nil {
+		t.client.Close()
+	}
+	if t.agent != nil {
+		t.agent.Close()
+	}
+	t.connections.Wait()
+}
+
+func (t *sshTunnel) accept() {
+	for {
+		local, err := t.listener.Accept()
+		if err != nil {
+			return
+		}
+
+		t.connections.Add(1)
+		go t.forward(local)
+	}
+}
+
+// forward copies data between a local connection and a new connection to the remote address
+func (t *sshTunnel) forward(local net.Conn) {
+	defer t.connections.Done()
+	defer local.Close()
+
+	remote, err := t.client.Dial("tcp", t.remote)
+	if err != nil {
+		tflog.Warn(t.ctx, "ssh tunnel failed to connect to the remote address", map[string]interface{}{"remote": t.remote, "error": err.Error()})
+		return
+	}
+	defer remote.Close()
+
+	done := make(chan struct{}, 2)
+	go func() {
+		io.Copy(remote, local)
+		done <- struct{}{}
+	}()
+	go func() {
+		io.Copy(local, remote)
+		done <- struct{}{}
+	}()
+
+	// Either side closing ends the connection
+	<-done
+}
+
+// startSSHTunnel opens an SSH tunnel as the proxy of an operation
+func startSSHTunnel(ctx context.Context, ssh_tunnel types.Object) (*proxyProcess, diag.Diagnostics) {
+	var diags diag.Diagnostics
+	var data sshTunnelData
+
+	diags.Append(ssh_tunnel.As(ctx, &data, types.ObjectAsOptions{})...)
+	if diags.HasError() {
+		return nil, diags
+	}
+
+	tunnel, err := openSSHTunnel(ctx, data)
+	if err != nil {
+		diags.AddAttributeError(path.Root("ssh_tunnel"), "failed to open ssh tunnel", fmt.Sprintf("Unable to forward '%v' through '%v@%v': %v", net.JoinHostPort(data.RemoteHost.Value, strconv.FormatInt(data.RemotePort.Value, 10)), data.User.Value, data.Host.Value, err))
+		return nil, diags
+	}
+
+	proxy := &proxyProcess{
+		port:   tunnel.Port(),
+		tunnel: tunnel,
+		output: &proxyOutput{ctx: ctx, buffer: newBoundedBuffer(proxyOutputLimit)},
+		done:   make(chan struct{}),
+	}
+
+	go func() {
+		proxy.err = tunnel.Wait()
+		close(proxy.done)
+	}()
+
+	return proxy, diags
+}
@@ -0,0 +1,351 @@
+package alembic
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/hashicorp/terraform-plugin-framework/types"
+	"golang.org/x/crypto/ssh"
+)
+
+// testSSHServer is an in-process SSH server which accepts a single client key and
+// forwards "direct-tcpip" channels, as a bastion host would
+type testSSHServer struct {
+	t        *testing.T
+	listener net.Listener
+	host_key ssh.Signer
+	config   *ssh.ServerConfig
+
+	mutex       sync.Mutex
+	connections []net.Conn
+}
+
+func newTestSSHServer(t *testing.T, client_key ssh.PublicKey) *testSSHServer {
+	t.Helper()
+
+	_, private_key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	host_key, err := ssh.NewSignerFromKey(private_key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	server := &testSSHServer{t: t, listener: listener, host_key: host_key}
+	server.config = &ssh.ServerConfig{
+		PublicKeyCallback: func(_ ssh.ConnMetadata, key ssh.PublicKey) (*ssh.Permissions, error) {
+			if string(key.Marshal()) != string(client_key.Marshal()) {
+				return nil, io.EOF
+			}
+			return nil, nil
+		},
+	}
+	server.config.AddHostKey(host_key)
+
+	go server.accept()
+	t.Cleanup(server.Close)
+
+	return server
+}
+
+func (s *testSSHServer) accept() {
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+
+		s.mutex.Lock()
+		s.connections = append(s.connections, conn)
+		s.mutex.Unlock()
+
+		go s.serve(conn)
+	}
+}
+
+func (s *testSSHServer) serve(conn net.Conn) {
+	_, channels, requests, err := ssh.NewServerConn(conn, s.config)
+	if err != nil {
+		return
+	}
+	go ssh.DiscardRequests(requests)
+
+	for request := range channels {
+		if request.ChannelType() != "direct-tcpip" {
+			request.Reject(ssh.UnknownChannelType, "unsupported channel type")
+			continue
+		}
+
+		var payload struct {
+			Host       string
+			Port       uint32
+			OriginHost string
+			OriginPort uint32
+		}
+		if err := ssh.Unmarshal(request.ExtraData(), &payload); err != nil {
+			request.Reject(ssh.ConnectionFailed, err.Error())
+			continue
+		}
+
+		remote, err := net.Dial("tcp", net.JoinHostPort(payload.Host, strconv.Itoa(int(payload.Port))))
+		if err != nil {
+			request.Reject(ssh.ConnectionFailed, err.Error())
+			continue
+		}
+
+		channel, channel_requests, err := request.Accept()
+		if err != nil {
+			remote.Close()
+			continue
+		}
+		go ssh.DiscardRequests(channel_requests)
+
+		go func() {
+			io.Copy(channel, remote)
+			channel.CloseWrite()
+		}()
+		go func() {
+			io.Copy(remote, channel)
+			remote.Close()
+		}()
+	}
+}
+
+func (s *testSSHServer) Port() int {
+	return s.listener.Addr().(*net.TCPAddr).Port
+}
+
+// Close stops the server and drops every client connection
+func (s *testSSHServer) Close() {
+	s.listener.Close()
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	for _, conn := range s.connections {
+		conn.Close()
+	}
+}
+
+// newTestClientKey returns an RSA private key in PEM format, encrypted when a passphrase is
+// given, along with its public key
+func newTestClientKey(t *testing.T, passphrase string) (string, ssh.PublicKey) {
+	t.Helper()
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	block := &pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)}
+	if passphrase != "" {
+		block, err = x509.EncryptPEMBlock(rand.Reader, block.Type, block.Bytes, []byte(passphrase), x509.PEMCipherAES256)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	public_key, err := ssh.NewPublicKey(&key.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return string(pem.EncodeToMemory(block)), public_key
+}
+
+// newEchoServer listens on a local port, writing back whatever it receives
+func newEchoServer(t *testing.T) int {
+	t.Helper()
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				io.Copy(conn, conn)
+			}()
+		}
+	}()
+
+	return listener.Addr().(*net.TCPAddr).Port
+}
+
+func testSSHTunnelData(server *testSSHServer, private_key string, remote_port int) sshTunnelData {
+	return sshTunnelData{
+		Host:                 types.String{Value: "127.0.0.1"},
+		Port:                 types.Int64{Value: int64(server.Port())},
+		User:                 types.String{Value: "alembic"},
+		PrivateKey:           types.String{Value: private_key},
+		PrivateKeyPassphrase: types.String{Null: true},
+		HostKey:              types.String{Value: string(ssh.MarshalAuthorizedKey(server.host_key.PublicKey()))},
+		RemoteHost:           types.String{Value: "127.0.0.1"},
+		RemotePort:           types.Int64{Value: int64(remote_port)},
+		LocalPort:            types.Int64{Null: true},
+	}
+}
+
+// checkEcho sends a message through the tunnel and expects it back
+func checkEcho(t *testing.T, tunnel *sshTunnel) {
+	t.Helper()
+
+	conn, err := net.Dial("tcp", net.JoinHostPort("127.0.0.1", strconv.Itoa(tunnel.Port())))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	if _, err := conn.Write([]byte("select 1")); err != nil {
+		t.Fatal(err)
+	}
+
+	response := make([]byte, len("select 1"))
+	if _, err := io.ReadFull(conn, response); err != nil {
+		t.Fatal(err)
+	}
+	if string(response) != "select 1" {
+		t.Fatalf("unexpected response %q", response)
+	}
+}
+
+func TestOpenSSHTunnel(t *testing.T) {
+	private_key, public_key := newTestClientKey(t, "")
+	server := newTestSSHServer(t, public_key)
+	remote_port := newEchoServer(t)
+
+	tunnel, err := openSSHTunnel(context.Background(), testSSHTunnelData(server, private_key, remote_port))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tunnel.Close()
+
+	// Several connections may be forwarded at once
+	checkEcho(t, tunnel)
+	checkEcho(t, tunnel)
+
+	// The tunnel reports the SSH server going away
+	server.Close()
+	if err := tunnel.Wait(); err == nil {
+		t.Fatal("expected an error once the server closed the connection")
+	}
+}
+
+func TestOpenSSHTunnelOptions(t *testing.T) {
+	encrypted_key, public_key := newTestClientKey(t, "secret")
+	server := newTestSSHServer(t, public_key)
+	remote_port := newEchoServer(t)
+
+	local, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	local_port := local.Addr().(*net.TCPAddr).Port
+	local.Close()
+
+	data := testSSHTunnelData(server, encrypted_key, remote_port)
+	data.PrivateKeyPassphrase = types.String{Value: "secret"}
+	data.LocalPort = types.Int64{Value: int64(local_port)}
+	data.HostKey = types.String{Value: "[127.0.0.1]:" + strconv.Itoa(server.Port()) + " " + string(ssh.MarshalAuthorizedKey(server.host_key.PublicKey()))}
+
+	tunnel, err := openSSHTunnel(context.Background(), data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tunnel.Close()
+
+	if tunnel.Port() != local_port {
+		t.Fatalf("expected local port %v, got %v", local_port, tunnel.Port())
+	}
+	checkEcho(t, tunnel)
+}
+
+func TestOpenSSHTunnelErrors(t *testing.T) {
+	private_key, public_key := newTestClientKey(t, "")
+	other_key, _ := newTestClientKey(t, "")
+	encrypted_key, _ := newTestClientKey(t, "secret")
+	server := newTestSSHServer(t, public_key)
+	other_server := newTestSSHServer(t, public_key)
+
+	tests := []struct {
+		name   string
+		modify func(data *sshTunnelData)
+		err    string
+	}{
+		{
+			name: "host key mismatch",
+			modify: func(data *sshTunnelData) {
+				data.HostKey = types.String{Value: string(ssh.MarshalAuthorizedKey(other_server.host_key.PublicKey()))}
+			},
+			err: "host key mismatch",
+		},
+		{
+			name:   "invalid host key",
+			modify: func(data *sshTunnelData) { data.HostKey = types.String{Value: "ssh-ed25519 invalid"} },
+			err:    "invalid host key",
+		},
+		{
+			name:   "unknown private key",
+			modify: func(data *sshTunnelData) { data.PrivateKey = types.String{Value: other_key} },
+			err:    "unable to authenticate",
+		},
+		{
+			name:   "missing passphrase",
+			modify: func(data *sshTunnelData) { data.PrivateKey = types.String{Value: encrypted_key} },
+			err:    "invalid private key",
+		},
+		{
+			name: "wrong passphrase",
+			modify: func(data *sshTunnelData) {
+				data.PrivateKey = types.String{Value: encrypted_key}
+				data.PrivateKeyPassphrase = types.String{Value: "wrong"}
+			},
+			err: "invalid private key",
+		},
+		{
+			name: "no agent",
+			modify: func(data *sshTunnelData) {
+				t.Setenv("SSH_AUTH_SOCK", "")
+				data.PrivateKey = types.String{Null: true}
+			},
+			err: "SSH_AUTH_SOCK is not set",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			data := testSSHTunnelData(server, private_key, 5432)
+			test.modify(&data)
+
+			tunnel, err := openSSHTunnel(context.Background(), data)
+			if err == nil {
+				tunnel.Close()
+			}
+			if err == nil || !strings.Contains(err.Error(), test.err) {
+				t.Fatalf("expected error containing %q, got %v", test.err, err)
+			}
+		})
+	}
+}
@@ -3,190 +3,958 @@ package alembic
 import (
 	"bytes"
 	"context"
+	"encoding/json"
 	"fmt"
 	"os"
 	"os/exec"
-	"regexp"
-	"strconv"
+	"path/filepath"
+	"sort"
 	"strings"
-	"time"
 
+	"github.com/calebstewart/terraform-provider-alembic/internal/alembic/script"
+	"github.com/calebstewart/terraform-provider-alembic/internal/alembic/versiontable"
+	"github.com/hashicorp/terraform-plugin-framework/attr"
 	"github.com/hashicorp/terraform-plugin-framework/diag"
+	"github.com/hashicorp/terraform-plugin-framework/path"
+	"github.com/hashicorp/terraform-plugin-framework/resource"
 	"github.com/hashicorp/terraform-plugin-framework/types"
+	"github.com/hashicorp/terraform-plugin-go/tfprotov6"
+	"github.com/hashicorp/terraform-plugin-log/tflog"
 )
 
-var durationRegex = regexp.MustCompile(`P([\d\.]+Y)?([\d\.]+M)?([\d\.]+D)?T?([\d\.]+H)?([\d\.]+M)?([\d\.]+?S)?`)
+// targetState compares the revisions a database is stamped with against a target
+type targetState struct {
+	// Current holds every revision the database is stamped with
+	Current []string
 
-// ParseDuration converts a ISO8601 duration into a time.Duration
-func parseDuration(str string) time.Duration {
-	matches := durationRegex.FindStringSubmatch(str)
+	// Target holds the concrete revisions the target specification resolves to, where
+	// an empty list is "base"
+	Target []string
 
-	years := parseDurationPart(matches[1], time.Hour*24*365)
-	months := parseDurationPart(matches[2], time.Hour*24*30)
-	days := parseDurationPart(matches[3], time.Hour*24)
-	hours := parseDurationPart(matches[4], time.Hour)
-	minutes := parseDurationPart(matches[5], time.Second*60)
-	seconds := parseDurationPart(matches[6], time.Second)
+	// Engines holds the revisions of each engine of the database (see engineRevisions)
+	Engines map[string][]string
 
-	return time.Duration(years + months + days + hours + minutes + seconds)
+	// InSync is set when every engine of the database is already at the target revisions
+	InSync bool
 }
 
-func parseDurationPart(value string, unit time.Duration) time.Duration {
-	if len(value) != 0 {
-		if parsed, err := strconv.ParseFloat(value[:len(value)-1], 64); err == nil {
-			return time.Duration(float64(unit) * parsed)
+func doReadState(
+	ctx context.Context,
+	p alembicProvider,
+	proxy_command types.List,
+	proxy_sleep types.String,
+	proxy_ready types.Object,
+	ssh_tunnel types.Object,
+	alembic_command types.List,
+	extra_values types.Map,
+	environment_values types.Map,
+	database_url types.String,
+	target string,
+	target_revisions types.List,
+) (state targetState, diags diag.Diagnostics) {
+
+	proxy, result_diags := executeProxyCommand(ctx, p, proxy_command, proxy_sleep, proxy_ready, ssh_tunnel)
+	diags.Append(result_diags...)
+	if diags.HasError() {
+		return state, diags
+	}
+	defer stopProxyCommand(proxy, &diags)
+	p = p.withProxy(proxy)
+
+	// Run the "alembic current" command to get the current revisions for the database
+	current, result_diags := readCurrentRevisions(ctx, p, alembic_command, extra_values, environment_values, database_url)
+	diags.Append(result_diags...)
+	if diags.HasError() {
+		return state, diags
+	}
+
+	state.Current = currentRevisionIDs(current)
+	state.Engines = engineRevisions(p, current)
+
+	// Imported resources have no target yet, so there is nothing to compare against
+	if target == "" {
+		state.Target = state.Current
+		state.InSync = true
+		return state, diags
+	}
+
+	graph, result_diags := readRevisionGraph(ctx, p, alembic_command, environment_values)
+	diags.Append(result_diags...)
+	if diags.HasError() {
+		return state, diags
+	}
+
+	// Targets relative to the current revision (e.g. "+1") were resolved when they were
+	// applied. Resolving them again would always move the target, so the stored result
+	// is used instead.
+	if isCurrentRelative(target) && !target_revisions.Null && !target_revisions.Unknown {
+		diags.Append(target_revisions.ElementsAs(ctx, &state.Target, false)...)
+		if diags.HasError() {
+			return state, diags
+		}
+	} else {
+		resolved, err := graph.resolve(target, state.Current)
+		if err != nil {
+			diags.AddError(fmt.Sprintf("failed resolving target revision '%v'", target), err.Error())
+			return state, diags
+		}
+		state.Target = resolved
+	}
+
+	state.InSync = true
+	for _, revisions := range state.Engines {
+		state.InSync = state.InSync && graph.satisfies(revisions, state.Target)
+	}
+
+	return state, diags
+
+}
+
+// upgradePlan describes what moving a database to a target would do
+type upgradePlan struct {
+	// Current holds every revision the database is stamped with
+	Current []string
+
+	// Target holds the concrete revisions the target specification resolves to
+	Target []string
+
+	// Pending holds the revisions which would be applied, in order
+	Pending []alembicRevision
+
+	// Reverted holds the revisions which would be downgraded, in order
+	Reverted []alembicRevision
+
+	// SQL is the SQL alembic would run, or empty if it could not be generated offline
+	SQL string
+
+	// HasSQL is set when SQL was generated
+	HasSQL bool
+}
+
+// command returns the alembic command and the revisions which move the database to the
+// target, one alembic run per revision. Reverting revisions is refused unless downgrades
+// are allowed.
+func (plan upgradePlan) command(target string, allow_downgrade bool) (string, []string, diag.Diagnostics) {
+	var diags diag.Diagnostics
+
+	if len(plan.Reverted) == 0 {
+		// Alembic only accepts a single target, so the revisions of a list of targets are
+		// upgraded to one at a time
+		if strings.Contains(target, ",") {
+			return "upgrade", plan.Target, diags
+		}
+		return "upgrade", []string{target}, diags
+	}
+
+	var reverted []string
+	for _, revision := range plan.Reverted {
+		reverted = append(reverted, "  - "+describeRevision(revision))
+	}
+
+	if len(plan.Pending) > 0 {
+		diags.AddAttributeError(
+			path.Root("target"),
+			"target requires both an upgrade and a downgrade",
+			fmt.Sprintf("Moving the database from %v to '%v' would revert some revisions while applying others. Please downgrade and upgrade the database in separate steps. The reverted revisions are:\n%v", describeRevisions(plan.Current), target, strings.Join(reverted, "\n")),
+		)
+		return "", nil, diags
+	}
+
+	if !allow_downgrade {
+		diags.AddAttributeError(
+			path.Root("target"),
+			"target would downgrade the database",
+			fmt.Sprintf("Moving the database from %v to '%v' would revert the following revisions:\n%v\n\nSet allow_downgrade = true to downgrade the database.", describeRevisions(plan.Current), target, strings.Join(reverted, "\n")),
+		)
+		return "", nil, diags
+	}
+
+	// Relative targets must not be resolved again by alembic, since they are relative to
+	// the revision the database is at. With several target revisions, only those which
+	// have reverted revisions on top of them are downgraded to.
+	if len(plan.Target) == 0 {
+		return "downgrade", []string{"base"}, diags
+	} else if len(plan.Target) == 1 {
+		return "downgrade", plan.Target, diags
+	}
+
+	downgraded := make(map[string]bool)
+	for _, revision := range plan.Reverted {
+		for _, down := range revision.DownRevisions {
+			downgraded[down] = true
+		}
+	}
+
+	var revisions []string
+	for _, id := range plan.Target {
+		if downgraded[id] {
+			revisions = append(revisions, id)
+		}
+	}
+
+	return "downgrade", revisions, diags
+}
+
+// resolveUpgrade determines the revisions moving a database to the target would apply or
+// revert. A proxy, if needed, must already be running.
+func resolveUpgrade(
+	ctx context.Context,
+	p alembicProvider,
+	alembic_command types.List,
+	extra_values types.Map,
+	environment_values types.Map,
+	database_url types.String,
+	target string,
+) (upgradePlan, diag.Diagnostics) {
+
+	revisions, diags := readCurrentRevisions(ctx, p, alembic_command, extra_values, environment_values, database_url)
+	if diags.HasError() {
+		return upgradePlan{}, diags
+	}
+
+	graph, result_diags := readRevisionGraph(ctx, p, alembic_command, environment_values)
+	diags.Append(result_diags...)
+	if diags.HasError() {
+		return upgradePlan{}, diags
+	}
+
+	plan, result_diags := planRevisions(p, graph, revisions, target)
+	diags.Append(result_diags...)
+
+	return plan, diags
+}
+
+// planRevisions determines the revisions moving a database stamped with the given revisions
+// to the target would apply or revert, like resolveUpgrade.
+func planRevisions(p alembicProvider, graph *revisionGraph, revisions []currentRevision, target string) (upgradePlan, diag.Diagnostics) {
+	var diags diag.Diagnostics
+	var plan upgradePlan
+
+	plan.Current = currentRevisionIDs(revisions)
+
+	resolved, err := graph.resolve(target, plan.Current)
+	if err != nil {
+		diags.AddError(fmt.Sprintf("failed resolving target revision '%v'", target), err.Error())
+		return plan, diags
+	}
+	plan.Target = resolved
+
+	// Each engine of a multidb project may be at a different revision
+	pending := make(map[string]bool)
+	reverted := make(map[string]bool)
+	for _, current := range engineRevisions(p, revisions) {
+		for _, id := range graph.pending(current, resolved) {
+			pending[id] = true
+		}
+		for _, id := range graph.reverted(current, resolved) {
+			reverted[id] = true
+		}
+	}
+
+	// Revisions are applied base first, and reverted heads first
+	plan.Pending = []alembicRevision{}
+	plan.Reverted = []alembicRevision{}
+	for i := range graph.order {
+		if id := graph.order[len(graph.order)-1-i]; pending[id] {
+			plan.Pending = append(plan.Pending, *graph.revisions[id])
+		}
+		if id := graph.order[i]; reverted[id] {
+			plan.Reverted = append(plan.Reverted, *graph.revisions[id])
+		}
+	}
+
+	return plan, diags
+}
+
+// planUpgrade determines the revisions moving a database to the target would apply or
+// revert, along with the SQL alembic would run for them. A proxy, if needed, must already
+// be running.
+func planUpgrade(
+	ctx context.Context,
+	p alembicProvider,
+	alembic_command types.List,
+	extra_values types.Map,
+	environment_values types.Map,
+	database_url types.String,
+	tag types.String,
+	target string,
+) (upgradePlan, diag.Diagnostics) {
+
+	plan, diags := resolveUpgrade(ctx, p, alembic_command, extra_values, environment_values, database_url, target)
+	if diags.HasError() {
+		return plan, diags
+	}
+
+	if len(plan.Pending) == 0 && len(plan.Reverted) == 0 {
+		plan.HasSQL = true
+		return plan, diags
+	} else if len(plan.Pending) > 0 && len(plan.Reverted) > 0 {
+		return plan, diags
+	}
+
+	// The multidb template writes the SQL of each engine to a file of its own in offline
+	// mode, rather than to alembic's output
+	if len(p.databases) > 0 {
+		tflog.Debug(ctx, "not generating the planned SQL of a multidb project")
+		return plan, diags
+	}
+
+	// Offline mode cannot query the database, so the range must start at a single known
+	// revision (or base) and end at concrete revisions.
+	if len(plan.Current) > 1 {
+		diags.AddWarning(
+			"unable to generate the planned SQL",
+			fmt.Sprintf("The database is stamped with multiple revisions (%v), but alembic can only generate SQL starting from a single revision.", describeRevisions(plan.Current)),
+		)
+		return plan, diags
+	}
+
+	// Neither can it generate the SQL of several targets at once
+	if strings.Contains(target, ",") && len(plan.Target) > 1 {
+		diags.AddWarning(
+			"unable to generate the planned SQL",
+			fmt.Sprintf("The target resolves to multiple revisions (%v), but alembic can only generate SQL for a single target revision.", describeRevisions(plan.Target)),
+		)
+		return plan, diags
+	}
+
+	command := "upgrade"
+	end := target
+	if len(plan.Target) == 1 {
+		end = plan.Target[0]
+	}
+
+	if len(plan.Reverted) > 0 {
+		command = "downgrade"
+		if len(plan.Target) == 0 {
+			end = "base"
+		}
+	}
+
+	revision_range := end
+	if len(plan.Current) == 1 {
+		revision_range = plan.Current[0] + ":" + end
+	}
+
+	sql, result_diags := generateMigrationSQL(ctx, p, alembic_command, extra_values, environment_values, tag, command, revision_range)
+	diags.Append(result_diags...)
+	if diags.HasError() {
+		return plan, diags
+	}
+	plan.SQL = sql
+	plan.HasSQL = true
+
+	return plan, diags
+}
+
+// validateTarget checks that a target revision specification resolves against the migration
+// scripts, suggesting close matches when it does not. Targets relative to the current
+// revision of the database are not checked, and neither is anything if the migration
+// scripts cannot be read, since that is reported once alembic is actually run.
+func validateTarget(
+	ctx context.Context,
+	p alembicProvider,
+	alembic_command types.List,
+	environment_values types.Map,
+	attribute path.Path,
+	target string,
+) diag.Diagnostics {
+
+	var diags diag.Diagnostics
+
+	if isCurrentRelative(target) {
+		return diags
+	}
+
+	graph, result_diags := readRevisionGraph(ctx, p, alembic_command, environment_values)
+	if result_diags.HasError() {
+		tflog.Warn(ctx, "unable to read the revision graph, skipping target validation")
+		return diags
+	}
+
+	// Each revision of a comma separated list is checked on its own, so the suggestions
+	// are about the one which is wrong
+	for _, spec := range splitSpecs(target) {
+		if _, err := graph.resolve(spec, nil); err != nil {
+			detail := fmt.Sprintf("The target revision '%v' is not valid for the migration scripts in '%v': %v.", spec, p.project_root, err)
+
+			var suggestions []string
+			for _, suggestion := range graph.suggestions(spec) {
+				if revision, ok := graph.revisions[suggestion]; ok {
+					suggestion = describeRevision(*revision)
+				}
+				suggestions = append(suggestions, "  - "+suggestion)
+			}
+
+			if len(suggestions) > 0 {
+				detail += "\n\nDid you mean one of these?\n" + strings.Join(suggestions, "\n")
+			}
+
+			diags.AddAttributeError(attribute, "invalid target revision", detail)
+		}
+	}
+
+	return diags
+}
+
+// attributeSource is the configuration or plan of a resource
+type attributeSource interface {
+	GetAttribute(ctx context.Context, path path.Path, target interface{}) diag.Diagnostics
+}
+
+// targetValidation reads the 'alembic', 'environment', 'project_root', 'config' and 'section'
+// attributes of a resource, which are needed to validate its revision attributes. It reports
+// false when the provider is not configured yet or any of the values is unknown.
+func targetValidation(ctx context.Context, p alembicProvider, source attributeSource) (alembicProvider, types.List, types.Map, bool, diag.Diagnostics) {
+	var diags diag.Diagnostics
+	var alembic_command types.List
+	var environment_values types.Map
+	var project_root types.String
+	var config types.String
+	var section types.String
+
+	if !p.configured {
+		return p, alembic_command, environment_values, false, diags
+	}
+
+	diags.Append(source.GetAttribute(ctx, path.Root("alembic"), &alembic_command)...)
+	diags.Append(source.GetAttribute(ctx, path.Root("environment"), &environment_values)...)
+	diags.Append(source.GetAttribute(ctx, path.Root("project_root"), &project_root)...)
+	diags.Append(source.GetAttribute(ctx, path.Root("config"), &config)...)
+	diags.Append(source.GetAttribute(ctx, path.Root("section"), &section)...)
+	if diags.HasError() || alembic_command.Unknown || environment_values.Unknown || project_root.Unknown ||
+		config.Unknown || section.Unknown {
+		return p, alembic_command, environment_values, false, diags
+	}
+
+	p, result_diags := p.withProject(ctx, project_root, alembic_command, config, section)
+	diags.Append(result_diags...)
+	if diags.HasError() {
+		return p, alembic_command, environment_values, false, diags
+	}
+
+	return p, alembic_command, environment_values, true, diags
+}
+
+// validateTargetAttribute validates a revision attribute of a resource (e.g. 'target') using
+// its 'alembic', 'environment', 'project_root', 'config' and 'section' attributes. Nothing is
+// checked until the provider is configured and all of those values are known.
+func validateTargetAttribute(ctx context.Context, p alembicProvider, source attributeSource, name string) diag.Diagnostics {
+	var diags diag.Diagnostics
+	var target types.String
+
+	diags.Append(source.GetAttribute(ctx, path.Root(name), &target)...)
+	if diags.HasError() || target.Unknown || target.Null {
+		return diags
+	}
+
+	p, alembic_command, environment_values, ok, result_diags := targetValidation(ctx, p, source)
+	diags.Append(result_diags...)
+	if diags.HasError() || !ok {
+		return diags
+	}
+
+	return validateTarget(ctx, p, alembic_command, environment_values, path.Root(name), target.Value)
+}
+
+// validateTargetListAttribute validates each revision of a list attribute of a resource
+// (e.g. 'targets'), like validateTargetAttribute
+func validateTargetListAttribute(ctx context.Context, p alembicProvider, source attributeSource, name string) diag.Diagnostics {
+	var diags diag.Diagnostics
+	var targets types.List
+
+	diags.Append(source.GetAttribute(ctx, path.Root(name), &targets)...)
+	if diags.HasError() || targets.Unknown || targets.Null {
+		return diags
+	}
+
+	p, alembic_command, environment_values, ok, result_diags := targetValidation(ctx, p, source)
+	diags.Append(result_diags...)
+	if diags.HasError() || !ok {
+		return diags
+	}
+
+	for i, elem := range targets.Elems {
+		target, ok := elem.(types.String)
+		if !ok || target.Unknown || target.Null {
+			continue
 		}
+		diags.Append(validateTarget(ctx, p, alembic_command, environment_values, path.Root(name).AtListIndex(i), target.Value)...)
 	}
-	return 0
+
+	return diags
 }
 
-func executeProxyCommand(ctx context.Context, proxy_command types.List, proxy_sleep types.String) (*exec.Cmd, diag.Diagnostics) {
+// resolveTarget resolves a target revision specification into concrete revision IDs
+// before it is applied. A proxy, if needed, must already be running.
+func resolveTarget(
+	ctx context.Context,
+	p alembicProvider,
+	alembic_command types.List,
+	extra_values types.Map,
+	environment_values types.Map,
+	database_url types.String,
+	target string,
+) ([]string, diag.Diagnostics) {
+
 	var diags diag.Diagnostics
-	var args []string
-	var sleep_duration time.Duration
+	var current []string
 
-	// This is fine, we don't need a proxy command
-	if proxy_command.Null {
+	graph, result_diags := readRevisionGraph(ctx, p, alembic_command, environment_values)
+	diags.Append(result_diags...)
+	if diags.HasError() {
+		return nil, diags
+	}
+
+	// The database only needs to be queried for targets relative to its current revision
+	if isCurrentRelative(target) {
+		revisions, result_diags := readCurrentRevisions(ctx, p, alembic_command, extra_values, environment_values, database_url)
+		diags.Append(result_diags...)
+		if diags.HasError() {
+			return nil, diags
+		}
+
+		current = currentRevisionIDs(revisions)
+	}
+
+	resolved, err := graph.resolve(target, current)
+	if err != nil {
+		diags.AddError(fmt.Sprintf("failed resolving target revision '%v'", target), err.Error())
 		return nil, diags
 	}
 
-	diags.Append(proxy_command.ElementsAs(ctx, &args, false)...)
+	return resolved, diags
+}
+
+// Name under which revisions of a database are reported when the project is not based on
+// alembic's multidb template
+const defaultEngine = "default"
+
+// currentRevision is a single revision the database is stamped with
+type currentRevision struct {
+	Revision string `json:"revision"`
+	IsHead   bool   `json:"is_head"`
+
+	// Engine is the name of the database of a multidb project, or empty otherwise
+	Engine string `json:"engine"`
+}
+
+// readCurrentRevisions returns every revision the database is currently stamped with. When
+// a database URL of a supported dialect is configured the version table is read directly,
+// otherwise this asks alembic through the helper script. The same goes for the databases
+// of a multidb project, where each revision records the engine it belongs to. A proxy, if
+// needed, must already be running.
+func readCurrentRevisions(
+	ctx context.Context,
+	p alembicProvider,
+	alembic_command types.List,
+	extra_values types.Map,
+	environment_values types.Map,
+	database_url types.String,
+) ([]currentRevision, diag.Diagnostics) {
+
+	var diags diag.Diagnostics
+	var revisions []currentRevision
+
+	urls := map[string]string{}
+	if len(p.databases) > 0 {
+		urls = p.proxyDatabases()
+	} else if !database_url.Null && !database_url.Unknown && database_url.Value != "" {
+		urls[""] = p.proxyURL(database_url.Value)
+	} else if p.database_url != "" {
+		urls[""] = p.proxyURL(p.database_url)
+	}
+
+	// Databases of other dialects are left to alembic
+	for _, url := range urls {
+		if !versiontable.Supported(url) {
+			tflog.Debug(ctx, "unsupported database dialect, falling back to the alembic helper")
+			urls = nil
+			break
+		}
+	}
+
+	if len(urls) == 0 {
+		result, diags := runHelper(ctx, p, alembic_command, extra_values, environment_values, "current")
+		if diags.HasError() {
+			return nil, diags
+		}
+
+		return result.Current, diags
+	}
+
+	// The version table does not record whether a revision is a head
+	graph, result_diags := readRevisionGraph(ctx, p, alembic_command, environment_values)
+	diags.Append(result_diags...)
 	if diags.HasError() {
 		return nil, diags
 	}
 
-	if !proxy_sleep.Null {
-		sleep_duration = parseDuration(proxy_sleep.Value)
-	} else {
-		sleep_duration = 5 * time.Second
+	var engines []string
+	for engine := range urls {
+		engines = append(engines, engine)
 	}
+	sort.Strings(engines)
 
-	proc := exec.CommandContext(ctx, args[0], args[1:]...)
+	for _, engine := range engines {
+		versions, err := versiontable.Read(ctx, urls[engine], p.project_root, p.version_table, p.version_table_schema)
+		if err != nil {
+			summary := "failed reading the alembic version table"
+			if engine != "" {
+				summary = fmt.Sprintf("failed reading the alembic version table of '%v'", engine)
+			}
+			diags.AddError(summary, err.Error())
+			return nil, diags
+		}
 
-	// No stdio for the proxy
-	proc.Stdin = nil
-	proc.Stderr = nil
-	proc.Stdout = nil
+		for _, version := range versions {
+			revision := currentRevision{Revision: version, Engine: engine}
+			if known, ok := graph.revisions[version]; ok {
+				revision.IsHead = known.IsHead
+			}
+			revisions = append(revisions, revision)
+		}
+	}
+
+	return revisions, diags
+}
+
+// currentRevisionIDs returns the distinct revisions of a database, across all of its engines
+func currentRevisionIDs(revisions []currentRevision) []string {
+	ids := []string{}
+	seen := make(map[string]bool)
+	for _, revision := range revisions {
+		if !seen[revision.Revision] {
+			seen[revision.Revision] = true
+			ids = append(ids, revision.Revision)
+		}
+	}
+	return ids
+}
+
+// engineRevisions groups the revisions of a database by engine. Every configured engine of
+// a multidb project is present, even if it has not been stamped yet, and the revisions of a
+// single database are listed under the default engine name.
+func engineRevisions(p alembicProvider, revisions []currentRevision) map[string][]string {
+	engines := make(map[string][]string)
+	for engine := range p.databases {
+		engines[engine] = []string{}
+	}
+	if len(p.databases) == 0 {
+		engines[defaultEngine] = []string{}
+	}
+
+	for _, revision := range revisions {
+		engine := revision.Engine
+		if engine == "" {
+			engine = defaultEngine
+		}
+		engines[engine] = append(engines[engine], revision.Revision)
+	}
+
+	return engines
+}
+
+// readHeads returns the head revision IDs of the script directory
+func readHeads(
+	ctx context.Context,
+	p alembicProvider,
+	alembic_command types.List,
+	environment_values types.Map,
+) ([]string, diag.Diagnostics) {
+
+	graph, diags := readRevisionGraph(ctx, p, alembic_command, environment_values)
+	if diags.HasError() {
+		return nil, diags
+	}
+
+	return graph.heads(), diags
+}
+
+// readHistory returns the revisions between two revision specifications (inclusive) in
+// the order 'alembic history' lists them, newest first. Empty specifications default to
+// base and heads.
+func readHistory(
+	ctx context.Context,
+	p alembicProvider,
+	alembic_command types.List,
+	environment_values types.Map,
+	from string,
+	to string,
+) ([]alembicRevision, diag.Diagnostics) {
+
+	if from == "" {
+		from = "base"
+	}
+	if to == "" {
+		to = "heads"
+	}
 
-	err := proc.Start()
+	graph, diags := readRevisionGraph(ctx, p, alembic_command, environment_values)
+	if diags.HasError() {
+		return nil, diags
+	}
+
+	lower, err := graph.resolve(from, nil)
 	if err != nil {
-		diags.AddError(fmt.Sprintf("failed to start sql proxy: %v", args), err.Error())
+		diags.AddError(fmt.Sprintf("failed resolving revision '%v'", from), err.Error())
 		return nil, diags
 	}
 
-	// Wait a bit for the proxy to come alive
-	time.Sleep(sleep_duration)
+	upper, err := graph.resolve(to, nil)
+	if err != nil {
+		diags.AddError(fmt.Sprintf("failed resolving revision '%v'", to), err.Error())
+		return nil, diags
+	}
 
-	return proc, diags
+	history, err := graph.history(lower, upper)
+	if err != nil {
+		diags.AddError(fmt.Sprintf("failed listing the history from '%v' to '%v'", from, to), err.Error())
+		return nil, diags
+	}
+
+	revisions := []alembicRevision{}
+	for _, id := range history {
+		revisions = append(revisions, *graph.revisions[id])
+	}
 
+	return revisions, diags
 }
 
-func doReadState(
+// resolveRevisions resolves a revision specification (e.g. "heads", a partial revision ID
+// or "branch@head") into the metadata of the matching revisions. Specifications relative
+// to the current revision are taken relative to base, since no database is consulted.
+func resolveRevisions(
+	ctx context.Context,
+	p alembicProvider,
+	alembic_command types.List,
+	environment_values types.Map,
+	revision string,
+) ([]alembicRevision, diag.Diagnostics) {
+
+	var revisions []alembicRevision
+
+	graph, diags := readRevisionGraph(ctx, p, alembic_command, environment_values)
+	if diags.HasError() {
+		return nil, diags
+	}
+
+	ids, err := graph.resolve(revision, nil)
+	if err != nil {
+		diags.AddError(fmt.Sprintf("failed resolving revision '%v'", revision), err.Error())
+		return nil, diags
+	}
+
+	for _, id := range ids {
+		revisions = append(revisions, *graph.revisions[id])
+	}
+
+	return revisions, diags
+}
+
+// resolveRevision resolves a revision specification which must match exactly one revision
+func resolveRevision(
 	ctx context.Context,
 	p alembicProvider,
-	proxy_command types.List,
-	proxy_sleep types.String,
 	alembic_command types.List,
-	extra_values types.Map,
 	environment_values types.Map,
 	revision string,
-) (string, string, diag.Diagnostics) {
+) (alembicRevision, diag.Diagnostics) {
+
+	revisions, diags := resolveRevisions(ctx, p, alembic_command, environment_values, revision)
+	if diags.HasError() {
+		return alembicRevision{}, diags
+	}
+
+	if len(revisions) != 1 {
+		diags.AddError(
+			fmt.Sprintf("revision '%v' did not resolve to a single revision", revision),
+			fmt.Sprintf("Expected exactly one revision, but it resolved to %v.", len(revisions)),
+		)
+		return alembicRevision{}, diags
+	}
+
+	return revisions[0], diags
+}
+
+// readScriptRevisions loads every revision directly from the migration scripts, without
+// running alembic.
+func readScriptRevisions(p alembicProvider) ([]alembicRevision, error) {
+	var revisions []alembicRevision
+
+	config, err := script.ReadConfig(p.project_root, p.config, p.section)
+	if err != nil {
+		return nil, err
+	}
+
+	scripts, err := config.Revisions()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, revision := range scripts {
+		revisions = append(revisions, alembicRevision{
+			Revision:      revision.Revision,
+			DownRevisions: revision.DownRevisions,
+			BranchLabels:  revision.BranchLabels,
+			DependsOn:     revision.DependsOn,
+			Message:       revision.Message,
+			CreateDate:    revision.CreateDate,
+			Path:          revision.Path,
+		})
+	}
+
+	return revisions, nil
+}
+
+// readRevisionGraph loads every revision in the script directory. The migration scripts
+// are parsed natively where possible, falling back to asking alembic through the helper
+// script for scripts which cannot be statically evaluated.
+func readRevisionGraph(
+	ctx context.Context,
+	p alembicProvider,
+	alembic_command types.List,
+	environment_values types.Map,
+) (*revisionGraph, diag.Diagnostics) {
 
 	var diags diag.Diagnostics
+
+	revisions, err := readScriptRevisions(p)
+	if err == nil {
+		graph, err := newRevisionGraph(revisions)
+		if err == nil {
+			return graph, diags
+		}
+		tflog.Warn(ctx, "failed building the revision graph from the migration scripts", map[string]interface{}{"error": err.Error()})
+	} else {
+		tflog.Warn(ctx, "failed parsing the migration scripts, falling back to the alembic helper", map[string]interface{}{"error": err.Error()})
+	}
+
+	result, diags := runHelper(ctx, p, alembic_command, types.Map{Null: true}, environment_values, "history", ":")
+	if diags.HasError() {
+		return nil, diags
+	}
+	revisions = result.Revisions
+
+	graph, err := newRevisionGraph(revisions)
+	if err != nil {
+		diags.AddError("failed building the revision graph", err.Error())
+		return nil, diags
+	}
+
+	return graph, diags
+}
+
+// runMigration upgrades, downgrades or stamps the database (depending on command) to a revision. This
+// runs through the provider's worker when one is enabled, and runs alembic otherwise. Only stamp
+// accepts several revisions.
+func runMigration(
+	ctx context.Context,
+	p alembicProvider,
+	alembic_command types.List,
+	extra_values types.Map,
+	environment_values types.Map,
+	tag types.String,
+	command string,
+	revisions ...string,
+) diag.Diagnostics {
+
 	var stderr bytes.Buffer
 	var stdout bytes.Buffer
-	var upgraded_revision string
-	var real_head string
 
-	proxy, result_diags := executeProxyCommand(ctx, proxy_command, proxy_sleep)
-	diags.Append(result_diags...)
-	if diags.HasError() {
-		return "", "", diags
+	if p.worker != nil && alembic_command.Null {
+		request, diags := newWorkerRequest(ctx, p, extra_values, environment_values, command, revisions...)
+		if diags.HasError() {
+			return diags
+		}
+		request.Tag = tag.Value
+
+		_, diags = p.worker.run(ctx, request)
+		return diags
 	}
-	defer proxy.Process.Kill()
 
-	// Run the "alembic current" command to get the current revision for the database
-	proc, result_diags := buildAlembicCommand(ctx, p, alembic_command, extra_values, environment_values, "current")
-	diags.Append(result_diags...)
+	// The alembic command line has no way to pass the database URLs of a multidb project
+	if len(p.databases) > 0 {
+		args := append([]string{command}, revisions...)
+		if !tag.Null {
+			args = append(args, "--tag", tag.Value)
+		}
+
+		_, diags := runHelper(ctx, p, alembic_command, extra_values, environment_values, args...)
+		return diags
+	}
+
+	proc, diags := buildUpgradeOrDowngradeCommand(ctx, p, alembic_command, extra_values, environment_values, tag, revisions, command)
 	if diags.HasError() {
-		return "", "", diags
+		return diags
 	}
 
-	// Store standard output which has our new revision
+	// Capture output for diagnostics
 	proc.Stdout = &stdout
 	proc.Stderr = &stderr
 
 	err := proc.Run()
 	if err != nil {
 		diags.AddError(
-			fmt.Sprintf("alembic current failed: %v", err),
+			fmt.Sprintf("alembic %v failed: %v", command, err),
 			fmt.Sprintf("Standard Output:\n%v\n\nStandard Error:\n%v\n\n", stdout.String(), stderr.String()),
 		)
-		return "", "", diags
 	}
 
-	// Store the resulting revision ID
-	upgraded_revision = strings.Split(strings.Trim(stdout.String(), "\n\r"), " ")[0]
-	real_head = upgraded_revision
-
-	// If the target revision is the special name "head", we need to resolve this to
-	// the revision ID to make sure we are actually at "head", and trigger an update if
-	// needed.
-	if revision == "head" {
-		// the  'alembic show' command can reoslve symbolic names to revision IDs
-		proc, result_diags = buildAlembicCommand(ctx, p, alembic_command, extra_values, environment_values, "show", revision)
-		diags.Append(result_diags...)
-		if diags.HasError() {
-			return "", "", diags
-		}
+	return diags
+}
 
-		// Capture output
-		stderr.Reset()
-		stdout.Reset()
-		proc.Stderr = &stderr
-		proc.Stdout = &stdout
+// generateMigrationSQL returns the SQL alembic would run to upgrade or downgrade (depending
+// on command) the database across a revision range (e.g. "ae10:head"), using alembic's
+// offline (--sql) mode.
+func generateMigrationSQL(
+	ctx context.Context,
+	p alembicProvider,
+	alembic_command types.List,
+	extra_values types.Map,
+	environment_values types.Map,
+	tag types.String,
+	command string,
+	revisions string,
+) (string, diag.Diagnostics) {
 
-		// Run the process
-		err := proc.Run()
-		if err != nil {
-			diags.AddError(
-				fmt.Sprintf("alembic current failed: %v", err),
-				fmt.Sprintf("Standard Output:\n%v\n\nStandard Error:\n%v\n\n", stdout.String(), stderr.String()),
-			)
-			return "", "", diags
-		}
+	var stderr bytes.Buffer
+	var stdout bytes.Buffer
 
-		// The first line of the output shoud loook like "Rev: 774ddff6187f (head)"
-		expression := `(?m)^Rev: ([a-f0-9]+) \(` + regexp.QuoteMeta(revision) + `\)$`
-		exp, err := regexp.Compile(expression)
-		if err != nil {
-			diags.AddError(fmt.Sprintf("failed to compile revision expression: '%v'", expression), err.Error())
-			return "", "", diags
+	if p.worker != nil && alembic_command.Null {
+		request, diags := newWorkerRequest(ctx, p, extra_values, environment_values, command, revisions)
+		if diags.HasError() {
+			return "", diags
 		}
+		request.Tag = tag.Value
+		request.SQL = true
 
-		// Find the matching sub expressions
-		matches := exp.FindStringSubmatch(stdout.String())
-		if matches == nil || matches[1] == "" {
-			diags.AddError(
-				"failed parsing alembic show results",
-				fmt.Sprintf(
-					"The regular expression '%v' did not match. If Alembic changes output formats, please submit an\nissue at https://github.com/calebstewart/terraform-provider-alembic.\n\nStandard Output:\n%v\n",
-					expression,
-					stdout.String(),
-				),
-			)
-			return "", "", diags
+		result, diags := p.worker.run(ctx, request)
+		if diags.HasError() {
+			return "", diags
 		}
+		return result.Output, diags
+	}
 
-		// The meaning of 'head' changed, so change the state value for revision
-		// to match the current head. This will trigger a upgrade for the resource.
-		if matches[1] != upgraded_revision {
-			real_head = matches[1]
-		}
+	proc, diags := buildUpgradeOrDowngradeCommand(ctx, p, alembic_command, extra_values, environment_values, tag, []string{revisions}, command, "--sql")
+	if diags.HasError() {
+		return "", diags
 	}
 
-	return upgraded_revision, real_head, diags
+	// The SQL is written to standard output, while logging goes to standard error
+	proc.Stdout = &stdout
+	proc.Stderr = &stderr
 
+	err := proc.Run()
+	if err != nil {
+		diags.AddError(
+			fmt.Sprintf("alembic %v --sql failed: %v", command, err),
+			fmt.Sprintf("Standard Output:\n%v\n\nStandard Error:\n%v\n\n", stdout.String(), stderr.String()),
+		)
+		return "", diags
+	}
+
+	return stdout.String(), diags
 }
 
 func buildUpgradeOrDowngradeCommand(
@@ -196,7 +964,7 @@ func buildUpgradeOrDowngradeCommand(
 	extra_values types.Map,
 	environment_values types.Map,
 	tag types.String,
-	revision string,
+	revisions []string,
 	args ...string,
 ) (*exec.Cmd, diag.Diagnostics) {
 
@@ -205,8 +973,8 @@ func buildUpgradeOrDowngradeCommand(
 		args = append(args, "--tag", tag.Value)
 	}
 
-	// Add the revision
-	args = append(args, revision)
+	// Add the revisions
+	args = append(args, revisions...)
 
 	return buildAlembicCommand(ctx, p, alembic_command, extra_values, environment_values, args...)
 }
@@ -233,25 +1001,96 @@ func buildAlembicCommand(
 		alembic = p.alembic
 	}
 
-	// Add provider extras
-	if p.extra != nil {
-		for k, v := range p.extra {
-			alembic = append(alembic, "-x", fmt.Sprintf("%v=%v", k, v))
-		}
+	// Name the configuration file and section, which may be overridden per resource, unless
+	// the alembic command already names them itself
+	alembic = append([]string{}, alembic...)
+	if _, ok := commandOption(alembic, "-c", "--config"); !ok {
+		alembic = append(alembic, "-c", p.config)
+	}
+	if _, ok := commandOption(alembic, "-n", "--name"); !ok {
+		alembic = append(alembic, "-n", p.section)
 	}
 
-	// Add resource extras
-	if !extra_values.Null {
-		var extra map[string]string
-		diags.Append(extra_values.ElementsAs(ctx, &extra, false)...)
-		if diags.HasError() {
-			return nil, diags
+	return buildCommand(ctx, p, alembic, extra_values, environment_values, args...)
+}
+
+// commandOption returns the value of an option in the alembic arguments of a command line,
+// given in its short ("-c x" or "-cx") or long ("--config x" or "--config=x") form. As with
+// alembic, the last occurrence wins. Only arguments following the alembic executable (or
+// "-m alembic") are alembic's own, so the options of wrapper commands such as
+// "nice -n 10 alembic" are not mistaken for it.
+func commandOption(command []string, short string, long string) (string, bool) {
+	args := alembicArguments(command)
+
+	value := ""
+	found := false
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		switch {
+		case arg == "--":
+			return value, found
+		case arg == "-x":
+			// The value of an extra argument is never an option itself
+			i += 1
+		case arg == short || arg == long:
+			if i+1 < len(args) {
+				value, found = args[i+1], true
+				i += 1
+			}
+		case strings.HasPrefix(arg, long+"="):
+			value, found = strings.TrimPrefix(arg, long+"="), true
+		case strings.HasPrefix(arg, short) && !strings.HasPrefix(arg, "--"):
+			value, found = strings.TrimPrefix(arg, short), true
 		}
+	}
 
-		for k, v := range extra {
-			alembic = append(alembic, "-x", fmt.Sprintf("%v=%v", k, v))
+	return value, found
+}
+
+// alembicArguments returns the arguments following the alembic executable in a command
+// line, which may be prefixed with a wrapper (e.g. "sudo" or "python -m"). Without an
+// alembic executable, no arguments are known to be alembic's.
+func alembicArguments(command []string) []string {
+	if i := alembicExecutable(command); i >= 0 {
+		return command[i+1:]
+	}
+	return nil
+}
+
+// alembicExecutable returns the index of the alembic executable (or the "alembic" of
+// "python -m alembic") in a command line, or -1 if there is none.
+func alembicExecutable(command []string) int {
+	for i, arg := range command {
+		name := strings.TrimSuffix(filepath.Base(arg), ".exe")
+		if name == "alembic" {
+			return i
 		}
 	}
+	return -1
+}
+
+// buildCommand builds a command which runs from the project root with the configured
+// environment and the provider and resource extras passed as "-x" arguments.
+func buildCommand(
+	ctx context.Context,
+	p alembicProvider,
+	command []string,
+	extra_values types.Map,
+	environment_values types.Map,
+	args ...string,
+) (*exec.Cmd, diag.Diagnostics) {
+
+	// Never modify the caller's (or the provider's) argument list
+	alembic := append([]string{}, command...)
+
+	// Add provider and resource extras
+	extra, diags := extraArguments(ctx, p, extra_values)
+	if diags.HasError() {
+		return nil, diags
+	}
+	for _, value := range extra {
+		alembic = append(alembic, "-x", value)
+	}
 
 	// Add our specific alembic sub-command
 	alembic = append(alembic, args...)
@@ -278,8 +1117,91 @@ func buildAlembicCommand(
 		}
 	}
 
+	// Tell env.py where a proxy listening on a port picked by the provider is
+	if p.proxy_port != 0 {
+		proc.Env = append(proc.Env, fmt.Sprintf("%v=%v", proxyPortVariable, p.proxy_port))
+	}
+
 	// Ensure the process runs from the project root directory
 	proc.Dir = p.project_root
 
 	return proc, diags
 }
+
+// extraArguments formats the provider extras followed by the resource extras as the
+// "key=value" pairs passed to alembic with "-x".
+func extraArguments(ctx context.Context, p alembicProvider, extra_values types.Map) ([]string, diag.Diagnostics) {
+	var diags diag.Diagnostics
+	var result []string
+
+	// Add provider extras
+	for k, v := range p.extra {
+		result = append(result, fmt.Sprintf("%v=%v", k, v))
+	}
+
+	// Add resource extras
+	if !extra_values.Null {
+		var extra map[string]string
+		diags.Append(extra_values.ElementsAs(ctx, &extra, false)...)
+		if diags.HasError() {
+			return nil, diags
+		}
+
+		for k, v := range extra {
+			result = append(result, fmt.Sprintf("%v=%v", k, v))
+		}
+	}
+
+	return result, diags
+}
+
+// stringList converts a slice of strings into a known terraform list value
+func stringList(values []string) types.List {
+	elems := make([]attr.Value, 0, len(values))
+	for _, value := range values {
+		elems = append(elems, types.String{Value: value})
+	}
+	return types.List{ElemType: types.StringType, Elems: elems}
+}
+
+// stringSet converts a slice of strings into a known terraform set value
+func stringSet(values []string) types.Set {
+	elems := make([]attr.Value, 0, len(values))
+	for _, value := range values {
+		elems = append(elems, types.String{Value: value})
+	}
+	return types.Set{ElemType: types.StringType, Elems: elems}
+}
+
+// revisionMap converts the revisions of each engine into a 'revision' attribute value, where
+// multiple revisions of one engine are joined by commas and no revisions is empty.
+func revisionMap(engines map[string][]string) types.Map {
+	result := types.Map{ElemType: types.StringType, Elems: map[string]attr.Value{}}
+	for engine, revisions := range engines {
+		result.Elems[engine] = types.String{Value: strings.Join(revisions, ",")}
+	}
+	return result
+}
+
+// upgradeRevisionState upgrades the state of alembic_upgrade and alembic_stamp resources from
+// schema version 2, where 'revision' was a single string, to a map keyed by engine.
+func upgradeRevisionState(ctx context.Context, req resource.UpgradeStateRequest, resp *resource.UpgradeStateResponse) {
+	var state map[string]interface{}
+
+	if err := json.Unmarshal(req.RawState.JSON, &state); err != nil {
+		resp.Diagnostics.AddError("failed reading the previous resource state", err.Error())
+		return
+	}
+
+	if revision, ok := state["revision"].(string); ok {
+		state["revision"] = map[string]string{defaultEngine: revision}
+	}
+
+	raw, err := json.Marshal(state)
+	if err != nil {
+		resp.Diagnostics.AddError("failed writing the upgraded resource state", err.Error())
+		return
+	}
+
+	resp.DynamicValue = &tfprotov6.DynamicValue{JSON: raw}
+}
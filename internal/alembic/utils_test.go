@@ -0,0 +1,832 @@
+package alembic
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/hashicorp/terraform-plugin-framework/attr"
+	"github.com/hashicorp/terraform-plugin-framework/path"
+	"github.com/hashicorp/terraform-plugin-framework/resource"
+	"github.com/hashicorp/terraform-plugin-framework/types"
+	"github.com/hashicorp/terraform-plugin-go/tfprotov6"
+)
+
+func TestBuildAlembicCommand(t *testing.T) {
+	p := alembicProvider{
+		project_root: t.TempDir(),
+		alembic:      []string{"alembic"},
+		config:       "alembic.ini",
+		section:      "alembic",
+	}
+
+	tests := []struct {
+		name     string
+		alembic  []string
+		expected []string
+	}{
+		{
+			name:     "provider command",
+			expected: []string{"alembic", "-c", "alembic.ini", "-n", "alembic", "current"},
+		},
+		{
+			name:     "short options",
+			alembic:  []string{"alembic", "-c", "other.ini", "-n", "tenant"},
+			expected: []string{"alembic", "-c", "other.ini", "-n", "tenant", "current"},
+		},
+		{
+			name:     "attached short options",
+			alembic:  []string{"alembic", "-cother.ini", "-ntenant"},
+			expected: []string{"alembic", "-cother.ini", "-ntenant", "current"},
+		},
+		{
+			name:     "long options",
+			alembic:  []string{"alembic", "--config", "other.ini", "--name", "tenant"},
+			expected: []string{"alembic", "--config", "other.ini", "--name", "tenant", "current"},
+		},
+		{
+			name:     "long options with values",
+			alembic:  []string{"alembic", "--config=other.ini", "--name=tenant"},
+			expected: []string{"alembic", "--config=other.ini", "--name=tenant", "current"},
+		},
+		{
+			name:     "config only",
+			alembic:  []string{"python", "-m", "alembic", "--config=other.ini"},
+			expected: []string{"python", "-m", "alembic", "--config=other.ini", "-n", "alembic", "current"},
+		},
+		{
+			name:     "section only",
+			alembic:  []string{"alembic", "-n", "tenant"},
+			expected: []string{"alembic", "-n", "tenant", "-c", "alembic.ini", "current"},
+		},
+		{
+			name:     "wrapper options",
+			alembic:  []string{"nice", "-n", "10", "sudo", "-n", "-c", "x", "/usr/local/bin/alembic"},
+			expected: []string{"nice", "-n", "10", "sudo", "-n", "-c", "x", "/usr/local/bin/alembic", "-c", "alembic.ini", "-n", "alembic", "current"},
+		},
+		{
+			name:     "wrapper and alembic options",
+			alembic:  []string{"nice", "-n", "10", "alembic", "--name=tenant"},
+			expected: []string{"nice", "-n", "10", "alembic", "--name=tenant", "-c", "alembic.ini", "current"},
+		},
+		{
+			name:     "python module",
+			alembic:  []string{"python", "-c", "x", "-m", "alembic", "-ctenant.ini"},
+			expected: []string{"python", "-c", "x", "-m", "alembic", "-ctenant.ini", "-n", "alembic", "current"},
+		},
+		{
+			name:     "extra values",
+			alembic:  []string{"alembic", "-x", "-cfoo", "-x", "--name=x"},
+			expected: []string{"alembic", "-x", "-cfoo", "-x", "--name=x", "-c", "alembic.ini", "-n", "alembic", "current"},
+		},
+		{
+			name:     "unknown executable",
+			alembic:  []string{"./migrate.sh", "-c", "other.ini"},
+			expected: []string{"./migrate.sh", "-c", "other.ini", "-c", "alembic.ini", "-n", "alembic", "current"},
+		},
+		{
+			name:     "similar options",
+			alembic:  []string{"alembic", "--configuration", "--names", "-x", "config=x"},
+			expected: []string{"alembic", "--configuration", "--names", "-x", "config=x", "-c", "alembic.ini", "-n", "alembic", "current"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			alembic_command := types.List{ElemType: types.StringType, Null: true}
+			if test.alembic != nil {
+				alembic_command = types.List{ElemType: types.StringType}
+				for _, arg := range test.alembic {
+					alembic_command.Elems = append(alembic_command.Elems, types.String{Value: arg})
+				}
+			}
+
+			proc, diags := buildAlembicCommand(context.Background(), p, alembic_command, types.Map{ElemType: types.StringType, Null: true}, types.Map{ElemType: types.StringType, Null: true}, "current")
+			if diags.HasError() {
+				t.Fatal(diags)
+			}
+
+			if !reflect.DeepEqual(proc.Args, test.expected) {
+				t.Fatalf("expected %v, got %v", test.expected, proc.Args)
+			}
+		})
+	}
+
+	// The provider's command is never modified
+	if !reflect.DeepEqual(p.alembic, []string{"alembic"}) {
+		t.Fatalf("the provider command was modified: %v", p.alembic)
+	}
+}
+
+// fakeAlembic writes an executable named alembic which records its arguments, one per line
+func fakeAlembic(t *testing.T) (string, string) {
+	t.Helper()
+	return fakeAlembicOutput(t, "")
+}
+
+// fakeAlembicOutput writes an executable like fakeAlembic, which also prints the given output
+// (e.g. the SQL of offline mode)
+func fakeAlembicOutput(t *testing.T, stdout string) (string, string) {
+	t.Helper()
+
+	dir := t.TempDir()
+	executable := filepath.Join(dir, "alembic")
+	output := filepath.Join(dir, "args")
+
+	if err := os.WriteFile(filepath.Join(dir, "stdout"), []byte(stdout), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	script := "#!/bin/sh\nprintf '%s\\n' \"$@\" >> '" + output + "'\ncat '" + filepath.Join(dir, "stdout") + "'\n"
+	if err := os.WriteFile(executable, []byte(script), 0700); err != nil {
+		t.Fatal(err)
+	}
+
+	return executable, output
+}
+
+func TestRunMigration(t *testing.T) {
+	tests := []struct {
+		name      string
+		command   string
+		revisions []string
+		tag       types.String
+		expected  []string
+	}{
+		{
+			name:      "upgrade",
+			command:   "upgrade",
+			revisions: []string{"main@head"},
+			tag:       types.String{Null: true},
+			expected:  []string{"-c", "alembic.ini", "-n", "alembic", "upgrade", "main@head"},
+		},
+		{
+			name:      "stamp several revisions at once",
+			command:   "stamp",
+			revisions: []string{mainHead, featureHead},
+			tag:       types.String{Value: "release"},
+			expected:  []string{"-c", "alembic.ini", "-n", "alembic", "stamp", "--tag", "release", mainHead, featureHead},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			executable, output := fakeAlembic(t)
+			p := alembicProvider{
+				project_root: t.TempDir(),
+				alembic:      []string{executable},
+				config:       "alembic.ini",
+				section:      "alembic",
+			}
+
+			diags := runMigration(context.Background(), p, types.List{ElemType: types.StringType, Null: true}, types.Map{ElemType: types.StringType, Null: true}, types.Map{ElemType: types.StringType, Null: true}, test.tag, test.command, test.revisions...)
+			if diags.HasError() {
+				t.Fatal(diags)
+			}
+
+			contents, err := os.ReadFile(output)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			// alembic runs exactly once
+			if args := strings.Split(strings.TrimSuffix(string(contents), "\n"), "\n"); !reflect.DeepEqual(args, test.expected) {
+				t.Fatalf("expected %v, got %v", test.expected, args)
+			}
+		})
+	}
+}
+
+func TestAlembicCommandConfig(t *testing.T) {
+	root := testProject(t)
+
+	// The project's default configuration does not point at the migration scripts, so only
+	// the configuration named by the alembic command finds them
+	if err := os.Rename(filepath.Join(root, "alembic.ini"), filepath.Join(root, "audit.ini")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(root, "alembic.ini"), []byte("[alembic]\nscript_location = missing\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	python, record := fakePython(t, `{"current": [{"revision": "`+mainHead+`", "is_head": true, "engine": ""}]}`, 0)
+	alembic_command := types.List{ElemType: types.StringType, Elems: []attr.Value{
+		types.String{Value: filepath.Join(filepath.Dir(python), "alembic")},
+		types.String{Value: "-c"},
+		types.String{Value: "audit.ini"},
+	}}
+
+	p, diags := alembicProvider{
+		project_root: root,
+		alembic:      []string{"alembic"},
+		config:       "alembic.ini",
+		section:      "alembic",
+	}.withConfig(context.Background(), alembic_command, types.String{Null: true}, types.String{Null: true})
+	if diags.HasError() {
+		t.Fatal(diags)
+	}
+
+	graph, diags := readRevisionGraph(context.Background(), p, alembic_command, types.Map{ElemType: types.StringType, Null: true})
+	if diags.HasError() {
+		t.Fatal(diags)
+	}
+	if len(graph.revisions) != len(testGraph(t).revisions) {
+		t.Fatalf("expected the revisions of audit.ini, got %v", graph.order)
+	}
+
+	current, diags := readCurrentRevisions(context.Background(), p, alembic_command, types.Map{ElemType: types.StringType, Null: true}, types.Map{ElemType: types.StringType, Null: true}, types.String{Null: true})
+	if diags.HasError() {
+		t.Fatal(diags)
+	}
+	if ids := currentRevisionIDs(current); !reflect.DeepEqual(ids, []string{mainHead}) {
+		t.Fatalf("expected %v, got %v", []string{mainHead}, ids)
+	}
+
+	// The helper runs with the interpreter next to alembic, using the same configuration
+	contents, err := os.ReadFile(record)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSuffix(string(contents), "\n"), "\n")
+	if expected := []string{"-c", "audit.ini", "-n", "alembic", "current"}; !reflect.DeepEqual(lines[1:len(lines)-1], expected) {
+		t.Fatalf("expected arguments %v, got %v", expected, lines[1:len(lines)-1])
+	}
+}
+
+func TestReadCurrentRevisionsSource(t *testing.T) {
+	tests := []struct {
+		name         string
+		database_url string
+		provider_url string
+		databases    map[string]string
+		helper       bool
+	}{
+		{name: "resource database", database_url: "sqlite:///app.db"},
+		{name: "provider database", provider_url: "sqlite:///app.db"},
+		{name: "multidb databases", databases: map[string]string{"engine1": "sqlite:///app.db"}},
+		{name: "no database", helper: true},
+		{name: "unsupported resource database", database_url: "mssql+pyodbc://user@dsn", helper: true},
+		{name: "unsupported provider database", provider_url: "oracle://user@host/db", helper: true},
+		{name: "unsupported multidb database", databases: map[string]string{"engine1": "sqlite:///app.db", "engine2": "mssql://user@host/db"}, helper: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			// The helper reports a revision the database is not stamped with, so the source
+			// of the revisions is apparent
+			python, _ := fakePython(t, `{"current": [{"revision": "`+mainBase+`", "is_head": false, "engine": ""}]}`, 0)
+			p := testProvider(t)
+			p.python = []string{python}
+			p.database_url = test.provider_url
+			p.databases = test.databases
+			testDatabase(t, p.project_root, "app.db", mainHead)
+
+			database_url := types.String{Null: true}
+			if test.database_url != "" {
+				database_url = types.String{Value: test.database_url}
+			}
+
+			current, diags := readCurrentRevisions(context.Background(), p, types.List{ElemType: types.StringType, Null: true}, types.Map{ElemType: types.StringType, Null: true}, types.Map{ElemType: types.StringType, Null: true}, database_url)
+			if diags.HasError() {
+				t.Fatal(diags)
+			}
+
+			expected := []string{mainHead}
+			if test.helper {
+				expected = []string{mainBase}
+			}
+			if ids := currentRevisionIDs(current); !reflect.DeepEqual(ids, expected) {
+				t.Fatalf("expected %v, got %v", expected, ids)
+			}
+		})
+	}
+}
+
+// testProject writes an alembic project holding the revisions of testGraph, returning the
+// project root
+func testProject(t *testing.T) string {
+	t.Helper()
+
+	root := t.TempDir()
+	files := map[string]string{
+		"alembic.ini": "[alembic]\nscript_location = migrations\n",
+	}
+
+	for _, revision := range testGraph(t).revisions {
+		down_revision := "None"
+		if len(revision.DownRevisions) > 0 {
+			down_revision = fmt.Sprintf("%q", revision.DownRevisions[0])
+		}
+		branch_labels := "None"
+		if revision.Revision == mainBase || revision.Revision == featureBase {
+			branch_labels = fmt.Sprintf("(%q,)", revision.BranchLabels[0])
+		}
+
+		files["migrations/versions/"+revision.Revision+".py"] = fmt.Sprintf(
+			"\"\"\"%v\n\nRevision ID: %v\nCreate Date: 2022-09-01 12:00:00.000000\n\"\"\"\nrevision = %q\ndown_revision = %v\nbranch_labels = %v\n",
+			revision.Message, revision.Revision, revision.Revision, down_revision, branch_labels,
+		)
+	}
+
+	for name, contents := range files {
+		path := filepath.Join(root, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(contents), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	return root
+}
+
+func TestValidateTarget(t *testing.T) {
+	p := alembicProvider{
+		project_root: testProject(t),
+		alembic:      []string{"alembic"},
+		config:       "alembic.ini",
+		section:      "alembic",
+	}
+
+	tests := []struct {
+		target  string
+		err     string
+		suggest []string
+	}{
+		{target: "heads"},
+		{target: "main@head"},
+		{target: "27c6"},
+		{target: "main@head,feature@head"},
+		{target: "-1"},
+		{target: "feature@+1"},
+		{target: "head", err: "multiple heads are present"},
+		{target: "27c"},
+		{target: "27C", err: "no such revision or branch '27C'", suggest: []string{mainThird + " (add orders)"}},
+		{target: "mian@head", err: "no such branch: 'mian'"},
+		{target: "featur", err: "no such revision or branch 'featur'", suggest: []string{"feature"}},
+		{target: "ORDERS", err: "no such revision or branch 'ORDERS'", suggest: []string{mainHead + " (index orders)", mainThird + " (add orders)"}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.target, func(t *testing.T) {
+			diags := validateTarget(context.Background(), p, types.List{ElemType: types.StringType, Null: true}, types.Map{ElemType: types.StringType, Null: true}, path.Root("target"), test.target)
+			if test.err == "" {
+				if diags.HasError() {
+					t.Fatalf("unexpected error: %v", diags)
+				}
+				return
+			}
+
+			if !diags.HasError() {
+				t.Fatalf("expected error containing %q", test.err)
+			}
+
+			detail := diags[0].Detail()
+			if !strings.Contains(detail, test.err) {
+				t.Fatalf("expected error containing %q, got %q", test.err, detail)
+			}
+			for _, suggestion := range test.suggest {
+				if !strings.Contains(detail, "Did you mean one of these?") || !strings.Contains(detail, "  - "+suggestion) {
+					t.Fatalf("expected suggestion %q, got %q", suggestion, detail)
+				}
+			}
+		})
+	}
+
+	// Projects which can not be read are left for alembic to report
+	p.project_root = t.TempDir()
+	if diags := validateTarget(context.Background(), p, types.List{ElemType: types.StringType, Null: true}, types.Map{ElemType: types.StringType, Null: true}, path.Root("target"), "missing"); diags.HasError() {
+		t.Fatalf("unexpected error: %v", diags)
+	}
+}
+
+func TestSuggestions(t *testing.T) {
+	graph := testGraph(t)
+
+	tests := []struct {
+		spec     string
+		expected []string
+	}{
+		{spec: "ae1", expected: []string{mainSecond}},
+		{spec: "main@27c", expected: []string{mainThird}},
+		{spec: "27c+1", expected: []string{mainThird}},
+		{spec: "featur", expected: []string{"feature"}},
+		{spec: "F5A0", expected: []string{featureHead, featureBase}},
+		{spec: "orders", expected: []string{mainHead, mainThird}},
+		{spec: "zzz", expected: nil},
+	}
+
+	for _, test := range tests {
+		t.Run(test.spec, func(t *testing.T) {
+			if result := graph.suggestions(test.spec); !reflect.DeepEqual(result, test.expected) {
+				t.Fatalf("expected %v, got %v", test.expected, result)
+			}
+		})
+	}
+}
+
+// testDatabase creates a SQLite database within the project root stamped with the given
+// revisions, returning its URL
+func testDatabase(t *testing.T, root string, name string, revisions ...string) string {
+	t.Helper()
+
+	db, err := sql.Open("sqlite", filepath.Join(root, name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	if _, err := db.Exec("CREATE TABLE alembic_version (version_num VARCHAR(32) NOT NULL PRIMARY KEY)"); err != nil {
+		t.Fatal(err)
+	}
+	for _, revision := range revisions {
+		if _, err := db.Exec("INSERT INTO alembic_version VALUES (?)", revision); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	return "sqlite:///" + name
+}
+
+// revisionIDs returns the IDs of a list of revisions
+func revisionIDs(revisions []alembicRevision) []string {
+	ids := []string{}
+	for _, revision := range revisions {
+		ids = append(ids, revision.Revision)
+	}
+	return ids
+}
+
+func TestUpgradePlanCommand(t *testing.T) {
+	graph := testGraph(t)
+	revisions := func(ids ...string) []alembicRevision {
+		result := []alembicRevision{}
+		for _, id := range ids {
+			result = append(result, *graph.revisions[id])
+		}
+		return result
+	}
+
+	tests := []struct {
+		name            string
+		plan            upgradePlan
+		target          string
+		allow_downgrade bool
+		command         string
+		revisions       []string
+		err             string
+	}{
+		{
+			name:      "upgrade",
+			plan:      upgradePlan{Current: []string{mainSecond}, Target: []string{mainHead}, Pending: revisions(mainThird, mainHead), Reverted: revisions()},
+			target:    "main@head",
+			command:   "upgrade",
+			revisions: []string{"main@head"},
+		},
+		{
+			name:      "up to date",
+			plan:      upgradePlan{Current: []string{mainHead}, Target: []string{mainHead}, Pending: revisions(), Reverted: revisions()},
+			target:    "main@head",
+			command:   "upgrade",
+			revisions: []string{"main@head"},
+		},
+		{
+			name:   "downgrade without allow_downgrade",
+			plan:   upgradePlan{Current: []string{mainHead}, Target: []string{mainSecond}, Pending: revisions(), Reverted: revisions(mainHead, mainThird)},
+			target: "ae10",
+			err:    "target would downgrade the database: Moving the database from " + mainHead + " to 'ae10' would revert the following revisions:\n  - " + mainHead + " (index orders)\n  - " + mainThird + " (add orders)",
+		},
+		{
+			name:            "downgrade",
+			plan:            upgradePlan{Current: []string{mainHead}, Target: []string{mainSecond}, Pending: revisions(), Reverted: revisions(mainHead, mainThird)},
+			target:          "ae10",
+			allow_downgrade: true,
+			command:         "downgrade",
+			revisions:       []string{mainSecond},
+		},
+		{
+			name:            "relative downgrade",
+			plan:            upgradePlan{Current: []string{mainHead}, Target: []string{mainThird}, Pending: revisions(), Reverted: revisions(mainHead)},
+			target:          "-1",
+			allow_downgrade: true,
+			command:         "downgrade",
+			revisions:       []string{mainThird},
+		},
+		{
+			name:            "downgrade to base",
+			plan:            upgradePlan{Current: []string{mainSecond}, Target: []string{}, Pending: revisions(), Reverted: revisions(mainSecond, mainBase)},
+			target:          "base",
+			allow_downgrade: true,
+			command:         "downgrade",
+			revisions:       []string{"base"},
+		},
+		{
+			name:            "upgrade and downgrade",
+			plan:            upgradePlan{Current: []string{mainHead}, Target: []string{featureHead}, Pending: revisions(featureBase, featureHead), Reverted: revisions(mainHead)},
+			target:          featureHead,
+			allow_downgrade: true,
+			err:             "target requires both an upgrade and a downgrade",
+		},
+		{
+			name:      "upgrade several targets",
+			plan:      upgradePlan{Current: []string{}, Target: []string{mainHead, featureHead}, Pending: revisions(mainBase, mainSecond, mainThird, mainHead, featureBase, featureHead), Reverted: revisions()},
+			target:    "main@head,feature@head",
+			command:   "upgrade",
+			revisions: []string{mainHead, featureHead},
+		},
+		{
+			name:            "downgrade one of several targets",
+			plan:            upgradePlan{Current: []string{mainHead, featureHead}, Target: []string{mainSecond, featureHead}, Pending: revisions(), Reverted: revisions(mainHead, mainThird)},
+			target:          "ae10,feature@head",
+			allow_downgrade: true,
+			command:         "downgrade",
+			revisions:       []string{mainSecond},
+		},
+		{
+			name:            "upgrade one target while downgrading another",
+			plan:            upgradePlan{Current: []string{mainHead, featureBase}, Target: []string{mainSecond, featureHead}, Pending: revisions(featureHead), Reverted: revisions(mainHead, mainThird)},
+			target:          "ae10,feature@head",
+			allow_downgrade: true,
+			err:             "target requires both an upgrade and a downgrade",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			command, revisions, diags := test.plan.command(test.target, test.allow_downgrade)
+
+			if test.err != "" {
+				if !diags.HasError() || !strings.HasPrefix(diags[0].Summary()+": "+diags[0].Detail(), test.err) {
+					t.Fatalf("expected an error starting with %q, got %v", test.err, diags)
+				}
+				return
+			}
+			if diags.HasError() {
+				t.Fatal(diags)
+			}
+			if command != test.command || !reflect.DeepEqual(revisions, test.revisions) {
+				t.Fatalf("expected %v %v, got %v %v", test.command, test.revisions, command, revisions)
+			}
+		})
+	}
+}
+
+func TestResolveUpgrade(t *testing.T) {
+	tests := []struct {
+		name     string
+		current  []string
+		target   string
+		resolved []string
+		pending  []string
+		reverted []string
+		err      string
+	}{
+		{
+			name:     "upgrade a branch",
+			current:  []string{mainSecond},
+			target:   "main@head",
+			resolved: []string{mainHead},
+			pending:  []string{mainThird, mainHead},
+			reverted: []string{},
+		},
+		{
+			name:     "upgrade from base",
+			target:   "feature@head",
+			resolved: []string{featureHead},
+			pending:  []string{featureBase, featureHead},
+			reverted: []string{},
+		},
+		{
+			name:     "downgrade",
+			current:  []string{mainHead},
+			target:   "ae10",
+			resolved: []string{mainSecond},
+			pending:  []string{},
+			reverted: []string{mainHead, mainThird},
+		},
+		{
+			name:     "relative downgrade",
+			current:  []string{mainHead},
+			target:   "-2",
+			resolved: []string{mainSecond},
+			pending:  []string{},
+			reverted: []string{mainHead, mainThird},
+		},
+		{
+			name:     "downgrade to base",
+			current:  []string{mainSecond},
+			target:   "base",
+			resolved: []string{},
+			pending:  []string{},
+			reverted: []string{mainSecond, mainBase},
+		},
+		{
+			name:     "up to date",
+			current:  []string{mainHead},
+			target:   "main@head",
+			resolved: []string{mainHead},
+			pending:  []string{},
+			reverted: []string{},
+		},
+		{
+			name:     "several targets",
+			current:  []string{mainSecond},
+			target:   "main@head,feature@head",
+			resolved: []string{mainHead, featureHead},
+			pending:  []string{featureBase, featureHead, mainThird, mainHead},
+			reverted: []string{},
+		},
+		{
+			name:     "several targets moving in different directions",
+			current:  []string{mainHead, featureBase},
+			target:   "ae10,feature@head",
+			resolved: []string{mainSecond, featureHead},
+			pending:  []string{featureHead},
+			reverted: []string{mainHead, mainThird},
+		},
+		{
+			name:    "unknown target",
+			current: []string{mainHead},
+			target:  "missing",
+			err:     "failed resolving target revision 'missing'",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			p := testProvider(t)
+			database_url := testDatabase(t, p.project_root, "app.db", test.current...)
+
+			plan, diags := resolveUpgrade(context.Background(), p, types.List{ElemType: types.StringType, Null: true}, types.Map{ElemType: types.StringType, Null: true}, types.Map{ElemType: types.StringType, Null: true}, types.String{Value: database_url}, test.target)
+
+			if test.err != "" {
+				if !diags.HasError() || diags[0].Summary() != test.err {
+					t.Fatalf("expected error %q, got %v", test.err, diags)
+				}
+				return
+			}
+			if diags.HasError() {
+				t.Fatal(diags)
+			}
+
+			if !reflect.DeepEqual(plan.Target, test.resolved) {
+				t.Fatalf("expected target %v, got %v", test.resolved, plan.Target)
+			}
+			if pending := revisionIDs(plan.Pending); !reflect.DeepEqual(pending, test.pending) {
+				t.Fatalf("expected pending %v, got %v", test.pending, pending)
+			}
+			if reverted := revisionIDs(plan.Reverted); !reflect.DeepEqual(reverted, test.reverted) {
+				t.Fatalf("expected reverted %v, got %v", test.reverted, reverted)
+			}
+		})
+	}
+}
+
+func TestEngineRevisions(t *testing.T) {
+	tests := []struct {
+		name      string
+		databases map[string]string
+		revisions []currentRevision
+		expected  map[string][]string
+	}{
+		{
+			name:     "unstamped database",
+			expected: map[string][]string{defaultEngine: {}},
+		},
+		{
+			name:      "single database",
+			revisions: []currentRevision{{Revision: mainHead}, {Revision: featureHead}},
+			expected:  map[string][]string{defaultEngine: {mainHead, featureHead}},
+		},
+		{
+			name:      "multidb project",
+			databases: map[string]string{"engine1": "sqlite:///one.db", "engine2": "sqlite:///two.db", "engine3": "sqlite:///three.db"},
+			revisions: []currentRevision{
+				{Revision: mainHead, Engine: "engine1"},
+				{Revision: mainSecond, Engine: "engine2"},
+				{Revision: featureHead, Engine: "engine2"},
+			},
+			expected: map[string][]string{
+				"engine1": {mainHead},
+				"engine2": {mainSecond, featureHead},
+				"engine3": {},
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			engines := engineRevisions(alembicProvider{databases: test.databases}, test.revisions)
+			if !reflect.DeepEqual(engines, test.expected) {
+				t.Fatalf("expected %v, got %v", test.expected, engines)
+			}
+		})
+	}
+}
+
+func TestUpgradeRevisionState(t *testing.T) {
+	tests := []struct {
+		name     string
+		state    string
+		expected string
+		err      string
+	}{
+		{
+			name:     "revision",
+			state:    `{"id":"abc","revision":"` + mainHead + `","target":"head"}`,
+			expected: `{"id":"abc","revision":{"default":"` + mainHead + `"},"target":"head"}`,
+		},
+		{
+			name:     "unknown revision",
+			state:    `{"id":"abc","revision":null,"target":"head"}`,
+			expected: `{"id":"abc","revision":null,"target":"head"}`,
+		},
+		{
+			name:  "invalid state",
+			state: `{"revision":`,
+			err:   "failed reading the previous resource state",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			req := resource.UpgradeStateRequest{RawState: &tfprotov6.RawState{JSON: []byte(test.state)}}
+			var resp resource.UpgradeStateResponse
+
+			upgradeRevisionState(context.Background(), req, &resp)
+
+			if test.err != "" {
+				if !resp.Diagnostics.HasError() || resp.Diagnostics[0].Summary() != test.err {
+					t.Fatalf("expected error %q, got %v", test.err, resp.Diagnostics)
+				}
+				return
+			}
+			if resp.Diagnostics.HasError() {
+				t.Fatal(resp.Diagnostics)
+			}
+			if string(resp.DynamicValue.JSON) != test.expected {
+				t.Fatalf("expected %v, got %s", test.expected, resp.DynamicValue.JSON)
+			}
+		})
+	}
+}
+
+func TestReadStateMultidb(t *testing.T) {
+	tests := []struct {
+		name    string
+		engine1 []string
+		engine2 []string
+		in_sync bool
+	}{
+		{name: "every engine at the target", engine1: []string{mainHead}, engine2: []string{mainHead}, in_sync: true},
+		{name: "one engine behind", engine1: []string{mainHead}, engine2: []string{mainSecond}},
+		{name: "one engine unstamped", engine1: []string{mainHead}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			p := testProvider(t)
+			p, diags := p.withDatabases(context.Background(), types.Map{ElemType: types.StringType, Elems: map[string]attr.Value{
+				"engine1": types.String{Value: testDatabase(t, p.project_root, "one.db", test.engine1...)},
+				"engine2": types.String{Value: testDatabase(t, p.project_root, "two.db", test.engine2...)},
+			}})
+			if diags.HasError() {
+				t.Fatal(diags)
+			}
+
+			state, diags := doReadState(
+				context.Background(), p,
+				types.List{ElemType: types.StringType, Null: true},
+				types.String{Null: true},
+				types.Object{Null: true},
+				types.Object{Null: true},
+				types.List{ElemType: types.StringType, Null: true},
+				types.Map{ElemType: types.StringType, Null: true},
+				types.Map{ElemType: types.StringType, Null: true},
+				types.String{Null: true},
+				"main@head",
+				types.List{ElemType: types.StringType, Null: true},
+			)
+			if diags.HasError() {
+				t.Fatal(diags)
+			}
+
+			expected := map[string][]string{"engine1": append([]string{}, test.engine1...), "engine2": append([]string{}, test.engine2...)}
+			if !reflect.DeepEqual(state.Engines, expected) {
+				t.Fatalf("expected engines %v, got %v", expected, state.Engines)
+			}
+			if state.InSync != test.in_sync {
+				t.Fatalf("expected in sync %v, got %v", test.in_sync, state.InSync)
+			}
+			if !reflect.DeepEqual(state.Target, []string{mainHead}) {
+				t.Fatalf("unexpected target %v", state.Target)
+			}
+		})
+	}
+}
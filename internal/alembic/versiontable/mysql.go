@@ -0,0 +1,60 @@
+package versiontable
+
+import (
+	"context"
+	"database/sql"
+	"net/url"
+	"strings"
+
+	"github.com/go-sql-driver/mysql"
+)
+
+func init() {
+	Register("mysql", mysqlDialect{})
+	Register("mariadb", mysqlDialect{})
+}
+
+type mysqlDialect struct{}
+
+// Open connects to a MySQL or MariaDB database. The "unix_socket" query parameter used
+// by SQLAlchemy selects a unix socket instead of TCP.
+func (mysqlDialect) Open(u *url.URL, _ string) (*sql.DB, error) {
+	config := mysql.NewConfig()
+	config.Net = "tcp"
+	config.Addr = u.Host
+	config.DBName = strings.TrimPrefix(u.Path, "/")
+
+	if u.User != nil {
+		config.User = u.User.Username()
+		config.Passwd, _ = u.User.Password()
+	}
+
+	if socket := u.Query().Get("unix_socket"); socket != "" {
+		config.Net = "unix"
+		config.Addr = socket
+	} else if u.Port() == "" && u.Host != "" {
+		config.Addr = u.Host + ":3306"
+	}
+
+	connector, err := mysql.NewConnector(config)
+	if err != nil {
+		return nil, err
+	}
+
+	return sql.OpenDB(connector), nil
+}
+
+func (mysqlDialect) TableExists(ctx context.Context, db *sql.DB, table string, schema string) (bool, error) {
+	var count int
+	err := db.QueryRowContext(
+		ctx,
+		"SELECT COUNT(*) FROM information_schema.tables WHERE table_name = ? AND table_schema = COALESCE(NULLIF(?, ''), DATABASE())",
+		table,
+		schema,
+	).Scan(&count)
+	return count > 0, err
+}
+
+func (mysqlDialect) Quote(identifier string) string {
+	return quoteIdentifier(identifier, "`")
+}
@@ -0,0 +1,39 @@
+package versiontable
+
+import (
+	"context"
+	"database/sql"
+	"net/url"
+
+	_ "github.com/lib/pq"
+)
+
+func init() {
+	Register("postgresql", postgresDialect{})
+	Register("postgres", postgresDialect{})
+}
+
+type postgresDialect struct{}
+
+// Open connects to a PostgreSQL database. Query parameters understood by libpq (e.g.
+// "sslmode" or "host" for unix sockets) are passed through unchanged.
+func (postgresDialect) Open(u *url.URL, _ string) (*sql.DB, error) {
+	dsn := *u
+	dsn.Scheme = "postgres"
+	return sql.Open("postgres", dsn.String())
+}
+
+func (postgresDialect) TableExists(ctx context.Context, db *sql.DB, table string, schema string) (bool, error) {
+	var count int
+	err := db.QueryRowContext(
+		ctx,
+		"SELECT COUNT(*) FROM information_schema.tables WHERE table_name = $1 AND table_schema = COALESCE(NULLIF($2, ''), current_schema())",
+		table,
+		schema,
+	).Scan(&count)
+	return count > 0, err
+}
+
+func (postgresDialect) Quote(identifier string) string {
+	return quoteIdentifier(identifier, `"`)
+}
@@ -0,0 +1,55 @@
+package versiontable
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+
+	_ "modernc.org/sqlite"
+)
+
+func init() {
+	Register("sqlite", sqliteDialect{})
+}
+
+type sqliteDialect struct{}
+
+// Open connects to a SQLite database read-only. SQLAlchemy URLs have the form
+// "sqlite:///relative/path.db" or "sqlite:////absolute/path.db".
+func (sqliteDialect) Open(u *url.URL, root string) (*sql.DB, error) {
+	path := strings.TrimPrefix(u.Path, "/")
+	if path == "" || path == ":memory:" {
+		// A new in-memory database is never stamped
+		return sql.Open("sqlite", ":memory:")
+	}
+
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(root, path)
+	}
+
+	if _, err := os.Stat(path); err != nil {
+		return nil, fmt.Errorf("unable to open sqlite database: %v", err)
+	}
+
+	dsn := url.URL{Scheme: "file", Path: path, RawQuery: "mode=ro"}
+	return sql.Open("sqlite", dsn.String())
+}
+
+func (d sqliteDialect) TableExists(ctx context.Context, db *sql.DB, table string, schema string) (bool, error) {
+	master := "sqlite_master"
+	if schema != "" {
+		master = d.Quote(schema) + "." + master
+	}
+
+	var count int
+	err := db.QueryRowContext(ctx, "SELECT COUNT(*) FROM "+master+" WHERE type = 'table' AND name = ?", table).Scan(&count)
+	return count > 0, err
+}
+
+func (sqliteDialect) Quote(identifier string) string {
+	return quoteIdentifier(identifier, `"`)
+}
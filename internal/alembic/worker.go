@@ -0,0 +1,267 @@
+package alembic
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"sync"
+
+	"github.com/hashicorp/terraform-plugin-framework/diag"
+	"github.com/hashicorp/terraform-plugin-framework/types"
+	"github.com/hashicorp/terraform-plugin-log/tflog"
+)
+
+// Amount of standard error output kept from the worker for diagnostics
+const workerStderrLimit = 64 * 1024
+
+// workerRequest is a single JSON-lines request sent to the helper script in worker mode
+type workerRequest struct {
+	Command     string            `json:"command"`
+	Args        []string          `json:"args"`
+	Config      string            `json:"config"`
+	Section     string            `json:"section"`
+	Extra       []string          `json:"x"`
+	Tag         string            `json:"tag,omitempty"`
+	SQL         bool              `json:"sql,omitempty"`
+	Databases   map[string]string `json:"databases,omitempty"`
+	Environment map[string]string `json:"environment"`
+}
+
+// alembicWorker is a long-lived helper process shared by everything using one provider
+// configuration. Python and the modules imported by env.py are only loaded once, and
+// each command is sent to it as one line of JSON. Requests are handled one at a time.
+type alembicWorker struct {
+	python []string
+	root   string
+
+	mutex  sync.Mutex
+	dir    string
+	proc   *exec.Cmd
+	stdin  io.WriteCloser
+	stdout *bufio.Reader
+	stderr *boundedBuffer
+}
+
+func newAlembicWorker(python []string, root string) *alembicWorker {
+	return &alembicWorker{
+		python: python,
+		root:   root,
+	}
+}
+
+// start launches the worker process. The caller must hold the mutex.
+func (w *alembicWorker) start(ctx context.Context) error {
+	dir, err := os.MkdirTemp("", "terraform-provider-alembic-")
+	if err != nil {
+		return err
+	}
+
+	helper := filepath.Join(dir, "helper.py")
+	if err := os.WriteFile(helper, helperSource, 0600); err != nil {
+		os.RemoveAll(dir)
+		return err
+	}
+
+	args := append(append([]string{}, w.python...), helper, "serve")
+
+	// The worker outlives any single request, so it is not bound to the request context.
+	// It exits by itself once its standard input is closed.
+	proc := exec.Command(args[0], args[1:]...)
+	proc.Dir = w.root
+	proc.Env = []string{fmt.Sprintf("PATH=%v", os.Getenv("PATH"))}
+
+	w.stderr = newBoundedBuffer(workerStderrLimit)
+	proc.Stderr = w.stderr
+
+	stdin, err := proc.StdinPipe()
+	if err != nil {
+		os.RemoveAll(dir)
+		return err
+	}
+
+	stdout, err := proc.StdoutPipe()
+	if err != nil {
+		os.RemoveAll(dir)
+		return err
+	}
+
+	if err := proc.Start(); err != nil {
+		os.RemoveAll(dir)
+		return err
+	}
+
+	tflog.Debug(ctx, "started alembic worker", map[string]interface{}{"python": w.python, "pid": proc.Process.Pid})
+
+	w.dir = dir
+	w.proc = proc
+	w.stdin = stdin
+	w.stdout = bufio.NewReader(stdout)
+
+	return nil
+}
+
+// stop terminates the worker process, if it is running. The caller must hold the mutex.
+func (w *alembicWorker) stop() {
+	if w.proc == nil {
+		return
+	}
+
+	w.stdin.Close()
+	w.proc.Process.Kill()
+	w.proc.Wait()
+	os.RemoveAll(w.dir)
+
+	w.proc = nil
+	w.stdin = nil
+	w.stdout = nil
+}
+
+// Close stops the worker process
+func (w *alembicWorker) Close() {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	w.stop()
+}
+
+// run sends a single request to the worker, starting it first if needed, and returns the
+// decoded response. A worker which fails to respond is stopped, so that the next request
+// starts a fresh one.
+func (w *alembicWorker) run(ctx context.Context, request workerRequest) (*helperResult, diag.Diagnostics) {
+	var diags diag.Diagnostics
+	var result helperResult
+
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	if w.proc == nil {
+		if err := w.start(ctx); err != nil {
+			diags.AddError("failed starting the alembic worker", err.Error())
+			return nil, diags
+		}
+	}
+
+	// Only report the output produced while handling this request
+	w.stderr.Reset()
+
+	// Abandon the worker if the request is cancelled while it is busy
+	done := make(chan struct{})
+	defer close(done)
+	go func(proc *exec.Cmd) {
+		select {
+		case <-ctx.Done():
+			proc.Process.Kill()
+		case <-done:
+		}
+	}(w.proc)
+
+	line, err := json.Marshal(request)
+	if err == nil {
+		_, err = w.stdin.Write(append(line, '\n'))
+	}
+
+	var response []byte
+	if err == nil {
+		response, err = w.stdout.ReadBytes('\n')
+	}
+
+	if err == nil {
+		err = json.Unmarshal(response, &result)
+	}
+
+	if err != nil {
+		w.stop()
+		diags.AddError(
+			fmt.Sprintf("alembic worker failed during %v: %v", request.Command, err),
+			fmt.Sprintf("Standard Error:\n%v\n\n", w.stderr.String()),
+		)
+		return nil, diags
+	}
+
+	if result.Error != "" {
+		diags.AddError(
+			fmt.Sprintf("alembic %v failed: %v", request.Command, result.Error),
+			fmt.Sprintf("Standard Output:\n%v\n\nStandard Error:\n%v\n\n", result.Output, w.stderr.String()),
+		)
+		return nil, diags
+	}
+
+	return &result, diags
+}
+
+// newWorkerRequest builds a request holding the provider configuration along with the
+// extras and environment of a resource.
+func newWorkerRequest(
+	ctx context.Context,
+	p alembicProvider,
+	extra_values types.Map,
+	environment_values types.Map,
+	command string,
+	args ...string,
+) (workerRequest, diag.Diagnostics) {
+
+	request := workerRequest{
+		Command:     command,
+		Args:        args,
+		Config:      p.config,
+		Section:     p.section,
+		Databases:   p.proxyDatabases(),
+		Environment: map[string]string{},
+	}
+
+	extra, diags := extraArguments(ctx, p, extra_values)
+	if diags.HasError() {
+		return request, diags
+	}
+	request.Extra = extra
+
+	if !environment_values.Null {
+		diags.Append(environment_values.ElementsAs(ctx, &request.Environment, false)...)
+	}
+
+	if p.proxy_port != 0 {
+		request.Environment[proxyPortVariable] = strconv.Itoa(p.proxy_port)
+	}
+
+	return request, diags
+}
+
+// boundedBuffer is a concurrency safe writer which only keeps the most recent output
+type boundedBuffer struct {
+	mutex sync.Mutex
+	limit int
+	data  []byte
+}
+
+func newBoundedBuffer(limit int) *boundedBuffer {
+	return &boundedBuffer{limit: limit}
+}
+
+func (b *boundedBuffer) Write(p []byte) (int, error) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	b.data = append(b.data, p...)
+	if len(b.data) > b.limit {
+		b.data = append([]byte{}, b.data[len(b.data)-b.limit:]...)
+	}
+
+	return len(p), nil
+}
+
+func (b *boundedBuffer) String() string {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	return string(b.data)
+}
+
+func (b *boundedBuffer) Reset() {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	b.data = nil
+}
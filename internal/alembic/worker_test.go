@@ -0,0 +1,224 @@
+package alembic
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/hashicorp/terraform-plugin-framework/attr"
+	"github.com/hashicorp/terraform-plugin-framework/types"
+)
+
+// fakeWorker writes an executable standing in for python running the helper in worker
+// mode. Every request line is recorded, and the response depends on the command: "fail"
+// reports an error, "crash" exits and anything else responds with the process ID.
+func fakeWorker(t *testing.T) (string, string) {
+	t.Helper()
+
+	dir := t.TempDir()
+	executable := filepath.Join(dir, "python")
+	record := filepath.Join(dir, "requests")
+
+	script := `#!/bin/sh
+while IFS= read -r line; do
+	printf '%s\n' "$line" >> '` + record + `'
+	case "$line" in
+	*'"command":"crash"'*) echo 'worker crashed' >&2; exit 1;;
+	*'"command":"fail"'*) echo '{"error": "CommandError: failed", "output": "partial output"}';;
+	*) echo "{\"output\": \"$$\"}";;
+	esac
+done
+`
+	if err := os.WriteFile(executable, []byte(script), 0700); err != nil {
+		t.Fatal(err)
+	}
+
+	return executable, record
+}
+
+// readRequests decodes the requests received by a fake worker
+func readRequests(t *testing.T, record string) []workerRequest {
+	t.Helper()
+
+	contents, err := os.ReadFile(record)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var requests []workerRequest
+	for _, line := range strings.Split(strings.TrimSuffix(string(contents), "\n"), "\n") {
+		var request workerRequest
+		if err := json.Unmarshal([]byte(line), &request); err != nil {
+			t.Fatalf("invalid request line %q: %v", line, err)
+		}
+		requests = append(requests, request)
+	}
+
+	return requests
+}
+
+func TestAlembicWorker(t *testing.T) {
+	python, record := fakeWorker(t)
+	worker := newAlembicWorker([]string{python}, t.TempDir())
+	defer worker.Close()
+
+	ctx := context.Background()
+	request := workerRequest{
+		Command:     "upgrade",
+		Args:        []string{"main@head"},
+		Config:      "alembic.ini",
+		Section:     "alembic",
+		Extra:       []string{"tenant=acme"},
+		Tag:         "release",
+		Databases:   map[string]string{"engine1": "sqlite:///one.db"},
+		Environment: map[string]string{"APP_ENV": "test"},
+	}
+
+	// The same process handles every request
+	first, diags := worker.run(ctx, request)
+	if diags.HasError() {
+		t.Fatal(diags)
+	}
+	second, diags := worker.run(ctx, workerRequest{Command: "heads", Environment: map[string]string{}})
+	if diags.HasError() {
+		t.Fatal(diags)
+	}
+	if first.Output == "" || first.Output != second.Output {
+		t.Fatalf("expected a single worker process, got %q and %q", first.Output, second.Output)
+	}
+
+	requests := readRequests(t, record)
+	if len(requests) != 2 || !reflect.DeepEqual(requests[0], request) {
+		t.Fatalf("unexpected requests %+v", requests)
+	}
+
+	// Errors reported by the helper leave the worker running
+	_, diags = worker.run(ctx, workerRequest{Command: "fail", Environment: map[string]string{}})
+	if !diags.HasError() || diags[0].Summary() != "alembic fail failed: CommandError: failed" {
+		t.Fatalf("expected the reported error, got %v", diags)
+	}
+	if detail := diags[0].Detail(); !strings.Contains(detail, "partial output") {
+		t.Fatalf("expected the output of the request in the detail, got %q", detail)
+	}
+
+	third, diags := worker.run(ctx, workerRequest{Command: "heads", Environment: map[string]string{}})
+	if diags.HasError() {
+		t.Fatal(diags)
+	}
+	if third.Output != first.Output {
+		t.Fatalf("expected the worker to keep running, got %q and %q", first.Output, third.Output)
+	}
+
+	// A worker which stops responding is replaced by the next request. Its standard error is
+	// complete once the process has been waited for.
+	_, diags = worker.run(ctx, workerRequest{Command: "crash", Environment: map[string]string{}})
+	if !diags.HasError() || !strings.HasPrefix(diags[0].Summary(), "alembic worker failed during crash") {
+		t.Fatalf("expected the worker to fail, got %v", diags)
+	}
+	if detail := diags[0].Detail(); !strings.Contains(detail, "worker crashed") {
+		t.Fatalf("expected the standard error of the worker in the detail, got %q", detail)
+	}
+
+	fourth, diags := worker.run(ctx, workerRequest{Command: "heads", Environment: map[string]string{}})
+	if diags.HasError() {
+		t.Fatal(diags)
+	}
+	if fourth.Output == first.Output {
+		t.Fatal("expected a new worker process")
+	}
+}
+
+func TestAlembicWorkerStartFailure(t *testing.T) {
+	worker := newAlembicWorker([]string{filepath.Join(t.TempDir(), "missing")}, t.TempDir())
+	defer worker.Close()
+
+	_, diags := worker.run(context.Background(), workerRequest{Command: "heads"})
+	if !diags.HasError() || diags[0].Summary() != "failed starting the alembic worker" {
+		t.Fatalf("expected the worker to fail starting, got %v", diags)
+	}
+}
+
+func TestNewWorkerRequest(t *testing.T) {
+	p := alembicProvider{
+		config:  "alembic.ini",
+		section: "tenant",
+		extra:   map[string]string{"schema": "public"},
+	}
+	extra := types.Map{ElemType: types.StringType, Elems: map[string]attr.Value{"tenant": types.String{Value: "acme"}}}
+	environment := types.Map{ElemType: types.StringType, Elems: map[string]attr.Value{"APP_ENV": types.String{Value: "test"}}}
+
+	request, diags := newWorkerRequest(context.Background(), p, extra, environment, "stamp", mainHead, featureHead)
+	if diags.HasError() {
+		t.Fatal(diags)
+	}
+
+	expected := workerRequest{
+		Command:     "stamp",
+		Args:        []string{mainHead, featureHead},
+		Config:      "alembic.ini",
+		Section:     "tenant",
+		Extra:       []string{"schema=public", "tenant=acme"},
+		Environment: map[string]string{"APP_ENV": "test"},
+	}
+	if !reflect.DeepEqual(request, expected) {
+		t.Fatalf("expected %+v, got %+v", expected, request)
+	}
+
+	// Optional fields are left out of the request line
+	line, err := json.Marshal(workerRequest{Command: "heads", Environment: map[string]string{}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(line) != `{"command":"heads","args":null,"config":"","section":"","x":null,"environment":{}}` {
+		t.Fatalf("unexpected request line %s", line)
+	}
+}
+
+func TestBoundedBuffer(t *testing.T) {
+	buffer := newBoundedBuffer(8)
+
+	buffer.Write([]byte("abc"))
+	if buffer.String() != "abc" {
+		t.Fatalf("unexpected contents %q", buffer.String())
+	}
+
+	// Only the most recent output is kept
+	buffer.Write([]byte("defghij"))
+	if buffer.String() != "cdefghij" {
+		t.Fatalf("unexpected contents %q", buffer.String())
+	}
+
+	n, err := buffer.Write([]byte("0123456789"))
+	if n != 10 || err != nil {
+		t.Fatalf("expected the whole write to succeed, got %v, %v", n, err)
+	}
+	if buffer.String() != "23456789" {
+		t.Fatalf("unexpected contents %q", buffer.String())
+	}
+
+	buffer.Reset()
+	if buffer.String() != "" {
+		t.Fatalf("expected an empty buffer, got %q", buffer.String())
+	}
+
+	// Concurrent writers never exceed the limit
+	var wait sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wait.Add(1)
+		go func() {
+			defer wait.Done()
+			for j := 0; j < 100; j++ {
+				buffer.Write([]byte("xyz"))
+			}
+		}()
+	}
+	wait.Wait()
+	if len(buffer.String()) != 8 {
+		t.Fatalf("unexpected contents %q", buffer.String())
+	}
+}
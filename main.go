@@ -42,6 +42,9 @@ func main() {
 
 	err := providerserver.Serve(context.Background(), alembic.New(version), opts)
 
+	// Remove extracted projects and stop any helper processes
+	alembic.Shutdown()
+
 	if err != nil {
 		log.Fatal(err.Error())
 	}